@@ -0,0 +1,406 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+
+	pkg "github.com/mudler/luet/pkg/package"
+	"gopkg.in/macaron.v1"
+)
+
+const (
+	apiPrefix      = "/api/v1"
+	defaultPerPage = 50
+	maxPerPage     = 500
+)
+
+type ApiError struct {
+	Error string `json:"error"`
+}
+
+type ApiRepository struct {
+	Name        string `json:"name"`
+	Url         string `json:"url"`
+	Type        string `json:"type"`
+	Github      string `json:"github,omitempty"`
+	Description string `json:"description,omitempty"`
+	Revision    int    `json:"revision"`
+	LastUpdate  string `json:"last_update,omitempty"`
+	Packages    int    `json:"packages"`
+
+	Sync *RepositoryStatus `json:"sync,omitempty"`
+}
+
+type ApiPackageRef struct {
+	Category string `json:"category"`
+	Name     string `json:"name"`
+	Version  string `json:"version"`
+}
+
+type ApiPackageSummary struct {
+	ApiPackageRef
+	Repository  string `json:"repository"`
+	Description string `json:"description,omitempty"`
+	License     string `json:"license,omitempty"`
+	Hidden      bool   `json:"hidden,omitempty"`
+}
+
+type ApiPackageList struct {
+	Total    int                 `json:"total"`
+	Page     int                 `json:"page"`
+	PerPage  int                 `json:"per_page"`
+	Packages []ApiPackageSummary `json:"packages"`
+}
+
+type ApiPackage struct {
+	ApiPackageSummary
+	Uri            []string          `json:"uri,omitempty"`
+	Labels         map[string]string `json:"labels,omitempty"`
+	Annotations    map[string]string `json:"annotations,omitempty"`
+	Requires       []ApiPackageRef   `json:"requires"`
+	Conflicts      []ApiPackageRef   `json:"conflicts"`
+	Provides       []ApiPackageRef   `json:"provides"`
+	BuildTimestamp string            `json:"build_timestamp,omitempty"`
+	Files          []string          `json:"files"`
+}
+
+// newApiRepository returns the repository with the status of the sync.
+// The informations of the metadata are available only after the
+// first successful sync.
+func newApiRepository(snap *Snapshot, name string) ApiRepository {
+	data := snap.Data[name]
+	ans := ApiRepository{
+		Name:        name,
+		Url:         data["url"],
+		Type:        data["type"],
+		Github:      data["github"],
+		Description: data["description"],
+		Sync:        snap.GetStatus(name),
+	}
+	if r := snap.GetRepository(name); r != nil {
+		ans.Type = r.GetType()
+		ans.Revision = r.GetRevision()
+		ans.LastUpdate = r.GetLastUpdate()
+		ans.Packages = len(r.GetTree().GetDatabase().World())
+	}
+	return ans
+}
+
+func newApiPackageRefs(packs []*pkg.DefaultPackage) []ApiPackageRef {
+	ans := []ApiPackageRef{}
+	for _, p := range packs {
+		ans = append(ans, ApiPackageRef{
+			Category: p.GetCategory(),
+			Name:     p.GetName(),
+			Version:  p.GetVersion(),
+		})
+	}
+	return ans
+}
+
+func newApiPackageSummary(repo string, p pkg.Package) ApiPackageSummary {
+	return ApiPackageSummary{
+		ApiPackageRef: ApiPackageRef{
+			Category: p.GetCategory(),
+			Name:     p.GetName(),
+			Version:  p.GetVersion(),
+		},
+		Repository:  repo,
+		Description: p.GetDescription(),
+		License:     p.GetLicense(),
+		Hidden:      p.IsHidden(),
+	}
+}
+
+func sortSummaries(packs []ApiPackageSummary) {
+	sort.SliceStable(packs, func(i, j int) bool {
+		if packs[i].Category != packs[j].Category {
+			return packs[i].Category < packs[j].Category
+		}
+		if packs[i].Name != packs[j].Name {
+			return packs[i].Name < packs[j].Name
+		}
+		if packs[i].Version != packs[j].Version {
+			return packs[i].Version < packs[j].Version
+		}
+		return packs[i].Repository < packs[j].Repository
+	})
+}
+
+// paginate returns the page of the packages selected with the
+// page and per_page query parameters.
+func paginate(ctx *macaron.Context, packs []ApiPackageSummary) ApiPackageList {
+	page := ctx.QueryInt("page")
+	if page < 1 {
+		page = 1
+	}
+	perPage := ctx.QueryInt("per_page")
+	if perPage < 1 {
+		perPage = defaultPerPage
+	} else if perPage > maxPerPage {
+		perPage = maxPerPage
+	}
+
+	ans := ApiPackageList{
+		Total:    len(packs),
+		Page:     page,
+		PerPage:  perPage,
+		Packages: []ApiPackageSummary{},
+	}
+
+	start := (page - 1) * perPage
+	if start < len(packs) {
+		end := start + perPage
+		if end > len(packs) {
+			end = len(packs)
+		}
+		ans.Packages = packs[start:end]
+	}
+
+	return ans
+}
+
+func apiNotFound(ctx *macaron.Context, msg string) {
+	ctx.JSON(http.StatusNotFound, ApiError{Error: msg})
+}
+
+// registerApiRoutes registers the API routes and returns the router
+// with their documentation.
+func registerApiRoutes(m *macaron.Macaron) *ApiRouter {
+	api := NewApiRouter(m, apiPrefix)
+
+	api.Get("/repositories", ApiRouteDoc{
+		Summary:  "List the configured repositories with the status of the sync",
+		Response: []ApiRepository{},
+	}, func(ctx *macaron.Context) {
+		snap := getSnapshot()
+
+		ans := []ApiRepository{}
+		for _, name := range snap.Names {
+			ans = append(ans, newApiRepository(snap, name))
+		}
+		ctx.JSON(http.StatusOK, ans)
+	})
+
+	api.Get("/repositories/:repository", ApiRouteDoc{
+		Summary:  "Show a repository",
+		Response: ApiRepository{},
+	}, func(ctx *macaron.Context) {
+		snap := getSnapshot()
+
+		name := ctx.Params(":repository")
+		if snap.GetStatus(name) == nil {
+			apiNotFound(ctx, "Repository not found")
+			return
+		}
+		ctx.JSON(http.StatusOK, newApiRepository(snap, name))
+	})
+
+	api.Get("/repositories/:repository/packages", ApiRouteDoc{
+		Summary: "List the packages of a repository",
+		Query: []ApiParamDoc{
+			{Name: "category", Description: "Filter the packages of the category"},
+			{Name: "page", Description: "Page number, starting from 1", Type: "integer"},
+			{Name: "per_page", Description: "Packages per page (max 500)", Type: "integer"},
+		},
+		Response: ApiPackageList{},
+	}, func(ctx *macaron.Context) {
+		snap := getSnapshot()
+
+		r := snap.GetRepository(ctx.Params(":repository"))
+		if r == nil {
+			apiNotFound(ctx, "Repository not found")
+			return
+		}
+
+		category := ctx.Query("category")
+		packs := []ApiPackageSummary{}
+		for _, p := range r.GetTree().GetDatabase().World() {
+			if category == "" || p.GetCategory() == category {
+				packs = append(packs, newApiPackageSummary(r.GetName(), p))
+			}
+		}
+		sortSummaries(packs)
+
+		ctx.JSON(http.StatusOK, paginate(ctx, packs))
+	})
+
+	api.Get("/repositories/:repository/packages/:packagecategory/:packagename", ApiRouteDoc{
+		Summary:  "List the versions of a package of a repository",
+		Response: []ApiPackageSummary{},
+	}, func(ctx *macaron.Context) {
+		snap := getSnapshot()
+
+		r := snap.GetRepository(ctx.Params(":repository"))
+		if r == nil {
+			apiNotFound(ctx, "Repository not found")
+			return
+		}
+
+		packages, err := r.GetTree().GetDatabase().FindPackages(&pkg.DefaultPackage{
+			Name:     ctx.Params(":packagename"),
+			Category: ctx.Params(":packagecategory"),
+			Version:  ">=0",
+		})
+		if err != nil || len(packages) == 0 {
+			apiNotFound(ctx, "Package not found")
+			return
+		}
+		ans := []ApiPackageSummary{}
+		for _, p := range packages {
+			ans = append(ans, newApiPackageSummary(r.GetName(), p))
+		}
+		sortSummaries(ans)
+		ctx.JSON(http.StatusOK, ans)
+	})
+
+	api.Get("/repositories/:repository/packages/:packagecategory/:packagename/:packageversion", ApiRouteDoc{
+		Summary:  "Show the details of a package",
+		Response: ApiPackage{},
+	}, func(ctx *macaron.Context) {
+		snap := getSnapshot()
+
+		r := snap.GetRepository(ctx.Params(":repository"))
+		if r == nil {
+			apiNotFound(ctx, "Repository not found")
+			return
+		}
+
+		find := &pkg.DefaultPackage{
+			Name:     ctx.Params(":packagename"),
+			Category: ctx.Params(":packagecategory"),
+			Version:  ctx.Params(":packageversion"),
+		}
+		for _, a := range r.GetIndex() {
+			if a.CompileSpec.GetPackage().GetFingerPrint() != find.GetFingerPrint() {
+				continue
+			}
+
+			// We get it from compilespec which contains the build timestamp
+			p := a.CompileSpec.GetPackage()
+			ans := ApiPackage{
+				ApiPackageSummary: newApiPackageSummary(r.GetName(), p),
+				Uri:               p.GetURI(),
+				Labels:            p.GetLabels(),
+				Annotations:       p.GetAnnotations(),
+				Requires:          newApiPackageRefs(p.GetRequires()),
+				Conflicts:         newApiPackageRefs(p.GetConflicts()),
+				Provides:          newApiPackageRefs(p.GetProvides()),
+				BuildTimestamp:    p.GetBuildTimestamp(),
+				Files:             a.Files,
+			}
+			if ans.Files == nil {
+				ans.Files = []string{}
+			}
+			ctx.JSON(http.StatusOK, ans)
+			return
+		}
+
+		apiNotFound(ctx, "Package not found")
+	})
+
+	api.Get("/repositories/:repository/packages/:packagecategory/:packagename/:packageversion/dependencies", ApiRouteDoc{
+		Summary: "Show the dependency tree of a package",
+		Query: []ApiParamDoc{
+			{Name: "depth", Description: "Depth of the tree (default 3, max 10)", Type: "integer"},
+			{Name: "reverse", Description: "Return the packages that require the package", Type: "boolean"},
+		},
+		Response: DependencyNode{},
+	}, func(ctx *macaron.Context) {
+		tree, msg := dependencyTree(ctx, ctx.QueryBool("reverse"))
+		if tree == nil {
+			apiNotFound(ctx, msg)
+			return
+		}
+		ctx.JSON(http.StatusOK, tree)
+	})
+
+	api.Get("/compare/:packagecategory/:packagename", ApiRouteDoc{
+		Summary: "Compare the newest version of a package in all the repositories",
+		Query: []ApiParamDoc{
+			{Name: "base", Description: "Reference repository of the differences. Default is the first repository with the latest version"},
+		},
+		Response: PackageComparison{},
+	}, func(ctx *macaron.Context) {
+		cmp := packageComparison(ctx)
+		if cmp == nil {
+			apiNotFound(ctx, "Package not found")
+			return
+		}
+		ctx.JSON(http.StatusOK, cmp)
+	})
+
+	api.Get("/search", ApiRouteDoc{
+		Summary: "Search packages by name, description and labels in all the repositories",
+		Query: []ApiParamDoc{
+			{Name: "q", Description: "Words to search. Every word must match"},
+			{Name: "category", Description: "Exact category of the packages"},
+			{Name: "name", Description: "Exact name of the packages"},
+			{Name: "page", Description: "Page number, starting from 1", Type: "integer"},
+			{Name: "per_page", Description: "Packages per page (max 500)", Type: "integer"},
+		},
+		Response: ApiPackageList{},
+	}, func(ctx *macaron.Context) {
+		snap := getSnapshot()
+
+		category := ctx.Query("category")
+		name := ctx.Query("name")
+
+		packs := []ApiPackageSummary{}
+		if q := ctx.Query("q"); q != "" {
+			for _, r := range snap.Index.Search(q) {
+				packs = append(packs, r.ApiPackageSummary)
+			}
+		} else {
+			for _, r := range snap.Repositories {
+				for _, p := range r.GetTree().GetDatabase().World() {
+					packs = append(packs, newApiPackageSummary(r.GetName(), p))
+				}
+			}
+			sortSummaries(packs)
+		}
+
+		filtered := []ApiPackageSummary{}
+		for _, p := range packs {
+			if (category == "" || p.Category == category) &&
+				(name == "" || p.Name == name) {
+				filtered = append(filtered, p)
+			}
+		}
+
+		ctx.JSON(http.StatusOK, paginate(ctx, filtered))
+	})
+
+	api.Get("/owner", ApiRouteDoc{
+		Summary: "Find the packages that ship a file",
+		Query: []ApiParamDoc{
+			{Name: "file", Description: "Path of the file or basename if without slashes"},
+		},
+		Response: []FileOwner{},
+	}, func(ctx *macaron.Context) {
+		snap := getSnapshot()
+
+		ctx.JSON(http.StatusOK, snap.Index.Owners(ctx.Query("file")))
+	})
+
+	api.Get("/changes", ApiRouteDoc{
+		Summary: "List the latest changes of the packages, the newest first",
+		Query: []ApiParamDoc{
+			{Name: "repository", Description: "Name of the repository"},
+			{Name: "category", Description: "Category of the packages"},
+			{Name: "name", Description: "Name of the packages"},
+			{Name: "limit", Description: "Max number of changes (max 1000)", Type: "integer"},
+		},
+		Response: []Change{},
+	}, func(ctx *macaron.Context) {
+		changes, err := getChanges(changeFilterFromQuery(ctx), changesLimit(ctx))
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, ApiError{Error: err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusOK, changes)
+	})
+
+	api.ServeOpenApi("/openapi.json", "luet-package-browser API", Version)
+	return api
+}
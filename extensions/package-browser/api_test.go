@@ -0,0 +1,257 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/macaron.v1"
+)
+
+// newTestServer returns a server with the routes registered by
+// register, it's closed at the end of the test.
+func newTestServer(t *testing.T, register func(m *macaron.Macaron)) *httptest.Server {
+	m := macaron.New()
+	m.Use(macaron.Renderer(macaron.RenderOptions{
+		Funcs: []template.FuncMap{templateFuncs},
+	}))
+	register(m)
+
+	server := httptest.NewServer(m)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// getJSON requests the url and decodes the JSON body.
+func getJSON(t *testing.T, url string) (int, interface{}) {
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ans interface{}
+	if err := json.Unmarshal(data, &ans); err != nil {
+		t.Fatalf("GET %s: invalid JSON %q: %s", url, data, err)
+	}
+	return resp.StatusCode, ans
+}
+
+// checkSchema returns the differences between the value and the
+// schema of the OpenAPI document. The fields of the objects must
+// be documented.
+func checkSchema(schemas map[string]interface{}, schema map[string]interface{}, value interface{}, path string) []string {
+	if ref, ok := schema["$ref"].(string); ok {
+		name := strings.TrimPrefix(ref, "#/components/schemas/")
+		s, ok := schemas[name].(map[string]interface{})
+		if !ok {
+			return []string{fmt.Sprintf("%s: missing schema %s", path, name)}
+		}
+		return checkSchema(schemas, s, value, path)
+	}
+
+	ans := []string{}
+	switch schema["type"] {
+	case "object":
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return []string{fmt.Sprintf("%s: %v is not an object", path, value)}
+		}
+		properties, _ := schema["properties"].(map[string]interface{})
+		additional, _ := schema["additionalProperties"].(map[string]interface{})
+		for k, v := range obj {
+			s, ok := properties[k].(map[string]interface{})
+			if !ok {
+				s = additional
+			}
+			if s == nil {
+				ans = append(ans, fmt.Sprintf("%s.%s: not documented", path, k))
+				continue
+			}
+			ans = append(ans, checkSchema(schemas, s, v, path+"."+k)...)
+		}
+	case "array":
+		list, ok := value.([]interface{})
+		if !ok {
+			return []string{fmt.Sprintf("%s: %v is not an array", path, value)}
+		}
+		items, _ := schema["items"].(map[string]interface{})
+		for i, v := range list {
+			ans = append(ans, checkSchema(schemas, items, v, fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	case "string":
+		if _, ok := value.(string); !ok {
+			ans = append(ans, fmt.Sprintf("%s: %v is not a string", path, value))
+		}
+	case "integer":
+		if f, ok := value.(float64); !ok || f != float64(int64(f)) {
+			ans = append(ans, fmt.Sprintf("%s: %v is not an integer", path, value))
+		}
+	case "number":
+		if _, ok := value.(float64); !ok {
+			ans = append(ans, fmt.Sprintf("%s: %v is not a number", path, value))
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			ans = append(ans, fmt.Sprintf("%s: %v is not a boolean", path, value))
+		}
+	}
+	return ans
+}
+
+// responseSchema returns the schema of the response of the operation
+// of the OpenAPI document with the status code.
+func responseSchema(t *testing.T, op map[string]interface{}, code string) map[string]interface{} {
+	resp, ok := op["responses"].(map[string]interface{})[code].(map[string]interface{})
+	if !ok {
+		t.Fatalf("missing %s response in %v", code, op)
+	}
+	content := resp["content"].(map[string]interface{})["application/json"].(map[string]interface{})
+	return content["schema"].(map[string]interface{})
+}
+
+func TestApiRoutes(t *testing.T) {
+	foo := newTestPackage("app", "foo", "1.0", "usr/bin/foo")
+	foo.Description = "A foo tool"
+	foo.Labels = map[string]string{"homepage": "example"}
+	foo.BuildTimestamp = "2021-01-01"
+	setTestSnapshot(t,
+		newTestRepository(t, "stable", 1,
+			withRequires(foo, "lib/bar"), newTestPackage("lib", "bar", "1.0", "usr/lib/libbar.so")),
+		newTestRepository(t, "testing", 2, newTestPackage("app", "foo", "1.1")))
+
+	history = newTestHistory(t)
+	t.Cleanup(func() { history = nil })
+	for i, versions := range [][]string{{"0.9"}, {"1.0"}} {
+		snap := newTestSnapshot(i, map[string][]string{"app/foo": versions})
+		if _, err := history.Record("stable", snap); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var api *ApiRouter
+	server := newTestServer(t, func(m *macaron.Macaron) {
+		api = registerApiRoutes(m)
+	})
+
+	code, doc := getJSON(t, server.URL+apiPrefix+"/openapi.json")
+	if code != http.StatusOK {
+		t.Fatalf("GET openapi.json = %d, want %d", code, http.StatusOK)
+	}
+	spec := doc.(map[string]interface{})
+	paths := spec["paths"].(map[string]interface{})
+	schemas := spec["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+
+	params := map[string]string{
+		":repository":      "stable",
+		":packagecategory": "app",
+		":packagename":     "foo",
+		":packageversion":  "1.0",
+	}
+	queries := map[string]string{
+		"/search": "q=foo",
+		"/owner":  "file=/usr/bin/foo",
+	}
+
+	// The document route is registered after the documented routes.
+	if len(api.routes) == 0 {
+		t.Fatal("no API routes registered")
+	}
+	for _, r := range api.routes {
+		specPath := apiPrefix + pathParamRegex.ReplaceAllString(r.Path, "{$1}")
+		item, ok := paths[specPath].(map[string]interface{})
+		if !ok {
+			t.Errorf("route %s is missing in the OpenAPI document", specPath)
+			continue
+		}
+		op, ok := item[r.Method].(map[string]interface{})
+		if !ok {
+			t.Errorf("method %s of route %s is missing in the OpenAPI document", r.Method, specPath)
+			continue
+		}
+
+		url := r.Path
+		for k, v := range params {
+			url = strings.Replace(url, k, v, 1)
+		}
+		if q, ok := queries[r.Path]; ok {
+			url += "?" + q
+		}
+
+		code, body := getJSON(t, server.URL+apiPrefix+url)
+		if code != http.StatusOK {
+			t.Errorf("GET %s = %d, want %d", url, code, http.StatusOK)
+			continue
+		}
+		if list, ok := body.([]interface{}); ok && len(list) == 0 {
+			t.Errorf("GET %s returned an empty list", url)
+		}
+		for _, e := range checkSchema(schemas, responseSchema(t, op, "200"), body, url) {
+			t.Error(e)
+		}
+
+		// The routes with parameters are not found with
+		// missing resources.
+		if !pathParamRegex.MatchString(r.Path) {
+			continue
+		}
+		missing := pathParamRegex.ReplaceAllString(r.Path, "missing")
+		code, body = getJSON(t, server.URL+apiPrefix+missing)
+		if code != http.StatusNotFound {
+			t.Errorf("GET %s = %d, want %d", missing, code, http.StatusNotFound)
+			continue
+		}
+		for _, e := range checkSchema(schemas, responseSchema(t, op, "404"), body, missing) {
+			t.Error(e)
+		}
+	}
+
+	if got, want := len(paths), len(api.routes); got != want {
+		t.Errorf("OpenAPI document has %d paths, want %d", got, want)
+	}
+}
+
+func TestCheckSchema(t *testing.T) {
+	schemas := map[string]interface{}{}
+	schema := jsonSchema(reflect.TypeOf(ApiPackageList{}), schemas)
+
+	valid := ApiPackageList{
+		Total:    1,
+		Page:     1,
+		PerPage:  50,
+		Packages: []ApiPackageSummary{{Repository: "stable"}},
+	}
+	data, _ := json.Marshal(valid)
+	var value interface{}
+	json.Unmarshal(data, &value)
+	if errs := checkSchema(schemas, schema, value, "list"); len(errs) != 0 {
+		t.Errorf("checkSchema(valid) = %v, want no errors", errs)
+	}
+
+	for _, tc := range []struct {
+		body string
+		want string
+	}{
+		{`{"total":"1"}`, "list.total: 1 is not an integer"},
+		{`{"undocumented":1}`, "list.undocumented: not documented"},
+		{`{"packages":[{"hidden":"no"}]}`, "list.packages[0].hidden: no is not a boolean"},
+		{`[]`, "list: [] is not an object"},
+	} {
+		var value interface{}
+		json.Unmarshal([]byte(tc.body), &value)
+		errs := checkSchema(schemas, schema, value, "list")
+		if len(errs) != 1 || errs[0] != tc.want {
+			t.Errorf("checkSchema(%s) = %v, want %q", tc.body, errs, tc.want)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	pkg "github.com/mudler/luet/pkg/package"
+	version "github.com/mudler/luet/pkg/versioner"
+	"github.com/narqo/go-badge"
+	"gopkg.in/macaron.v1"
+)
+
+const (
+	// Max age of the badges in the caches.
+	badgeMaxAge = 5 * time.Minute
+	// The sync age changes quickly.
+	syncBadgeMaxAge = time.Minute
+)
+
+var hexColorRegex = regexp.MustCompile(`^#?([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+// badgeColor returns the color selected with the color query parameter.
+// It could be a named color or a hex color with or without #.
+func badgeColor(ctx *macaron.Context, def badge.Color) badge.Color {
+	c := ctx.Query("color")
+	if _, ok := badge.ColorScheme[c]; ok {
+		return badge.Color(c)
+	}
+	if hexColorRegex.MatchString(c) {
+		return badge.Color("#" + strings.TrimPrefix(c, "#"))
+	}
+	return def
+}
+
+// formatAge returns the age in a short human readable format.
+func formatAge(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return fmt.Sprintf("%dm ago", int(d.Minutes()))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh ago", int(d.Hours()))
+	}
+	return fmt.Sprintf("%dd ago", int(d.Hours()/24))
+}
+
+// renderBadge writes the badge with the caching headers. The label and
+// the color can be overridden with the label and color query parameters.
+func renderBadge(ctx *macaron.Context, code int, label, status string, color badge.Color,
+	maxAge time.Duration, lastModified time.Time) {
+	if l := ctx.Query("label"); l != "" {
+		label = l
+	}
+
+	data, err := badge.RenderBytes(label, status, badgeColor(ctx, color))
+	if err != nil {
+		ctx.Error(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	etag := fmt.Sprintf(`"%x"`, sha1.Sum(data))
+	h := ctx.Resp.Header()
+	h.Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(maxAge.Seconds())))
+	h.Set("ETag", etag)
+	if !lastModified.IsZero() {
+		h.Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	if code == http.StatusOK && ctx.Req.Header.Get("If-None-Match") == etag {
+		ctx.Resp.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	h.Set("Content-Type", "image/svg+xml")
+	h.Set("Content-Length", strconv.Itoa(len(data)))
+	ctx.Resp.WriteHeader(code)
+	ctx.Resp.Write(data)
+}
+
+func registerBadgeRoutes(m *macaron.Macaron) {
+	instrumentedGet(m, "/badge/:repository", func(ctx *macaron.Context) {
+		snap := getSnapshot()
+		name := ctx.Params(":repository")
+
+		r := snap.GetRepository(name)
+		if r == nil {
+			renderBadge(ctx, http.StatusNotFound, name, "not found", badge.ColorLightgrey, badgeMaxAge, time.Time{})
+			return
+		}
+		renderBadge(ctx, http.StatusOK, name, fmt.Sprintf("%d packages", len(r.GetIndex())), "#3C1", badgeMaxAge,
+			snap.GetStatus(name).LastSync)
+	})
+
+	instrumentedGet(m, "/badge/:repository/sync", func(ctx *macaron.Context) {
+		st := getSnapshot().GetStatus(ctx.Params(":repository"))
+		switch {
+		case st == nil:
+			renderBadge(ctx, http.StatusNotFound, "last sync", "not found", badge.ColorLightgrey, syncBadgeMaxAge, time.Time{})
+		case !st.Synced:
+			renderBadge(ctx, http.StatusOK, "last sync", "never", badge.ColorRed, syncBadgeMaxAge, time.Time{})
+		case st.Stale:
+			renderBadge(ctx, http.StatusOK, "last sync", formatAge(time.Since(st.LastSync)), badge.ColorOrange, syncBadgeMaxAge, st.LastSync)
+		default:
+			renderBadge(ctx, http.StatusOK, "last sync", formatAge(time.Since(st.LastSync)), badge.ColorBrightgreen, syncBadgeMaxAge, st.LastSync)
+		}
+	})
+
+	instrumentedGet(m, "/badge/:repository/revision", func(ctx *macaron.Context) {
+		snap := getSnapshot()
+		name := ctx.Params(":repository")
+		r := snap.GetRepository(name)
+		if r == nil {
+			renderBadge(ctx, http.StatusNotFound, "revision", "not found", badge.ColorLightgrey, badgeMaxAge, time.Time{})
+			return
+		}
+		renderBadge(ctx, http.StatusOK, "revision", strconv.Itoa(r.GetRevision()), badge.ColorBlue, badgeMaxAge,
+			snap.GetStatus(name).LastSync)
+	})
+
+	instrumentedGet(m, "/badge/:repository/:packagecategory/:packagename", func(ctx *macaron.Context) {
+		snap := getSnapshot()
+		name := ctx.Params(":repository")
+		label := ctx.Params(":packagename")
+
+		r := snap.GetRepository(name)
+		if r == nil {
+			renderBadge(ctx, http.StatusNotFound, label, "not found", badge.ColorLightgrey, badgeMaxAge, time.Time{})
+			return
+		}
+
+		packages, err := r.GetTree().GetDatabase().FindPackages(&pkg.DefaultPackage{
+			Name:     ctx.Params(":packagename"),
+			Category: ctx.Params(":packagecategory"),
+			Version:  ">=0",
+		})
+		if err != nil || len(packages) == 0 {
+			renderBadge(ctx, http.StatusNotFound, label, "not found", badge.ColorLightgrey, badgeMaxAge, time.Time{})
+			return
+		}
+
+		versions := []string{}
+		for _, p := range packages {
+			versions = append(versions, p.GetVersion())
+		}
+		versions = version.DefaultVersioner().Sort(versions)
+
+		renderBadge(ctx, http.StatusOK, label, versions[len(versions)-1], badge.ColorBlue, badgeMaxAge,
+			snap.GetStatus(name).LastSync)
+	})
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+)
+
+var (
+	badgeTextRegex  = regexp.MustCompile(`<text x="[^"]*" y="14">([^<]*)</text>`)
+	badgeColorRegex = regexp.MustCompile(`<rect x="[^"]*" width="[^"]*" height="20" fill="([^"]*)"/>`)
+)
+
+// getBadge requests the badge and returns the subject, the status
+// and the color of the badge.
+func getBadge(t *testing.T, req *http.Request) (*http.Response, []string) {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode == http.StatusNotModified {
+		return resp, nil
+	}
+
+	ans := []string{}
+	for _, m := range badgeTextRegex.FindAllStringSubmatch(string(data), -1) {
+		ans = append(ans, m[1])
+	}
+	if m := badgeColorRegex.FindStringSubmatch(string(data)); m != nil {
+		ans = append(ans, m[1])
+	}
+	return resp, ans
+}
+
+func TestBadges(t *testing.T) {
+	setTestSnapshot(t,
+		newTestRepository(t, "stable", 3,
+			newTestPackage("app", "foo", "1.0"),
+			newTestPackage("app", "foo", "1.10"),
+			newTestPackage("app", "foo", "1.9")),
+		newTestRepository(t, "testing", 1))
+	updateSnapshot(true, func(s *Snapshot) {
+		s.Status["testing"].Synced = false
+		delete(s.synced, "testing")
+	})
+	lastSync := getSnapshot().GetStatus("stable").LastSync.UTC().Format(http.TimeFormat)
+
+	server := newTestServer(t, registerBadgeRoutes)
+
+	for _, tc := range []struct {
+		path         string
+		code         int
+		badge        []string
+		maxAge       time.Duration
+		lastModified string
+	}{
+		{"/badge/stable", http.StatusOK,
+			[]string{"stable", "3 packages", "#3C1"}, badgeMaxAge, lastSync},
+		// The label replaces the subject, the color the
+		// color of the status.
+		{"/badge/stable?label=luet", http.StatusOK,
+			[]string{"luet", "3 packages", "#3C1"}, badgeMaxAge, lastSync},
+		{"/badge/stable?color=red", http.StatusOK,
+			[]string{"stable", "3 packages", "#e05d44"}, badgeMaxAge, lastSync},
+		{"/badge/stable?color=abcdef", http.StatusOK,
+			[]string{"stable", "3 packages", "#abcdef"}, badgeMaxAge, lastSync},
+		{"/badge/stable?color=%23ABC", http.StatusOK,
+			[]string{"stable", "3 packages", "#ABC"}, badgeMaxAge, lastSync},
+		{"/badge/stable?color=invalid", http.StatusOK,
+			[]string{"stable", "3 packages", "#3C1"}, badgeMaxAge, lastSync},
+		{"/badge/testing", http.StatusNotFound,
+			[]string{"testing", "not found", "#9f9f9f"}, badgeMaxAge, ""},
+		{"/badge/missing", http.StatusNotFound,
+			[]string{"missing", "not found", "#9f9f9f"}, badgeMaxAge, ""},
+
+		{"/badge/stable/sync", http.StatusOK,
+			[]string{"last sync", "just now", "#4c1"}, syncBadgeMaxAge, lastSync},
+		{"/badge/stable/sync?label=updated", http.StatusOK,
+			[]string{"updated", "just now", "#4c1"}, syncBadgeMaxAge, lastSync},
+		{"/badge/testing/sync", http.StatusOK,
+			[]string{"last sync", "never", "#e05d44"}, syncBadgeMaxAge, ""},
+		{"/badge/missing/sync", http.StatusNotFound,
+			[]string{"last sync", "not found", "#9f9f9f"}, syncBadgeMaxAge, ""},
+
+		{"/badge/stable/revision", http.StatusOK,
+			[]string{"revision", "3", "#007ec6"}, badgeMaxAge, lastSync},
+		{"/badge/missing/revision", http.StatusNotFound,
+			[]string{"revision", "not found", "#9f9f9f"}, badgeMaxAge, ""},
+
+		{"/badge/stable/app/foo", http.StatusOK,
+			[]string{"foo", "1.10", "#007ec6"}, badgeMaxAge, lastSync},
+		{"/badge/stable/app/foo?label=app%2Ffoo&color=green", http.StatusOK,
+			[]string{"app/foo", "1.10", "#97ca00"}, badgeMaxAge, lastSync},
+		{"/badge/stable/app/missing", http.StatusNotFound,
+			[]string{"missing", "not found", "#9f9f9f"}, badgeMaxAge, ""},
+		{"/badge/testing/app/foo", http.StatusNotFound,
+			[]string{"foo", "not found", "#9f9f9f"}, badgeMaxAge, ""},
+	} {
+		req, _ := http.NewRequest("GET", server.URL+tc.path, nil)
+		resp, badge := getBadge(t, req)
+
+		if resp.StatusCode != tc.code {
+			t.Errorf("GET %s = %d, want %d", tc.path, resp.StatusCode, tc.code)
+		}
+		if !reflect.DeepEqual(badge, tc.badge) {
+			t.Errorf("GET %s badge = %q, want %q", tc.path, badge, tc.badge)
+		}
+		if got := resp.Header.Get("Content-Type"); got != "image/svg+xml" {
+			t.Errorf("GET %s Content-Type = %q, want image/svg+xml", tc.path, got)
+		}
+		if got, want := resp.Header.Get("Cache-Control"), "public, max-age="+
+			strconv.Itoa(int(tc.maxAge.Seconds())); got != want {
+			t.Errorf("GET %s Cache-Control = %q, want %q", tc.path, got, want)
+		}
+		if got := resp.Header.Get("Last-Modified"); got != tc.lastModified {
+			t.Errorf("GET %s Last-Modified = %q, want %q", tc.path, got, tc.lastModified)
+		}
+		if resp.Header.Get("ETag") == "" {
+			t.Errorf("GET %s without ETag", tc.path)
+		}
+	}
+}
+
+func TestBadgesNotModified(t *testing.T) {
+	setTestSnapshot(t, newTestRepository(t, "stable", 3))
+	server := newTestServer(t, registerBadgeRoutes)
+
+	req, _ := http.NewRequest("GET", server.URL+"/badge/stable/revision", nil)
+	resp, _ := getBadge(t, req)
+	etag := resp.Header.Get("ETag")
+
+	req.Header.Set("If-None-Match", etag)
+	resp, badge := getBadge(t, req)
+	if resp.StatusCode != http.StatusNotModified || badge != nil {
+		t.Errorf("GET with the ETag = %d %q, want %d", resp.StatusCode, badge, http.StatusNotModified)
+	}
+
+	// The ETag changes with the badge.
+	req, _ = http.NewRequest("GET", server.URL+"/badge/stable/revision?label=rev", nil)
+	req.Header.Set("If-None-Match", etag)
+	if resp, _ := getBadge(t, req); resp.StatusCode != http.StatusOK {
+		t.Errorf("GET with another label = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	// The errors are not cached with the ETag.
+	req, _ = http.NewRequest("GET", server.URL+"/badge/missing/revision", nil)
+	resp, _ = getBadge(t, req)
+	req.Header.Set("If-None-Match", resp.Header.Get("ETag"))
+	if resp, _ := getBadge(t, req); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET missing with the ETag = %d, want %d", resp.StatusCode, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"gopkg.in/macaron.v1"
+)
+
+const (
+	defaultChangesLimit = 100
+	maxChangesLimit     = 1000
+)
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssGuid struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	Description string  `xml:"description"`
+	PubDate     string  `xml:"pubDate"`
+	Guid        rssGuid `xml:"guid"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	Id      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomEntry struct {
+	Title   string   `xml:"title"`
+	Id      string   `xml:"id"`
+	Updated string   `xml:"updated"`
+	Link    atomLink `xml:"link"`
+	Summary string   `xml:"summary"`
+}
+
+// changeFilterFromQuery returns the filter defined by the repository,
+// category and name query parameters.
+func changeFilterFromQuery(ctx *macaron.Context) ChangeFilter {
+	return ChangeFilter{
+		Repository: ctx.Query("repository"),
+		Category:   ctx.Query("category"),
+		Name:       ctx.Query("name"),
+	}
+}
+
+func changesLimit(ctx *macaron.Context) int {
+	limit := ctx.QueryInt("limit")
+	if limit < 1 {
+		limit = defaultChangesLimit
+	} else if limit > maxChangesLimit {
+		limit = maxChangesLimit
+	}
+	return limit
+}
+
+func getChanges(filter ChangeFilter, limit int) ([]Change, error) {
+	if history == nil {
+		return []Change{}, nil
+	}
+	return history.Changes(filter, limit)
+}
+
+func (f ChangeFilter) Title() string {
+	ans := "Luet repositories changes"
+	if f.Category != "" || f.Name != "" {
+		ans += " of " + strings.Trim(f.Category+"/"+f.Name, "/")
+	}
+	if f.Repository != "" {
+		ans += " in " + f.Repository
+	}
+	return ans
+}
+
+// Query returns the query string that selects the same changes.
+func (f ChangeFilter) Query() string {
+	params := url.Values{}
+	for k, v := range map[string]string{
+		"repository": f.Repository,
+		"category":   f.Category,
+		"name":       f.Name,
+	} {
+		if v != "" {
+			params.Set(k, v)
+		}
+	}
+	if len(params) == 0 {
+		return ""
+	}
+	return "?" + params.Encode()
+}
+
+// baseUrl returns the url of the site used for the absolute links
+// of the feeds, including the base path.
+func baseUrl(ctx *macaron.Context) string {
+	scheme := "http"
+	if ctx.Req.TLS != nil {
+		scheme = "https"
+	}
+	if proto := ctx.Req.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	return scheme + "://" + ctx.Req.Host + basePath
+}
+
+func newRssFeed(base string, filter ChangeFilter, changes []Change) rssFeed {
+	ans := rssFeed{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:       filter.Title(),
+			Link:        base + "/changes" + filter.Query(),
+			Description: filter.Title(),
+			Items:       []rssItem{},
+		},
+	}
+	if len(changes) > 0 {
+		ans.Channel.LastBuildDate = changes[0].Time.Format(time.RFC1123Z)
+	}
+	for _, c := range changes {
+		ans.Channel.Items = append(ans.Channel.Items, rssItem{
+			Title:       c.Title(),
+			Link:        base + c.Path(),
+			Description: fmt.Sprintf("%s (revision %d)", c.Title(), c.Revision),
+			PubDate:     c.Time.Format(time.RFC1123Z),
+			Guid: rssGuid{
+				Value: fmt.Sprintf("%s/changes#%s-%d", base, c.Repository, c.Id),
+			},
+		})
+	}
+	return ans
+}
+
+func newAtomFeed(base string, filter ChangeFilter, changes []Change) atomFeed {
+	self := base + "/feeds/atom" + filter.Query()
+	ans := atomFeed{
+		Title:   filter.Title(),
+		Id:      self,
+		Updated: time.Now().UTC().Format(time.RFC3339),
+		Links: []atomLink{
+			{Href: self, Rel: "self"},
+			{Href: base + "/changes" + filter.Query()},
+		},
+		Entries: []atomEntry{},
+	}
+	if len(changes) > 0 {
+		ans.Updated = changes[0].Time.Format(time.RFC3339)
+	}
+	for _, c := range changes {
+		ans.Entries = append(ans.Entries, atomEntry{
+			Title:   c.Title(),
+			Id:      fmt.Sprintf("%s/changes#%s-%d", base, c.Repository, c.Id),
+			Updated: c.Time.Format(time.RFC3339),
+			Link:    atomLink{Href: base + c.Path()},
+			Summary: fmt.Sprintf("%s (revision %d)", c.Title(), c.Revision),
+		})
+	}
+	return ans
+}
+
+func registerChangesRoutes(m *macaron.Macaron) {
+	instrumentedGet(m, "/changes", func(ctx *macaron.Context) {
+		filter := changeFilterFromQuery(ctx)
+		changes, err := getChanges(filter, changesLimit(ctx))
+		if err != nil {
+			ctx.Error(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		ctx.Data["Filter"] = filter
+		ctx.Data["HistoryEnabled"] = history != nil
+		ctx.Data["Changes"] = changes
+		ctx.HTML(200, "changes")
+	})
+
+	instrumentedGet(m, "/feeds/:format", func(ctx *macaron.Context) {
+		filter := changeFilterFromQuery(ctx)
+		changes, err := getChanges(filter, changesLimit(ctx))
+		if err != nil {
+			ctx.Error(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		var feed interface{}
+		contentType := ""
+		switch ctx.Params(":format") {
+		case "rss":
+			feed = newRssFeed(baseUrl(ctx), filter, changes)
+			contentType = "application/rss+xml; charset=utf-8"
+		case "atom":
+			feed = newAtomFeed(baseUrl(ctx), filter, changes)
+			contentType = "application/atom+xml; charset=utf-8"
+		default:
+			ctx.Error(http.StatusNotFound, "Unsupported feed format")
+			return
+		}
+
+		data, err := xml.MarshalIndent(feed, "", "  ")
+		if err != nil {
+			ctx.Error(http.StatusInternalServerError, err.Error())
+			return
+		}
+		ctx.Resp.Header().Set("Content-Type", contentType)
+		ctx.Resp.WriteHeader(http.StatusOK)
+		ctx.Resp.Write([]byte(xml.Header))
+		ctx.Resp.Write(data)
+	})
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+
+	installer "github.com/mudler/luet/pkg/installer"
+	pkg "github.com/mudler/luet/pkg/package"
+	version "github.com/mudler/luet/pkg/versioner"
+	"gopkg.in/macaron.v1"
+)
+
+// PackageComparison compares the newest version of a package in every
+// repository. The differences are relative to the reference repository,
+// that is the first repository with the latest version unless selected.
+type PackageComparison struct {
+	Category     string                 `json:"category"`
+	Name         string                 `json:"name"`
+	Latest       string                 `json:"latest"`
+	Reference    string                 `json:"reference"`
+	Repositories []RepositoryComparison `json:"repositories"`
+}
+
+type RepositoryComparison struct {
+	Repository string `json:"repository"`
+	// Available is false if the repository is not synced or
+	// doesn't have the package.
+	Available bool     `json:"available"`
+	Versions  []string `json:"versions,omitempty"`
+	// Version is the newest version of the repository.
+	Version        string          `json:"version,omitempty"`
+	Behind         bool            `json:"behind"`
+	BuildTimestamp string          `json:"build_timestamp,omitempty"`
+	Requires       []ApiPackageRef `json:"requires,omitempty"`
+	Files          int             `json:"files"`
+
+	BuildTimestampDiffers bool            `json:"build_timestamp_differs,omitempty"`
+	RequiresAdded         []ApiPackageRef `json:"requires_added,omitempty"`
+	RequiresRemoved       []ApiPackageRef `json:"requires_removed,omitempty"`
+	FilesAdded            []string        `json:"files_added,omitempty"`
+	FilesRemoved          []string        `json:"files_removed,omitempty"`
+
+	files []string
+}
+
+// diffStrings returns the elements of list missing in ref and the
+// elements of ref missing in list.
+func diffStrings(list, ref []string) ([]string, []string) {
+	inList := map[string]bool{}
+	for _, s := range list {
+		inList[s] = true
+	}
+	inRef := map[string]bool{}
+	for _, s := range ref {
+		inRef[s] = true
+	}
+
+	added, removed := []string{}, []string{}
+	for s := range inList {
+		if !inRef[s] {
+			added = append(added, s)
+		}
+	}
+	for s := range inRef {
+		if !inList[s] {
+			removed = append(removed, s)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
+
+func diffRefs(list, ref []ApiPackageRef) ([]ApiPackageRef, []ApiPackageRef) {
+	refs := map[string]ApiPackageRef{}
+	keys := func(l []ApiPackageRef) []string {
+		ans := []string{}
+		for _, r := range l {
+			refs[r.String()] = r
+			ans = append(ans, r.String())
+		}
+		return ans
+	}
+
+	added, removed := diffStrings(keys(list), keys(ref))
+	toRefs := func(l []string) []ApiPackageRef {
+		ans := []ApiPackageRef{}
+		for _, k := range l {
+			ans = append(ans, refs[k])
+		}
+		return ans
+	}
+	return toRefs(added), toRefs(removed)
+}
+
+// compareRepository fills the comparison with the newest version of
+// the package in the repository.
+func compareRepository(r *installer.LuetSystemRepository, category, name string) RepositoryComparison {
+	ans := RepositoryComparison{Repository: r.GetName()}
+
+	packages, err := r.GetTree().GetDatabase().FindPackages(&pkg.DefaultPackage{
+		Name:     name,
+		Category: category,
+		Version:  ">=0",
+	})
+	if err != nil || len(packages) == 0 {
+		return ans
+	}
+
+	for _, p := range packages {
+		ans.Versions = append(ans.Versions, p.GetVersion())
+	}
+	ans.Versions = version.DefaultVersioner().Sort(ans.Versions)
+	ans.Version = ans.Versions[len(ans.Versions)-1]
+	ans.Available = true
+
+	var p pkg.Package
+	for _, c := range packages {
+		if c.GetVersion() == ans.Version {
+			p = c
+		}
+	}
+	// The artefact contains the build timestamp and the files
+	for _, a := range r.GetIndex() {
+		if a.CompileSpec.GetPackage().GetFingerPrint() == p.GetFingerPrint() {
+			p = a.CompileSpec.GetPackage()
+			ans.files = a.Files
+			break
+		}
+	}
+
+	ans.BuildTimestamp = p.GetBuildTimestamp()
+	ans.Requires = newApiPackageRefs(p.GetRequires())
+	ans.Files = len(ans.files)
+	return ans
+}
+
+// ComparePackage compares the package in all the repositories. It
+// returns nil if no repository has the package.
+func ComparePackage(snap *Snapshot, category, name, reference string) *PackageComparison {
+	ans := &PackageComparison{
+		Category:     category,
+		Name:         name,
+		Repositories: []RepositoryComparison{},
+	}
+
+	versions := []string{}
+	for _, repo := range snap.Names {
+		c := RepositoryComparison{Repository: repo}
+		if r := snap.GetRepository(repo); r != nil {
+			c = compareRepository(r, category, name)
+		}
+		if c.Available {
+			versions = append(versions, c.Version)
+		}
+		ans.Repositories = append(ans.Repositories, c)
+	}
+	if len(versions) == 0 {
+		return nil
+	}
+
+	versions = version.DefaultVersioner().Sort(versions)
+	ans.Latest = versions[len(versions)-1]
+
+	var ref *RepositoryComparison
+	for i := range ans.Repositories {
+		c := &ans.Repositories[i]
+		if !c.Available {
+			continue
+		}
+		c.Behind = c.Version != ans.Latest
+		if ref == nil && c.Version == ans.Latest {
+			ref = c
+		}
+		if reference != "" && c.Repository == reference {
+			ref = c
+			reference = ""
+		}
+	}
+	ans.Reference = ref.Repository
+
+	for i := range ans.Repositories {
+		c := &ans.Repositories[i]
+		if !c.Available || c == ref {
+			continue
+		}
+		c.BuildTimestampDiffers = c.BuildTimestamp != ref.BuildTimestamp
+		c.RequiresAdded, c.RequiresRemoved = diffRefs(c.Requires, ref.Requires)
+		c.FilesAdded, c.FilesRemoved = diffStrings(c.files, ref.files)
+	}
+
+	return ans
+}
+
+// packageComparison returns the comparison of the package selected by
+// the route parameters and the base query parameter.
+func packageComparison(ctx *macaron.Context) *PackageComparison {
+	return ComparePackage(getSnapshot(),
+		ctx.Params(":packagecategory"), ctx.Params(":packagename"), ctx.Query("base"))
+}
+
+func registerCompareRoutes(m *macaron.Macaron) {
+	instrumentedGet(m, "/compare/:packagecategory/:packagename", func(ctx *macaron.Context) {
+		cmp := packageComparison(ctx)
+		if cmp == nil {
+			ctx.Error(http.StatusNotFound, "Package not found")
+			return
+		}
+
+		ctx.Data["Comparison"] = cmp
+		ctx.HTML(http.StatusOK, "compare")
+	})
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// comparisonString returns the comparison with a line for every
+// repository.
+func comparisonString(c *PackageComparison) []string {
+	if c == nil {
+		return nil
+	}
+
+	ans := []string{fmt.Sprintf("latest %s reference %s", c.Latest, c.Reference)}
+	for _, r := range c.Repositories {
+		if !r.Available {
+			ans = append(ans, r.Repository+" missing")
+			continue
+		}
+		refs := func(l []ApiPackageRef) string {
+			s := []string{}
+			for _, r := range l {
+				s = append(s, r.String())
+			}
+			return strings.Join(s, ",")
+		}
+		ans = append(ans, fmt.Sprintf("%s %s behind=%t files=%d +%s -%s requires +%s -%s timestamp=%t",
+			r.Repository, r.Version, r.Behind, r.Files,
+			strings.Join(r.FilesAdded, ","), strings.Join(r.FilesRemoved, ","),
+			refs(r.RequiresAdded), refs(r.RequiresRemoved), r.BuildTimestampDiffers))
+	}
+	return ans
+}
+
+func TestComparePackage(t *testing.T) {
+	foo := newTestPackage("app", "foo", "1.0", "usr/bin/foo", "usr/share/foo")
+	foo.BuildTimestamp = "1"
+	newFoo := withRequires(newTestPackage("app", "foo", "1.1", "usr/bin/foo", "usr/lib/foo"), "lib/bar")
+	newFoo.BuildTimestamp = "2"
+	rebuiltFoo := newTestPackage("app", "foo", "1.0", "usr/bin/foo", "usr/share/foo")
+	rebuiltFoo.BuildTimestamp = "3"
+
+	for _, tc := range []struct {
+		name        string
+		left, right []testPackage
+		base        string
+		want        []string
+	}{
+		{"only left",
+			[]testPackage{foo}, nil, "",
+			[]string{
+				"latest 1.0 reference left",
+				"left 1.0 behind=false files=2 + - requires + - timestamp=false",
+				"right missing",
+			}},
+		{"only right",
+			nil, []testPackage{foo}, "",
+			[]string{
+				"latest 1.0 reference right",
+				"left missing",
+				"right 1.0 behind=false files=2 + - requires + - timestamp=false",
+			}},
+		{"version differs",
+			[]testPackage{foo}, []testPackage{foo, newFoo}, "",
+			[]string{
+				"latest 1.1 reference right",
+				"left 1.0 behind=true files=2 +usr/share/foo -usr/lib/foo requires + -lib/bar >=0 timestamp=true",
+				"right 1.1 behind=false files=2 + - requires + - timestamp=false",
+			}},
+		{"version differs with base",
+			[]testPackage{foo}, []testPackage{newFoo}, "left",
+			[]string{
+				"latest 1.1 reference left",
+				"left 1.0 behind=true files=2 + - requires + - timestamp=false",
+				"right 1.1 behind=false files=2 +usr/lib/foo -usr/share/foo requires +lib/bar >=0 - timestamp=true",
+			}},
+		{"same version rebuilt",
+			[]testPackage{foo}, []testPackage{rebuiltFoo}, "",
+			[]string{
+				"latest 1.0 reference left",
+				"left 1.0 behind=false files=2 + - requires + - timestamp=false",
+				"right 1.0 behind=false files=2 + - requires + - timestamp=true",
+			}},
+		{"missing base",
+			[]testPackage{foo}, []testPackage{newFoo}, "missing",
+			[]string{
+				"latest 1.1 reference right",
+				"left 1.0 behind=true files=2 +usr/share/foo -usr/lib/foo requires + -lib/bar >=0 timestamp=true",
+				"right 1.1 behind=false files=2 + - requires + - timestamp=false",
+			}},
+		{"missing package", nil, nil, "", nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			setTestSnapshot(t,
+				newTestRepository(t, "left", 1, tc.left...),
+				newTestRepository(t, "right", 1, tc.right...))
+
+			got := comparisonString(ComparePackage(getSnapshot(), "app", "foo", tc.base))
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("ComparePackage() =\n%s\nwant\n%s",
+					strings.Join(got, "\n"), strings.Join(tc.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestComparePackageNotSynced(t *testing.T) {
+	setTestSnapshot(t,
+		newTestRepository(t, "left", 1, newTestPackage("app", "foo", "1.0")),
+		newTestRepository(t, "right", 1, newTestPackage("app", "foo", "2.0")))
+	updateSnapshot(true, func(s *Snapshot) {
+		delete(s.synced, "right")
+	})
+
+	got := comparisonString(ComparePackage(getSnapshot(), "app", "foo", ""))
+	want := []string{
+		"latest 1.0 reference left",
+		"left 1.0 behind=false files=0 + - requires + - timestamp=false",
+		"right missing",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ComparePackage() = %q, want %q", got, want)
+	}
+}
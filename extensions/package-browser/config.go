@@ -0,0 +1,287 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/signal"
+	"reflect"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	defaultSyncInterval = 960
+	defaultSyncTimeout  = 600
+	defaultTemplatesDir = "/usr/share/luet-package-browser"
+	defaultHistoryDb    = "history.db"
+
+	configPollInterval = 5 * time.Second
+)
+
+var repositoryTypes = []string{"http", "disk", "docker"}
+
+// reservedNames are the top level routes, a repository with the same
+// name would be hidden by the route.
+var reservedNames = []string{
+	"api", "badge", "changes", "compare", "deps", "feeds",
+	"find", "health", "metrics", "owner", "search", "status",
+}
+
+// Config is the config file of the browser. The server settings are
+// applied on startup, the repositories are reloaded on SIGHUP or when
+// the file changes.
+type Config struct {
+	Server       ServerConfig `yaml:"server,omitempty"`
+	Repositories []Repository `yaml:"repositories"`
+}
+
+type ServerConfig struct {
+	// Listen address. Default is HOST:PORT or 0.0.0.0:4000.
+	Listen  string `yaml:"listen,omitempty"`
+	TLSCert string `yaml:"tls_cert,omitempty"`
+	TLSKey  string `yaml:"tls_key,omitempty"`
+	// BasePath is the path of the browser behind a reverse proxy.
+	BasePath     string `yaml:"base_path,omitempty"`
+	TemplatesDir string `yaml:"templates_dir,omitempty"`
+	HistoryDb    string `yaml:"history_db,omitempty"`
+	// Default sync interval of the repositories in seconds.
+	SyncInterval int `yaml:"sync_interval,omitempty"`
+	// Default sync timeout of the repositories in seconds.
+	SyncTimeout int `yaml:"sync_timeout,omitempty"`
+}
+
+type Repository struct {
+	Name        string `yaml:"name"`
+	Url         string `yaml:"url"`
+	Type        string `yaml:"type,omitempty"`
+	Github      string `yaml:"github,omitempty"`
+	Description string `yaml:"description,omitempty"`
+	// Sync interval in seconds. Default is the server sync interval.
+	SyncInterval int `yaml:"sync_interval,omitempty"`
+	// Sync timeout in seconds. Default is the server sync timeout.
+	SyncTimeout int `yaml:"sync_timeout,omitempty"`
+	// Auth contains the authentication of luet, for example the
+	// token of http repositories or username and password of docker
+	// repositories. The values are expanded with the environment variables.
+	Auth map[string]string `yaml:"auth,omitempty"`
+}
+
+// Equal returns true if the repository has the same settings.
+func (r Repository) Equal(o Repository) bool {
+	return reflect.DeepEqual(r, o)
+}
+
+// LoadConfig reads, validates and sets the defaults of the config file.
+// The environment variables SLEEPTIME, TEMPLATES_DIR and HISTORY_DB
+// are used as defaults of the server settings.
+func LoadConfig(file string) (*Config, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed reading config file")
+	}
+
+	cfg := &Config{}
+	if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+		return nil, errors.Wrap(err, "Invalid config file "+file)
+	}
+
+	cfg.setDefaults()
+
+	if err := cfg.Validate(); err != nil {
+		return nil, errors.Wrap(err, "Invalid config file "+file)
+	}
+
+	return cfg, nil
+}
+
+func (c *Config) setDefaults() {
+	s := &c.Server
+
+	if s.Listen == "" {
+		host := os.Getenv("HOST")
+		if host == "" {
+			host = "0.0.0.0"
+		}
+		port := os.Getenv("PORT")
+		if port == "" {
+			port = "4000"
+		}
+		s.Listen = net.JoinHostPort(host, port)
+	}
+	if s.TemplatesDir == "" {
+		s.TemplatesDir = os.Getenv("TEMPLATES_DIR")
+		if s.TemplatesDir == "" {
+			s.TemplatesDir = defaultTemplatesDir
+		}
+	}
+	if s.HistoryDb == "" {
+		s.HistoryDb = os.Getenv("HISTORY_DB")
+		if s.HistoryDb == "" {
+			s.HistoryDb = defaultHistoryDb
+		}
+	}
+	if s.SyncInterval == 0 {
+		s.SyncInterval = defaultSyncInterval
+		if v, err := strconv.Atoi(os.Getenv("SLEEPTIME")); err == nil {
+			s.SyncInterval = v
+		}
+	}
+	if s.SyncTimeout == 0 {
+		s.SyncTimeout = defaultSyncTimeout
+	}
+	if s.BasePath != "" {
+		s.BasePath = "/" + strings.Trim(s.BasePath, "/")
+		if s.BasePath == "/" {
+			s.BasePath = ""
+		}
+	}
+
+	for i := range c.Repositories {
+		r := &c.Repositories[i]
+		if r.Type == "" {
+			r.Type = "http"
+		}
+		if r.SyncInterval == 0 && s.SyncInterval > 0 {
+			r.SyncInterval = s.SyncInterval
+		}
+		if r.SyncTimeout == 0 && s.SyncTimeout > 0 {
+			r.SyncTimeout = s.SyncTimeout
+		}
+		for k, v := range r.Auth {
+			r.Auth[k] = os.ExpandEnv(v)
+		}
+	}
+}
+
+// Validate returns an error with all the invalid settings.
+func (c *Config) Validate() error {
+	errs := []string{}
+	addError := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Sprintf(format, args...))
+	}
+
+	s := c.Server
+	if _, _, err := net.SplitHostPort(s.Listen); err != nil {
+		addError("server.listen: invalid address %q: %s", s.Listen, err.Error())
+	}
+	if (s.TLSCert == "") != (s.TLSKey == "") {
+		addError("server.tls_cert and server.tls_key must be set together")
+	}
+	for _, f := range []string{s.TLSCert, s.TLSKey} {
+		if f == "" {
+			continue
+		}
+		if _, err := os.Stat(f); err != nil {
+			addError("server: TLS file %s not available: %s", f, err.Error())
+		}
+	}
+	if s.SyncInterval < 1 {
+		addError("server.sync_interval: must be greater than 0")
+	}
+	if s.SyncTimeout < 1 {
+		addError("server.sync_timeout: must be greater than 0")
+	}
+
+	if len(c.Repositories) == 0 {
+		addError("repositories: no repository defined")
+	}
+
+	names := map[string]bool{}
+	for i, r := range c.Repositories {
+		field := fmt.Sprintf("repositories[%d]", i)
+		if r.Name == "" {
+			addError("%s.name: missing name", field)
+		} else {
+			field = fmt.Sprintf("repositories[%s]", r.Name)
+			if strings.Contains(r.Name, "/") {
+				addError("%s.name: the name can't contain /", field)
+			}
+			for _, n := range reservedNames {
+				if r.Name == n {
+					addError("%s.name: the name is reserved, the reserved names are %s",
+						field, strings.Join(reservedNames, ", "))
+				}
+			}
+			if names[r.Name] {
+				addError("%s.name: duplicated repository", field)
+			}
+			names[r.Name] = true
+		}
+
+		if r.Url == "" {
+			addError("%s.url: missing url", field)
+		}
+
+		validType := false
+		for _, t := range repositoryTypes {
+			if r.Type == t {
+				validType = true
+			}
+		}
+		if !validType {
+			addError("%s.type: invalid type %q, the supported types are %s",
+				field, r.Type, strings.Join(repositoryTypes, ", "))
+		}
+
+		if r.SyncInterval < 0 {
+			addError("%s.sync_interval: must be positive", field)
+		}
+		if r.SyncTimeout < 0 {
+			addError("%s.sync_timeout: must be positive", field)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "\n"))
+	}
+	return nil
+}
+
+// watchConfig reloads the repositories of the config file on SIGHUP
+// or when the file changes. An invalid config file is ignored.
+func watchConfig(file string, cfg *Config) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	ticker := time.NewTicker(configPollInterval)
+	defer ticker.Stop()
+
+	stat := func() (time.Time, int64) {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, -1
+		}
+		return info.ModTime(), info.Size()
+	}
+	modTime, size := stat()
+
+	for {
+		select {
+		case <-hup:
+		case <-ticker.C:
+			t, sz := stat()
+			if t.Equal(modTime) && sz == size {
+				continue
+			}
+			modTime, size = t, sz
+		}
+
+		newCfg, err := LoadConfig(file)
+		if err != nil {
+			fmt.Println("Config not reloaded:", err)
+			continue
+		}
+		if !reflect.DeepEqual(newCfg.Server, cfg.Server) {
+			fmt.Println("The server settings changed, a restart is required to apply them")
+		}
+		applyRepositories(newCfg.Repositories)
+		cfg = newCfg
+		fmt.Println("Config reloaded")
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateRepositoryNames(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		error string
+	}{
+		{"stable", ""},
+		{"search-mirror", ""},
+		{"", "missing name"},
+		{"a/b", "can't contain /"},
+		{"search", "the name is reserved"},
+		{"api", "the name is reserved"},
+		{"compare", "the name is reserved"},
+	} {
+		cfg := &Config{Repositories: []Repository{{Name: tc.name, Url: "stable"}}}
+		cfg.setDefaults()
+
+		err := cfg.Validate()
+		if tc.error == "" && err != nil {
+			t.Errorf("%q: unexpected error %s", tc.name, err.Error())
+		}
+		if tc.error != "" && (err == nil || !strings.Contains(err.Error(), tc.error)) {
+			t.Errorf("%q: got error %v, want %q", tc.name, err, tc.error)
+		}
+	}
+}
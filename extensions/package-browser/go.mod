@@ -7,6 +7,8 @@ require (
 	github.com/mudler/luet v0.0.0-20210811123330-3402641241fd
 	github.com/narqo/go-badge v0.0.0-20190124110329-d9415e4e1e9f
 	github.com/pkg/errors v0.9.1
+	github.com/prometheus/client_golang v1.3.0
+	go.etcd.io/bbolt v1.3.5
 	gopkg.in/macaron.v1 v1.3.9
 	gopkg.in/yaml.v2 v2.4.0
 )
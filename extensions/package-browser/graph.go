@@ -0,0 +1,276 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	installer "github.com/mudler/luet/pkg/installer"
+	pkg "github.com/mudler/luet/pkg/package"
+	"gopkg.in/macaron.v1"
+)
+
+const (
+	defaultGraphDepth = 3
+	maxGraphDepth     = 10
+)
+
+// DependencyNode is a package of a dependency tree. The children are
+// the requires of the package, or the packages that require it for
+// a reverse dependency tree.
+type DependencyNode struct {
+	ApiPackageRef
+	Conflicts []ApiPackageRef `json:"conflicts,omitempty"`
+	// Missing is true if no package of the repository satisfies
+	// the requirement. The version is the version selector.
+	Missing bool `json:"missing,omitempty"`
+	// Cycle is true if the package is already in the path from the root.
+	Cycle bool `json:"cycle,omitempty"`
+	// Repeated is true if the children of the package are already in
+	// another branch of the tree. Every package is expanded once, the
+	// shared dependencies would make the tree grow exponentially.
+	Repeated bool `json:"repeated,omitempty"`
+	// Truncated is true if the children are omitted because of the depth.
+	Truncated bool              `json:"truncated,omitempty"`
+	Children  []*DependencyNode `json:"children,omitempty"`
+}
+
+// DependencyGraph contains the resolved requires of the packages of
+// a repository. It's built on every sync and it's read only.
+type DependencyGraph struct {
+	packages map[string]pkg.Package
+	// fingerprint -> best candidates of the requires
+	requires map[string][]string
+	// fingerprint -> packages that require it
+	rdeps map[string][]string
+	// fingerprint -> requires without candidates
+	missing map[string][]ApiPackageRef
+}
+
+func newApiPackageRef(p pkg.Package) ApiPackageRef {
+	return ApiPackageRef{
+		Category: p.GetCategory(),
+		Name:     p.GetName(),
+		Version:  p.GetVersion(),
+	}
+}
+
+func (r ApiPackageRef) String() string {
+	// The requires and the conflicts could have a version selector.
+	if strings.ContainsAny(r.Version, "<>=~!*") {
+		return r.Category + "/" + r.Name + " " + r.Version
+	}
+	return r.Category + "/" + r.Name + "-" + r.Version
+}
+
+func appendUnique(list []string, s string) []string {
+	for _, e := range list {
+		if e == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
+
+func NewDependencyGraph(r *installer.LuetSystemRepository) *DependencyGraph {
+	g := &DependencyGraph{
+		packages: map[string]pkg.Package{},
+		requires: map[string][]string{},
+		rdeps:    map[string][]string{},
+		missing:  map[string][]ApiPackageRef{},
+	}
+
+	db := r.GetTree().GetDatabase()
+	for _, p := range db.World() {
+		g.packages[p.GetFingerPrint()] = p
+	}
+
+	for fp, p := range g.packages {
+		for _, req := range p.GetRequires() {
+			candidates, err := db.FindPackages(req)
+			if err != nil || len(candidates) == 0 {
+				g.missing[fp] = append(g.missing[fp], newApiPackageRef(req))
+				continue
+			}
+
+			g.requires[fp] = appendUnique(g.requires[fp], candidates.Best(nil).GetFingerPrint())
+			// Every version that satisfies the requirement
+			// has the package as reverse dependency.
+			for _, c := range candidates {
+				g.rdeps[c.GetFingerPrint()] = appendUnique(g.rdeps[c.GetFingerPrint()], fp)
+			}
+		}
+	}
+
+	return g
+}
+
+func (g *DependencyGraph) sortedRefs(fps []string) []string {
+	ans := append([]string{}, fps...)
+	sort.Slice(ans, func(i, j int) bool {
+		return newApiPackageRef(g.packages[ans[i]]).String() <
+			newApiPackageRef(g.packages[ans[j]]).String()
+	})
+	return ans
+}
+
+// Tree returns the dependency tree of the package until the depth.
+// If reverse is true the tree contains the packages that require it.
+// The children of a package are returned only on its first occurrence
+// in depth first order. It returns nil if the package is not available.
+func (g *DependencyGraph) Tree(p pkg.Package, depth int, reverse bool) *DependencyNode {
+	if _, ok := g.packages[p.GetFingerPrint()]; !ok {
+		return nil
+	}
+	return g.tree(p.GetFingerPrint(), depth, reverse, map[string]bool{}, map[string]bool{})
+}
+
+func (g *DependencyGraph) tree(fp string, depth int, reverse bool, path, expanded map[string]bool) *DependencyNode {
+	p := g.packages[fp]
+	node := &DependencyNode{
+		ApiPackageRef: newApiPackageRef(p),
+	}
+	for _, c := range p.GetConflicts() {
+		node.Conflicts = append(node.Conflicts, newApiPackageRef(c))
+	}
+
+	if path[fp] {
+		node.Cycle = true
+		return node
+	}
+
+	edges := g.requires[fp]
+	if reverse {
+		edges = g.rdeps[fp]
+	}
+	hasChildren := len(edges) > 0 || (!reverse && len(g.missing[fp]) > 0)
+	if depth <= 0 {
+		node.Truncated = hasChildren
+		return node
+	}
+	if expanded[fp] {
+		node.Repeated = hasChildren
+		return node
+	}
+
+	path[fp] = true
+	expanded[fp] = true
+	for _, child := range g.sortedRefs(edges) {
+		node.Children = append(node.Children, g.tree(child, depth-1, reverse, path, expanded))
+	}
+	delete(path, fp)
+
+	if !reverse {
+		for _, m := range g.missing[fp] {
+			node.Children = append(node.Children, &DependencyNode{ApiPackageRef: m, Missing: true})
+		}
+	}
+
+	return node
+}
+
+// Dot returns the Graphviz representation of the tree.
+func (n *DependencyNode) Dot(reverse bool) string {
+	var b strings.Builder
+	edges := map[string]bool{}
+
+	quote := func(s string) string {
+		return `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+	}
+
+	fmt.Fprintf(&b, "digraph %s {\n", quote(n.String()))
+	b.WriteString("  node [shape=box];\n")
+	fmt.Fprintf(&b, "  %s [style=bold];\n", quote(n.String()))
+
+	var visit func(n *DependencyNode)
+	visit = func(n *DependencyNode) {
+		for _, c := range n.Conflicts {
+			edge := fmt.Sprintf("  %s -> %s [style=dashed, color=red, label=\"conflicts\"];\n",
+				quote(n.String()), quote(c.String()))
+			if !edges[edge] {
+				edges[edge] = true
+				b.WriteString(edge)
+			}
+		}
+		for _, c := range n.Children {
+			from, to := n.String(), c.String()
+			if reverse {
+				from, to = to, from
+			}
+			edge := fmt.Sprintf("  %s -> %s;\n", quote(from), quote(to))
+			if c.Missing {
+				edge = fmt.Sprintf("  %s [color=red, fontcolor=red];\n", quote(to)) + edge
+			}
+			if !edges[edge] {
+				edges[edge] = true
+				b.WriteString(edge)
+			}
+			visit(c)
+		}
+	}
+	visit(n)
+
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// graphDepth returns the depth selected with the depth query parameter.
+func graphDepth(ctx *macaron.Context) int {
+	depth := ctx.QueryInt("depth")
+	if depth < 1 {
+		depth = defaultGraphDepth
+	} else if depth > maxGraphDepth {
+		depth = maxGraphDepth
+	}
+	return depth
+}
+
+// dependencyTree returns the tree of the package selected by the route
+// parameters or an error message if the package is not available.
+func dependencyTree(ctx *macaron.Context, reverse bool) (*DependencyNode, string) {
+	g := getSnapshot().GetGraph(ctx.Params(":repository"))
+	if g == nil {
+		return nil, "Repository not found"
+	}
+
+	tree := g.Tree(&pkg.DefaultPackage{
+		Name:     ctx.Params(":packagename"),
+		Category: ctx.Params(":packagecategory"),
+		Version:  ctx.Params(":packageversion"),
+	}, graphDepth(ctx), reverse)
+	if tree == nil {
+		return nil, "Package not found"
+	}
+
+	return tree, ""
+}
+
+func registerGraphRoutes(m *macaron.Macaron) {
+	instrumentedGet(m, "/deps/:repository/:packagecategory/:packagename/:packageversion", func(ctx *macaron.Context) {
+		reverse := ctx.QueryBool("reverse")
+		tree, msg := dependencyTree(ctx, reverse)
+		if tree == nil {
+			ctx.Error(http.StatusNotFound, msg)
+			return
+		}
+
+		switch ctx.Query("format") {
+		case "dot":
+			ctx.Resp.Header().Set("Content-Type", "text/vnd.graphviz; charset=utf-8")
+			ctx.Resp.Header().Set("Content-Disposition",
+				fmt.Sprintf("inline; filename=%q", tree.Name+"-"+tree.Version+".dot"))
+			ctx.Resp.WriteHeader(http.StatusOK)
+			ctx.Resp.Write([]byte(tree.Dot(reverse)))
+		case "json":
+			ctx.JSON(http.StatusOK, tree)
+		default:
+			ctx.Data["RepositoryName"] = ctx.Params(":repository")
+			ctx.Data["Tree"] = tree
+			ctx.Data["Reverse"] = reverse
+			ctx.Data["Depth"] = graphDepth(ctx)
+			ctx.Data["MaxDepth"] = maxGraphDepth
+			ctx.HTML(http.StatusOK, "dependencies")
+		}
+	})
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	pkg "github.com/mudler/luet/pkg/package"
+)
+
+func withRequires(p testPackage, requires ...string) testPackage {
+	for _, r := range requires {
+		parts := strings.SplitN(r, "/", 2)
+		p.PackageRequires = append(p.PackageRequires, &pkg.DefaultPackage{
+			Category: parts[0],
+			Name:     parts[1],
+			Version:  ">=0",
+		})
+	}
+	return p
+}
+
+// treeString returns the tree with a line for every node, the children
+// are indented.
+func treeString(n *DependencyNode) string {
+	var b strings.Builder
+	var visit func(n *DependencyNode, indent string)
+	visit = func(n *DependencyNode, indent string) {
+		b.WriteString(indent + n.String())
+		for flag, set := range map[string]bool{
+			" missing":   n.Missing,
+			" cycle":     n.Cycle,
+			" repeated":  n.Repeated,
+			" truncated": n.Truncated,
+		} {
+			if set {
+				b.WriteString(flag)
+			}
+		}
+		b.WriteString("\n")
+		for _, c := range n.Children {
+			visit(c, indent+"  ")
+		}
+	}
+	visit(n, "")
+	return b.String()
+}
+
+func countNodes(n *DependencyNode) int {
+	ans := 1
+	for _, c := range n.Children {
+		ans += countNodes(c)
+	}
+	return ans
+}
+
+func TestDependencyTree(t *testing.T) {
+	g := NewDependencyGraph(newTestRepository(t, "stable", 1,
+		withRequires(newTestPackage("app", "foo", "1.0"), "lib/a", "lib/b", "lib/missing"),
+		withRequires(newTestPackage("lib", "a", "1.0"), "lib/c"),
+		withRequires(newTestPackage("lib", "b", "1.0"), "lib/c"),
+		withRequires(newTestPackage("lib", "c", "1.0"), "lib/d"),
+		withRequires(newTestPackage("lib", "d", "1.0"), "lib/c"),
+	))
+	foo := &pkg.DefaultPackage{Category: "app", Name: "foo", Version: "1.0"}
+
+	for _, tc := range []struct {
+		name    string
+		p       *pkg.DefaultPackage
+		depth   int
+		reverse bool
+		want    string
+	}{
+		{"shared dependencies expanded once", foo, 10, false, `app/foo-1.0
+  lib/a-1.0
+    lib/c-1.0
+      lib/d-1.0
+        lib/c-1.0 cycle
+  lib/b-1.0
+    lib/c-1.0 repeated
+  lib/missing >=0 missing
+`},
+		{"truncated", foo, 1, false, `app/foo-1.0
+  lib/a-1.0 truncated
+  lib/b-1.0 truncated
+  lib/missing >=0 missing
+`},
+		{"reverse", &pkg.DefaultPackage{Category: "lib", Name: "c", Version: "1.0"}, 10, true, `lib/c-1.0
+  lib/a-1.0
+    app/foo-1.0
+  lib/b-1.0
+    app/foo-1.0
+  lib/d-1.0
+    lib/c-1.0 cycle
+`},
+	} {
+		tree := g.Tree(tc.p, tc.depth, tc.reverse)
+		if tree == nil {
+			t.Fatalf("%s: package not found", tc.name)
+		}
+		if got := treeString(tree); got != tc.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", tc.name, got, tc.want)
+		}
+	}
+
+	if g.Tree(&pkg.DefaultPackage{Category: "app", Name: "foo", Version: "2.0"}, 10, false) != nil {
+		t.Errorf("tree of a missing package")
+	}
+}
+
+func TestDependencyTreeSize(t *testing.T) {
+	// A chain of diamonds, every level doubles the paths.
+	levels := 20
+	packages := []testPackage{}
+	for i := 0; i < levels; i++ {
+		next := fmt.Sprintf("lib/top%02d", i+1)
+		packages = append(packages,
+			withRequires(newTestPackage("lib", fmt.Sprintf("top%02d", i), "1.0"),
+				fmt.Sprintf("lib/left%02d", i), fmt.Sprintf("lib/right%02d", i)),
+			withRequires(newTestPackage("lib", fmt.Sprintf("left%02d", i), "1.0"), next),
+			withRequires(newTestPackage("lib", fmt.Sprintf("right%02d", i), "1.0"), next),
+		)
+	}
+	packages = append(packages, newTestPackage("lib", fmt.Sprintf("top%02d", levels), "1.0"))
+	g := NewDependencyGraph(newTestRepository(t, "stable", 1, packages...))
+
+	tree := g.Tree(&pkg.DefaultPackage{Category: "lib", Name: "top00", Version: "1.0"},
+		3*levels, false)
+	// Every package is expanded once, the right packages have a
+	// repeated top package.
+	if got, want := countNodes(tree), 4*levels+1; got != want {
+		t.Errorf("got %d nodes, want %d", got, want)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+
+	"gopkg.in/macaron.v1"
+)
+
+const (
+	healthOk       = "ok"
+	healthDegraded = "degraded"
+	healthDown     = "down"
+)
+
+// Health summarizes the state of the repositories. The status is
+// ok if all the repositories are synced and up to date, degraded
+// if some repositories are stale and down if no repository is synced.
+type Health struct {
+	Status       string `json:"status"`
+	Repositories int    `json:"repositories"`
+	Synced       int    `json:"synced"`
+	Stale        int    `json:"stale"`
+	Failing      int    `json:"failing"`
+}
+
+// Status is the health with the details of every repository.
+type Status struct {
+	Health
+	RepositoriesStatus []*RepositoryStatus `json:"repositories_status"`
+}
+
+func newHealth(snap *Snapshot) Health {
+	ans := Health{Repositories: len(snap.Names)}
+
+	for _, name := range snap.Names {
+		st := snap.GetStatus(name)
+		if st.Synced {
+			ans.Synced++
+		}
+		if st.Stale {
+			ans.Stale++
+		}
+		if st.LastError != "" {
+			ans.Failing++
+		}
+	}
+
+	switch {
+	case ans.Synced == 0 && ans.Repositories > 0:
+		ans.Status = healthDown
+	case ans.Stale > 0 || ans.Synced < ans.Repositories:
+		ans.Status = healthDegraded
+	default:
+		ans.Status = healthOk
+	}
+
+	return ans
+}
+
+func registerStatusRoutes(m *macaron.Macaron) {
+	// The site is usable while at least one repository is synced,
+	// so /health fails only when there is nothing to show.
+	instrumentedGet(m, "/health", func(ctx *macaron.Context) {
+		h := newHealth(getSnapshot())
+		code := http.StatusOK
+		if h.Status == healthDown {
+			code = http.StatusServiceUnavailable
+		}
+		ctx.JSON(code, h)
+	})
+
+	instrumentedGet(m, "/status", func(ctx *macaron.Context) {
+		snap := getSnapshot()
+		ctx.JSON(http.StatusOK, Status{
+			Health:             newHealth(snap),
+			RepositoriesStatus: snap.GetStatusList(),
+		})
+	})
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	installer "github.com/mudler/luet/pkg/installer"
+)
+
+func TestHealth(t *testing.T) {
+	server := newTestServer(t, registerStatusRoutes)
+
+	for _, tc := range []struct {
+		name   string
+		status func(s *Snapshot)
+		code   int
+		want   Health
+	}{
+		{"synced", func(s *Snapshot) {},
+			http.StatusOK,
+			Health{Status: healthOk, Repositories: 2, Synced: 2}},
+		{"failing sync", func(s *Snapshot) {
+			s.Status["testing"].LastError = "timeout"
+			s.Status["testing"].Failures = 1
+		},
+			http.StatusOK,
+			Health{Status: healthDegraded, Repositories: 2, Synced: 2, Stale: 1, Failing: 1}},
+		{"old data", func(s *Snapshot) {
+			s.Status["testing"].LastSync = time.Now().Add(-3 * time.Hour)
+		},
+			http.StatusOK,
+			Health{Status: healthDegraded, Repositories: 2, Synced: 2, Stale: 1}},
+		{"never synced", func(s *Snapshot) {
+			s.Status["testing"].Synced = false
+			delete(s.synced, "testing")
+		},
+			http.StatusOK,
+			Health{Status: healthDegraded, Repositories: 2, Synced: 1}},
+		{"nothing synced", func(s *Snapshot) {
+			for name, st := range s.Status {
+				st.Synced = false
+				st.LastError = "not found"
+				delete(s.synced, name)
+			}
+		},
+			http.StatusServiceUnavailable,
+			Health{Status: healthDown, Repositories: 2, Stale: 2, Failing: 2}},
+		{"no repositories", func(s *Snapshot) {
+			s.Names = []string{}
+			s.Status = map[string]*RepositoryStatus{}
+			s.synced = map[string]*installer.LuetSystemRepository{}
+		},
+			http.StatusOK,
+			Health{Status: healthOk}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			setTestSnapshot(t,
+				newTestRepository(t, "stable", 1),
+				newTestRepository(t, "testing", 1))
+			updateSnapshot(true, tc.status)
+
+			if got := newHealth(getSnapshot()); got != tc.want {
+				t.Errorf("newHealth() = %+v, want %+v", got, tc.want)
+			}
+
+			code, body := getJSON(t, server.URL+"/health")
+			if code != tc.code {
+				t.Errorf("GET /health = %d, want %d", code, tc.code)
+			}
+			if got := body.(map[string]interface{})["status"]; got != tc.want.Status {
+				t.Errorf("GET /health status = %v, want %s", got, tc.want.Status)
+			}
+
+			// The status is always available.
+			code, body = getJSON(t, server.URL+"/status")
+			if code != http.StatusOK {
+				t.Errorf("GET /status = %d, want %d", code, http.StatusOK)
+			}
+			status := body.(map[string]interface{})
+			if got := status["status"]; got != tc.want.Status {
+				t.Errorf("GET /status status = %v, want %s", got, tc.want.Status)
+			}
+			if got := len(status["repositories_status"].([]interface{})); got != tc.want.Repositories {
+				t.Errorf("GET /status repositories = %d, want %d", got, tc.want.Repositories)
+			}
+		})
+	}
+}
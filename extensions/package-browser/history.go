@@ -0,0 +1,393 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	installer "github.com/mudler/luet/pkg/installer"
+	version "github.com/mudler/luet/pkg/versioner"
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	ChangeAdded   = "added"
+	ChangeUpdated = "updated"
+	ChangeRemoved = "removed"
+
+	// Number of changes kept for every repository.
+	historyMaxChanges = 5000
+)
+
+var (
+	snapshotsBucket = []byte("snapshots")
+	changesBucket   = []byte("changes")
+)
+
+// Change is a change of a package between two syncs of a repository.
+type Change struct {
+	Id         uint64    `json:"id"`
+	Repository string    `json:"repository"`
+	Time       time.Time `json:"time"`
+	Revision   int       `json:"revision"`
+	Type       string    `json:"type"`
+	Category   string    `json:"category"`
+	Name       string    `json:"name"`
+	Version    string    `json:"version,omitempty"`
+	OldVersion string    `json:"old_version,omitempty"`
+}
+
+// Title returns a human readable description of the change.
+func (c Change) Title() string {
+	switch c.Type {
+	case ChangeUpdated:
+		return fmt.Sprintf("%s/%s updated from %s to %s in %s",
+			c.Category, c.Name, c.OldVersion, c.Version, c.Repository)
+	case ChangeRemoved:
+		return fmt.Sprintf("%s/%s %s removed from %s",
+			c.Category, c.Name, c.OldVersion, c.Repository)
+	}
+	return fmt.Sprintf("%s/%s %s added to %s",
+		c.Category, c.Name, c.Version, c.Repository)
+}
+
+// Path returns the path of the page of the package.
+func (c Change) Path() string {
+	if c.Version == "" {
+		return "/" + c.Repository + "/" + c.Category + "/" + c.Name
+	}
+	return "/" + c.Repository + "/" + c.Category + "/" + c.Name + "/" + c.Version
+}
+
+// ChangeFilter selects the changes. The empty fields match everything.
+type ChangeFilter struct {
+	Repository string
+	Category   string
+	Name       string
+}
+
+func (f ChangeFilter) Match(c Change) bool {
+	return (f.Repository == "" || f.Repository == c.Repository) &&
+		(f.Category == "" || f.Category == c.Category) &&
+		(f.Name == "" || f.Name == c.Name)
+}
+
+// repositorySnapshot is the persisted state of a repository used
+// to compute the changes of the next sync.
+type repositorySnapshot struct {
+	Revision int `json:"revision"`
+	// category/name -> versions
+	Packages map[string][]string `json:"packages"`
+}
+
+// History persists the last snapshot of every repository and
+// the changes between the syncs.
+type History struct {
+	db *bolt.DB
+}
+
+// history is nil if the history is disabled.
+var history *History
+
+func NewHistory(path string) (*History, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed opening history database "+path)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, b := range [][]byte{snapshotsBucket, changesBucket} {
+			if _, err := tx.CreateBucketIfNotExists(b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, "Failed initializing history database "+path)
+	}
+
+	return &History{db: db}, nil
+}
+
+func (h *History) Close() error {
+	return h.db.Close()
+}
+
+func newRepositorySnapshot(r *installer.LuetSystemRepository) *repositorySnapshot {
+	ans := &repositorySnapshot{
+		Revision: r.GetRevision(),
+		Packages: map[string][]string{},
+	}
+	for _, p := range r.GetTree().GetDatabase().World() {
+		key := p.GetCategory() + "/" + p.GetName()
+		ans.Packages[key] = append(ans.Packages[key], p.GetVersion())
+	}
+	for k, v := range ans.Packages {
+		ans.Packages[k] = version.DefaultVersioner().Sort(v)
+	}
+	return ans
+}
+
+func splitPackageKey(key string) (string, string) {
+	parts := strings.SplitN(key, "/", 2)
+	if len(parts) == 1 {
+		return "", key
+	}
+	return parts[0], parts[1]
+}
+
+func versionsDiff(old, new []string) (added, removed []string) {
+	oldSet := map[string]bool{}
+	newSet := map[string]bool{}
+	for _, v := range old {
+		oldSet[v] = true
+	}
+	for _, v := range new {
+		newSet[v] = true
+		if !oldSet[v] {
+			added = append(added, v)
+		}
+	}
+	for _, v := range old {
+		if !newSet[v] {
+			removed = append(removed, v)
+		}
+	}
+	return
+}
+
+func removeVersion(versions []string, v string) []string {
+	ans := []string{}
+	for _, e := range versions {
+		if e != v {
+			ans = append(ans, e)
+		}
+	}
+	return ans
+}
+
+// diffSnapshots returns the changes between two snapshots. A new latest
+// version of a package is reported as an update of the previous latest
+// version, the other versions as added or removed.
+func diffSnapshots(repo string, old, new *repositorySnapshot, t time.Time) []Change {
+	ans := []Change{}
+
+	keys := map[string]bool{}
+	for k := range old.Packages {
+		keys[k] = true
+	}
+	for k := range new.Packages {
+		keys[k] = true
+	}
+	sortedKeys := []string{}
+	for k := range keys {
+		sortedKeys = append(sortedKeys, k)
+	}
+	sort.Strings(sortedKeys)
+
+	for _, k := range sortedKeys {
+		oldVersions := old.Packages[k]
+		newVersions := new.Packages[k]
+		added, removed := versionsDiff(oldVersions, newVersions)
+		category, name := splitPackageKey(k)
+
+		change := func(typ, version, oldVersion string) {
+			ans = append(ans, Change{
+				Repository: repo,
+				Time:       t,
+				Revision:   new.Revision,
+				Type:       typ,
+				Category:   category,
+				Name:       name,
+				Version:    version,
+				OldVersion: oldVersion,
+			})
+		}
+
+		if len(oldVersions) > 0 && len(newVersions) > 0 {
+			oldLatest := oldVersions[len(oldVersions)-1]
+			newLatest := newVersions[len(newVersions)-1]
+			if oldLatest != newLatest && len(added) > 0 && added[len(added)-1] == newLatest {
+				change(ChangeUpdated, newLatest, oldLatest)
+				added = removeVersion(added, newLatest)
+				removed = removeVersion(removed, oldLatest)
+			}
+		}
+
+		for _, v := range added {
+			change(ChangeAdded, v, "")
+		}
+		for _, v := range removed {
+			change(ChangeRemoved, "", v)
+		}
+	}
+
+	return ans
+}
+
+func itob(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
+
+// Record stores the snapshot of the synced repository and the changes
+// from the previous snapshot. The first snapshot of a repository
+// doesn't generate changes.
+func (h *History) Record(name string, snap *repositorySnapshot) ([]Change, error) {
+	changes := []Change{}
+
+	err := h.db.Update(func(tx *bolt.Tx) error {
+		snapshots := tx.Bucket(snapshotsBucket)
+
+		if data := snapshots.Get([]byte(name)); data != nil {
+			old := &repositorySnapshot{}
+			if err := json.Unmarshal(data, old); err != nil {
+				return errors.Wrap(err, "Invalid snapshot of repository "+name)
+			}
+			changes = diffSnapshots(name, old, snap, time.Now().UTC())
+		}
+
+		data, err := json.Marshal(snap)
+		if err != nil {
+			return err
+		}
+		if err := snapshots.Put([]byte(name), data); err != nil {
+			return err
+		}
+
+		if len(changes) == 0 {
+			return nil
+		}
+
+		b, err := tx.Bucket(changesBucket).CreateBucketIfNotExists([]byte(name))
+		if err != nil {
+			return err
+		}
+		var last uint64
+		for i := range changes {
+			id, err := b.NextSequence()
+			if err != nil {
+				return err
+			}
+			changes[i].Id = id
+			data, err := json.Marshal(changes[i])
+			if err != nil {
+				return err
+			}
+			if err := b.Put(itob(id), data); err != nil {
+				return err
+			}
+			last = id
+		}
+
+		// Drop the oldest changes.
+		if last > historyMaxChanges {
+			min := itob(last - historyMaxChanges)
+			c := b.Cursor()
+			for k, _ := c.First(); k != nil && bytes.Compare(k, min) <= 0; k, _ = c.First() {
+				if err := c.Delete(); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed recording history of repository "+name)
+	}
+
+	return changes, nil
+}
+
+// PruneSnapshots deletes the snapshots of the repositories not in
+// names, a repository added again starts without changes. The
+// changes are kept.
+func (h *History) PruneSnapshots(names []string) error {
+	keep := map[string]bool{}
+	for _, n := range names {
+		keep[n] = true
+	}
+
+	err := h.db.Update(func(tx *bolt.Tx) error {
+		snapshots := tx.Bucket(snapshotsBucket)
+		removed := []string{}
+		err := snapshots.ForEach(func(k, v []byte) error {
+			if !keep[string(k)] {
+				removed = append(removed, string(k))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, n := range removed {
+			if err := snapshots.Delete([]byte(n)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "Failed pruning history snapshots")
+	}
+	return nil
+}
+
+// Changes returns the latest changes matching the filter,
+// the newest first.
+func (h *History) Changes(filter ChangeFilter, limit int) ([]Change, error) {
+	ans := []Change{}
+
+	err := h.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(changesBucket).ForEach(func(k, v []byte) error {
+			if filter.Repository != "" && filter.Repository != string(k) {
+				return nil
+			}
+			b := tx.Bucket(changesBucket).Bucket(k)
+			if b == nil {
+				return nil
+			}
+
+			n := 0
+			c := b.Cursor()
+			for k, v := c.Last(); k != nil && n < limit; k, v = c.Prev() {
+				change := Change{}
+				if err := json.Unmarshal(v, &change); err != nil {
+					return err
+				}
+				if filter.Match(change) {
+					ans = append(ans, change)
+					n++
+				}
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed reading history")
+	}
+
+	sort.SliceStable(ans, func(i, j int) bool {
+		if !ans[i].Time.Equal(ans[j].Time) {
+			return ans[i].Time.After(ans[j].Time)
+		}
+		if ans[i].Repository != ans[j].Repository {
+			return ans[i].Repository < ans[j].Repository
+		}
+		return ans[i].Id < ans[j].Id
+	})
+	if len(ans) > limit {
+		ans = ans[:limit]
+	}
+
+	return ans, nil
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newTestHistory(t *testing.T) *History {
+	dir, err := ioutil.TempDir("", "package-browser")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	h, err := NewHistory(filepath.Join(dir, "history.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { h.Close() })
+	return h
+}
+
+func newTestSnapshot(revision int, packages map[string][]string) *repositorySnapshot {
+	return &repositorySnapshot{Revision: revision, Packages: packages}
+}
+
+func TestDiffSnapshots(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		old, new map[string][]string
+		want     []string
+	}{
+		{"unchanged",
+			map[string][]string{"app/foo": {"1.0"}},
+			map[string][]string{"app/foo": {"1.0"}},
+			[]string{}},
+		{"new package",
+			map[string][]string{},
+			map[string][]string{"app/foo": {"1.0", "1.1"}},
+			[]string{"added app/foo 1.0 ", "added app/foo 1.1 "}},
+		{"removed package",
+			map[string][]string{"app/foo": {"1.0"}},
+			map[string][]string{},
+			[]string{"removed app/foo  1.0"}},
+		{"new latest version",
+			map[string][]string{"app/foo": {"1.0"}},
+			map[string][]string{"app/foo": {"2.0"}},
+			[]string{"updated app/foo 2.0 1.0"}},
+		{"new latest version keeping the old one",
+			map[string][]string{"app/foo": {"1.0"}},
+			map[string][]string{"app/foo": {"1.0", "2.0"}},
+			[]string{"updated app/foo 2.0 1.0"}},
+		{"new older version",
+			map[string][]string{"app/foo": {"2.0"}},
+			map[string][]string{"app/foo": {"1.0", "2.0"}},
+			[]string{"added app/foo 1.0 "}},
+		{"latest version removed",
+			map[string][]string{"app/foo": {"1.0", "2.0"}},
+			map[string][]string{"app/foo": {"1.0"}},
+			[]string{"removed app/foo  2.0"}},
+		{"update with other versions",
+			map[string][]string{"app/foo": {"1.0", "1.1"}},
+			map[string][]string{"app/foo": {"1.5", "2.0"}},
+			[]string{
+				"updated app/foo 2.0 1.1",
+				"added app/foo 1.5 ",
+				"removed app/foo  1.0",
+			}},
+		{"packages sorted",
+			map[string][]string{"lib/bar": {"1.0"}},
+			map[string][]string{"app/foo": {"1.0"}},
+			[]string{"added app/foo 1.0 ", "removed lib/bar  1.0"}},
+	} {
+		now := time.Now()
+		changes := diffSnapshots("stable",
+			newTestSnapshot(1, tc.old), newTestSnapshot(2, tc.new), now)
+
+		got := []string{}
+		for _, c := range changes {
+			if c.Repository != "stable" || c.Revision != 2 || !c.Time.Equal(now) {
+				t.Errorf("%s: invalid change %+v", tc.name, c)
+			}
+			got = append(got, fmt.Sprintf("%s %s/%s %s %s",
+				c.Type, c.Category, c.Name, c.Version, c.OldVersion))
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestNewRepositorySnapshot(t *testing.T) {
+	snap := newRepositorySnapshot(newTestRepository(t, "stable", 3,
+		newTestPackage("app", "foo", "1.10"),
+		newTestPackage("app", "foo", "1.9"),
+		newTestPackage("lib", "bar", "1.0"),
+	))
+
+	want := newTestSnapshot(3, map[string][]string{
+		"app/foo": {"1.9", "1.10"},
+		"lib/bar": {"1.0"},
+	})
+	if !reflect.DeepEqual(snap, want) {
+		t.Errorf("got %+v, want %+v", snap, want)
+	}
+}
+
+func TestHistoryRecord(t *testing.T) {
+	h := newTestHistory(t)
+
+	changes, err := h.Record("stable", newTestSnapshot(1, map[string][]string{
+		"app/foo": {"1.0"},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 {
+		t.Errorf("first snapshot: got %d changes, want 0", len(changes))
+	}
+
+	changes, err = h.Record("stable", newTestSnapshot(2, map[string][]string{
+		"app/foo": {"1.1"},
+		"app/bar": {"1.0"},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 2 || changes[0].Id != 1 || changes[1].Id != 2 {
+		t.Errorf("second snapshot: got %+v", changes)
+	}
+
+	stored, err := h.Changes(ChangeFilter{Name: "foo"}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 1 || stored[0].Type != ChangeUpdated {
+		t.Errorf("stored changes: got %+v", stored)
+	}
+}
+
+func TestHistoryMaxChanges(t *testing.T) {
+	h := newTestHistory(t)
+
+	// The packages are added and removed, every package
+	// generates two changes.
+	packages := map[string][]string{}
+	for i := 0; i < historyMaxChanges/2+500; i++ {
+		packages[fmt.Sprintf("app/pkg%05d", i)] = []string{"1.0"}
+	}
+	for i, snap := range []*repositorySnapshot{
+		newTestSnapshot(1, map[string][]string{}),
+		newTestSnapshot(2, packages),
+		newTestSnapshot(3, map[string][]string{}),
+	} {
+		if _, err := h.Record("stable", snap); err != nil {
+			t.Fatalf("snapshot %d: %s", i, err.Error())
+		}
+	}
+
+	changes, err := h.Changes(ChangeFilter{}, 2*historyMaxChanges)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != historyMaxChanges {
+		t.Fatalf("got %d changes, want %d", len(changes), historyMaxChanges)
+	}
+	// The oldest changes are dropped.
+	total := uint64(2 * len(packages))
+	first, last := changes[0].Id, changes[0].Id
+	for _, c := range changes {
+		if c.Id < first {
+			first = c.Id
+		}
+		if c.Id > last {
+			last = c.Id
+		}
+	}
+	if first != total-historyMaxChanges+1 || last != total {
+		t.Errorf("got changes from %d to %d, want from %d to %d",
+			first, last, total-historyMaxChanges+1, total)
+	}
+}
+
+func TestHistoryPruneSnapshots(t *testing.T) {
+	h := newTestHistory(t)
+
+	for _, repo := range []string{"stable", "testing"} {
+		if _, err := h.Record(repo, newTestSnapshot(1, map[string][]string{
+			"app/foo": {"1.0"},
+		})); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := h.PruneSnapshots([]string{"stable"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The removed repository is added again with other packages.
+	for repo, want := range map[string]int{"stable": 1, "testing": 0} {
+		changes, err := h.Record(repo, newTestSnapshot(2, map[string][]string{
+			"app/bar": {"1.0"},
+			"app/foo": {"1.0"},
+		}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(changes) != want {
+			t.Errorf("%s: got %d changes, want %d", repo, len(changes), want)
+		}
+	}
+}
@@ -2,13 +2,11 @@ package main
 
 import (
 	"fmt"
-	"io/ioutil"
-	"log"
+	"html/template"
 	"net/http"
 	"os"
 	"sort"
 	"strconv"
-	"sync"
 	"time"
 
 	config "github.com/mudler/luet/pkg/config"
@@ -16,8 +14,6 @@ import (
 	installer "github.com/mudler/luet/pkg/installer"
 	. "github.com/mudler/luet/pkg/logger"
 	pkg "github.com/mudler/luet/pkg/package"
-	"github.com/narqo/go-badge"
-	"github.com/pkg/errors"
 	"gopkg.in/macaron.v1"
 	"gopkg.in/yaml.v2"
 )
@@ -26,127 +22,133 @@ const (
 	Version = "0.2"
 )
 
-var lock = &sync.Mutex{}
-var Repositories installer.Repositories
-
-func refreshRepositories(repos installer.Repositories) (installer.Repositories, error) {
-	syncedRepos := installer.Repositories{}
-	for _, r := range repos {
-		repo, err := r.Sync(false)
-		if err != nil {
-			return nil, errors.Wrap(err, "Failed syncing repository: "+r.GetName())
-		}
-		syncedRepos = append(syncedRepos, repo)
+// basePath is the path of the browser behind a reverse proxy.
+var basePath string
+
+func GetRepo(r Repository) (*installer.LuetSystemRepository, error) {
+	// config.LuetRepository can't be marshaled, it has fields
+	// ignored with the same key.
+	data, err := yaml.Marshal(map[string]interface{}{
+		"name": r.Name,
+		"type": r.Type,
+		"urls": []string{r.Url},
+		"auth": r.Auth,
+	})
+	if err != nil {
+		return nil, err
 	}
-
-	// compute what to install and from where
-	sort.Sort(syncedRepos)
-
-	return syncedRepos, nil
+	return installer.NewLuetSystemRepositoryFromYaml(data, pkg.NewInMemoryDatabase(false))
 }
 
-func GetRepo(name, url, t string) (*installer.LuetSystemRepository, error) {
-	if t == "" {
-		t = "http"
+// withBasePath serves the handler under the base path.
+func withBasePath(base string, h http.Handler) http.Handler {
+	if base == "" {
+		return h
 	}
-	return installer.NewLuetSystemRepositoryFromYaml([]byte(`
-name: "`+name+`"
-type: "`+t+`"
-urls:
-- "`+url+`"`), pkg.NewInMemoryDatabase(false))
-}
-
-type Repository struct {
-	Name, Url, Type, Github, Description string
-}
-type Meta struct {
-	Repositories []Repository
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == base {
+			http.Redirect(w, req, base+"/", http.StatusMovedPermanently)
+			return
+		}
+		http.StripPrefix(base, h).ServeHTTP(w, req)
+	})
 }
 
-func syncRepos(repos installer.Repositories) {
-	lock.Lock()
-	defer lock.Unlock()
-	dir, err := ioutil.TempDir(os.TempDir(), "example")
-	if err != nil {
-		fmt.Println("failed refreshing repository", err)
+// instrumentedGet registers a GET route that records the latency of
+// the requests labeled with the pattern of the route.
+func instrumentedGet(m *macaron.Macaron, pattern string, h ...macaron.Handler) {
+	instrument := func(ctx *macaron.Context) {
+		start := time.Now()
+		ctx.Next()
+		httpDuration.WithLabelValues(
+			pattern, ctx.Req.Method, strconv.Itoa(ctx.Resp.Status()),
+		).Observe(time.Since(start).Seconds())
 	}
-	defer os.RemoveAll(dir)
+	m.Get(pattern, append([]macaron.Handler{instrument}, h...)...)
+}
 
-	config.LuetCfg.System.TmpDirBase = dir
-	config.LuetCfg.GetLogging().Color = false
-	config.LuetCfg.GetGeneral().Debug = true
-	InitAurora()
-	repos, err = refreshRepositories(repos)
-	if err != nil {
-		fmt.Println("failed refreshing repository", err)
-	}
-	Repositories = repos
+var templateFuncs = template.FuncMap{
+	// dict creates a map from the key value pairs to pass
+	// more values to a nested template.
+	"dict": func(values ...interface{}) map[string]interface{} {
+		ans := map[string]interface{}{}
+		for i := 0; i+1 < len(values); i += 2 {
+			ans[fmt.Sprint(values[i])] = values[i+1]
+		}
+		return ans
+	},
 }
 
 func main() {
-	var metadata Meta
 	configFile := os.Getenv("CONFIG")
 	if len(configFile) == 0 {
 		configFile = "config.yaml"
 	}
 
-	sleepDuration := 960
-	sleepTime := os.Getenv("SLEEPTIME")
-	if sleepTime != "" {
-		data, err := strconv.Atoi(sleepTime)
-		if err == nil {
-			sleepDuration = data
-		}
-	}
-
-	yamlFile, err := ioutil.ReadFile(configFile)
-	if err != nil {
-		panic(fmt.Sprintf("yamlFile.Get err   #%v ", err))
-	}
-	err = yaml.Unmarshal(yamlFile, &metadata)
+	cfg, err := LoadConfig(configFile)
 	if err != nil {
-		panic(fmt.Sprintf("Unmarshal err   #%v ", err))
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
+	basePath = cfg.Server.BasePath
 
-	additionalData := map[string]map[string]string{}
+	config.LuetCfg.GetLogging().Color = false
+	config.LuetCfg.GetGeneral().Debug = true
+	InitAurora()
 
-	repos := installer.Repositories{}
-	for _, r := range metadata.Repositories {
-		repo, err := GetRepo(r.Name, r.Url, r.Type)
-		if err != nil {
-			fmt.Println("Failed getting repo ", repo, err)
-			continue
-		}
-		additionalData[r.Name] = make(map[string]string)
-		additionalData[r.Name]["github"] = r.Github
-		additionalData[r.Name]["description"] = r.Description
-		additionalData[r.Name]["url"] = r.Url
-		additionalData[r.Name]["type"] = r.Type
-		repos = append(repos, repo)
+	luetDir, err := initLuetSystem()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
+	defer os.RemoveAll(luetDir)
 
-	go func() {
-		for {
-			syncRepos(repos)
-			time.Sleep(time.Duration(sleepDuration) * time.Second)
-		}
-	}()
-
-	templatesDir := os.Getenv("TEMPLATES_DIR")
-	if templatesDir == "" {
-		templatesDir = "/usr/share/luet-package-browser"
+	history, err = NewHistory(cfg.Server.HistoryDb)
+	if err != nil {
+		fmt.Println("History disabled:", err)
 	}
 
+	applyRepositories(cfg.Repositories)
+	go watchConfig(configFile, cfg)
+
 	m := macaron.Classic()
 	m.Use(macaron.Renderer(macaron.RenderOptions{
 		// Directory to load templates. Default is "templates".
-		Directory: templatesDir,
+		Directory: cfg.Server.TemplatesDir,
+		Funcs:     []template.FuncMap{templateFuncs},
 	}))
+	m.Use(func(ctx *macaron.Context) {
+		ctx.Data["BasePath"] = basePath
+	})
 	// Routes
-	m.Get("/:repository", func(ctx *macaron.Context) {
-		lock.Lock()
-		defer lock.Unlock()
-		for _, r := range Repositories {
+	registerApiRoutes(m)
+	registerStatusRoutes(m)
+	registerChangesRoutes(m)
+	registerMetricsRoutes(m)
+	registerGraphRoutes(m)
+	registerCompareRoutes(m)
+	registerBadgeRoutes(m)
+
+	instrumentedGet(m, "/search", func(ctx *macaron.Context) {
+		snap := getSnapshot()
+
+		ctx.Data["Query"] = ctx.Query("q")
+		ctx.Data["Results"] = snap.Index.Search(ctx.Query("q"))
+		ctx.HTML(200, "search")
+	})
+
+	instrumentedGet(m, "/owner", func(ctx *macaron.Context) {
+		snap := getSnapshot()
+
+		ctx.Data["Query"] = ctx.Query("file")
+		ctx.Data["FileSearch"] = true
+		ctx.Data["Owners"] = snap.Index.Owners(ctx.Query("file"))
+		ctx.HTML(200, "search")
+	})
+
+	instrumentedGet(m, "/:repository", func(ctx *macaron.Context) {
+		snap := getSnapshot()
+		for _, r := range snap.Repositories {
 			if r.GetName() == ctx.Params(":repository") {
 				packs := r.GetTree().GetDatabase().World()
 				sort.SliceStable(packs, func(i, j int) bool {
@@ -155,38 +157,17 @@ func main() {
 				ctx.Data["Packages"] = packs
 			}
 		}
-		ctx.Data["AdditionalData"] = additionalData
+		ctx.Data["AdditionalData"] = snap.Data
 		ctx.Data["RepositoryName"] = ctx.Params(":repository")
+		ctx.Data["Status"] = snap.GetStatus(ctx.Params(":repository"))
 		ctx.HTML(200, "repository")
 	})
 
-	m.Get("/badge/:repository", func(w http.ResponseWriter, ctx *macaron.Context) {
-		lock.Lock()
-		defer lock.Unlock()
-
-		var packN int
-		for _, r := range Repositories {
-			if r.GetName() == ctx.Params(":repository") {
-				packN = len(r.GetIndex())
-			}
-		}
-		badge, err := badge.RenderBytes(strconv.Itoa(packN), ctx.Params(":repository"), "#3C1")
-		if err != nil {
-			panic(err)
-		}
-		w.Header().Set("Content-Type", "image/svg+xml")
-		w.Header().Set("Content-Length", strconv.Itoa(len(badge)))
-		if _, err := w.Write(badge); err != nil {
-			log.Println("unable to write image.")
-		}
-	})
-
-	m.Get("/:repository/:packagecategory/:packagename", func(ctx *macaron.Context) {
-		lock.Lock()
-		defer lock.Unlock()
+	instrumentedGet(m, "/:repository/:packagecategory/:packagename", func(ctx *macaron.Context) {
+		snap := getSnapshot()
 		packs := map[string][]pkg.Package{}
 
-		for _, r := range Repositories {
+		for _, r := range snap.Repositories {
 			if r.GetName() == ctx.Params(":repository") {
 
 				packages, err := r.GetTree().GetDatabase().FindPackages(&pkg.DefaultPackage{
@@ -211,12 +192,11 @@ func main() {
 		ctx.HTML(200, "packages")
 	})
 
-	m.Get("/find/:packagecategory/:packagename", func(ctx *macaron.Context) {
-		lock.Lock()
-		defer lock.Unlock()
+	instrumentedGet(m, "/find/:packagecategory/:packagename", func(ctx *macaron.Context) {
+		snap := getSnapshot()
 		packs := map[string][]pkg.Package{}
 
-		for _, r := range Repositories {
+		for _, r := range snap.Repositories {
 			packages, err := r.GetTree().GetDatabase().FindPackages(&pkg.DefaultPackage{
 				Name:     ctx.Params(":packagename"),
 				Category: ctx.Params(":packagecategory"),
@@ -237,9 +217,8 @@ func main() {
 		ctx.HTML(200, "packages")
 	})
 
-	m.Get("/:repository/:packagecategory/:packagename/:packageversion", func(ctx *macaron.Context) {
-		lock.Lock()
-		defer lock.Unlock()
+	instrumentedGet(m, "/:repository/:packagecategory/:packagename/:packageversion", func(ctx *macaron.Context) {
+		snap := getSnapshot()
 		var pack pkg.Package
 
 		find := &pkg.DefaultPackage{
@@ -247,7 +226,7 @@ func main() {
 			Category: ctx.Params(":packagecategory"),
 			Version:  ctx.Params(":packageversion"),
 		}
-		for _, r := range Repositories {
+		for _, r := range snap.Repositories {
 			if r.GetName() == ctx.Params(":repository") {
 				for _, a := range r.GetIndex() {
 					if a.CompileSpec.GetPackage().GetFingerPrint() == find.GetFingerPrint() {
@@ -263,25 +242,38 @@ func main() {
 		ctx.HTML(200, "package")
 	})
 
-	m.Get("/", func(ctx *macaron.Context) {
-		lock.Lock()
-		defer lock.Unlock()
+	instrumentedGet(m, "/", func(ctx *macaron.Context) {
+		snap := getSnapshot()
 
 		packs := map[string][]pkg.Package{}
 
-		for _, r := range Repositories {
+		for _, r := range snap.Repositories {
 			packages := r.GetTree().GetDatabase().World()
 			for _, p := range packages {
 				packs[r.GetName()] = append(packs[r.GetName()], p)
 			}
 		}
 
-		ctx.Data["AdditionalData"] = additionalData
+		ctx.Data["AdditionalData"] = snap.Data
 		ctx.Data["Packages"] = packs
-		ctx.Data["Repositories"] = Repositories
+		ctx.Data["Repositories"] = snap.GetStatusList()
 		ctx.HTML(200, "index")
 	})
 
-	fmt.Printf("Starting luet-package-browser v%s\n", Version)
-	m.Run()
+	server := &http.Server{
+		Addr:    cfg.Server.Listen,
+		Handler: withBasePath(basePath, m),
+	}
+
+	fmt.Printf("Starting luet-package-browser v%s on %s%s\n", Version, cfg.Server.Listen, basePath)
+	if cfg.Server.TLSCert != "" {
+		err = server.ListenAndServeTLS(cfg.Server.TLSCert, cfg.Server.TLSKey)
+	} else {
+		err = server.ListenAndServe()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.RemoveAll(luetDir)
+		os.Exit(1)
+	}
 }
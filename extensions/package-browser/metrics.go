@@ -0,0 +1,142 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"gopkg.in/macaron.v1"
+)
+
+const metricsNamespace = "luet_package_browser"
+
+var (
+	syncDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "sync_duration_seconds",
+		Help:      "Duration of the syncs of the repositories.",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 2, 12),
+	}, []string{"repository"})
+
+	syncTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "syncs_total",
+		Help:      "Number of syncs of the repositories.",
+	}, []string{"repository"})
+
+	syncFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "sync_failures_total",
+		Help:      "Number of failed syncs of the repositories.",
+	}, []string{"repository"})
+
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of the HTTP requests by route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "code"})
+)
+
+var (
+	repositoryPackagesDesc = prometheus.NewDesc(
+		metricsNamespace+"_repository_packages",
+		"Number of packages of the repository.",
+		[]string{"repository"}, nil)
+	repositoryArtefactsDesc = prometheus.NewDesc(
+		metricsNamespace+"_repository_artefacts",
+		"Number of artefacts in the index of the repository.",
+		[]string{"repository"}, nil)
+	repositoryRevisionDesc = prometheus.NewDesc(
+		metricsNamespace+"_repository_revision",
+		"Revision of the repository.",
+		[]string{"repository"}, nil)
+	repositoryLastSyncDesc = prometheus.NewDesc(
+		metricsNamespace+"_repository_last_sync_timestamp_seconds",
+		"Unix time of the last successful sync of the repository.",
+		[]string{"repository"}, nil)
+	repositoryUpDesc = prometheus.NewDesc(
+		metricsNamespace+"_repository_up",
+		"1 if the last sync of the repository succeeded.",
+		[]string{"repository"}, nil)
+	repositoryStaleDesc = prometheus.NewDesc(
+		metricsNamespace+"_repository_stale",
+		"1 if the data of the repository are stale.",
+		[]string{"repository"}, nil)
+	repositoryFailuresDesc = prometheus.NewDesc(
+		metricsNamespace+"_repository_consecutive_failures",
+		"Number of consecutive failed syncs of the repository.",
+		[]string{"repository"}, nil)
+	searchIndexDesc = prometheus.NewDesc(
+		metricsNamespace+"_search_index_size",
+		"Number of entries of the search index by kind.",
+		[]string{"kind"}, nil)
+)
+
+// snapshotCollector exports the state of the current snapshot.
+type snapshotCollector struct{}
+
+func (c snapshotCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- repositoryPackagesDesc
+	ch <- repositoryArtefactsDesc
+	ch <- repositoryRevisionDesc
+	ch <- repositoryLastSyncDesc
+	ch <- repositoryUpDesc
+	ch <- repositoryStaleDesc
+	ch <- repositoryFailuresDesc
+	ch <- searchIndexDesc
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (c snapshotCollector) Collect(ch chan<- prometheus.Metric) {
+	snap := getSnapshot()
+
+	for _, st := range snap.GetStatusList() {
+		ch <- prometheus.MustNewConstMetric(repositoryUpDesc,
+			prometheus.GaugeValue, boolToFloat(st.Synced && st.LastError == ""), st.Name)
+		ch <- prometheus.MustNewConstMetric(repositoryStaleDesc,
+			prometheus.GaugeValue, boolToFloat(st.Stale), st.Name)
+		ch <- prometheus.MustNewConstMetric(repositoryFailuresDesc,
+			prometheus.GaugeValue, float64(st.Failures), st.Name)
+
+		r := snap.GetRepository(st.Name)
+		if r == nil {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(repositoryLastSyncDesc,
+			prometheus.GaugeValue, float64(st.LastSync.Unix()), st.Name)
+		ch <- prometheus.MustNewConstMetric(repositoryPackagesDesc,
+			prometheus.GaugeValue, float64(len(r.GetTree().GetDatabase().World())), st.Name)
+		ch <- prometheus.MustNewConstMetric(repositoryArtefactsDesc,
+			prometheus.GaugeValue, float64(len(r.GetIndex())), st.Name)
+		ch <- prometheus.MustNewConstMetric(repositoryRevisionDesc,
+			prometheus.GaugeValue, float64(r.GetRevision()), st.Name)
+	}
+
+	docs, terms, files := snap.Index.Size()
+	ch <- prometheus.MustNewConstMetric(searchIndexDesc, prometheus.GaugeValue, float64(docs), "documents")
+	ch <- prometheus.MustNewConstMetric(searchIndexDesc, prometheus.GaugeValue, float64(terms), "terms")
+	ch <- prometheus.MustNewConstMetric(searchIndexDesc, prometheus.GaugeValue, float64(files), "files")
+}
+
+func init() {
+	prometheus.MustRegister(syncDuration, syncTotal, syncFailures, httpDuration, snapshotCollector{})
+}
+
+func observeSync(repo string, d time.Duration, err error) {
+	syncTotal.WithLabelValues(repo).Inc()
+	syncDuration.WithLabelValues(repo).Observe(d.Seconds())
+	if err != nil {
+		syncFailures.WithLabelValues(repo).Inc()
+	}
+}
+
+func registerMetricsRoutes(m *macaron.Macaron) {
+	m.Get("/metrics", promhttp.Handler().ServeHTTP)
+}
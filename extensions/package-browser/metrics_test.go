@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/macaron.v1"
+)
+
+func TestMetrics(t *testing.T) {
+	setTestSnapshot(t,
+		newTestRepository(t, "stable", 3,
+			newTestPackage("app", "foo", "1.0", "usr/bin/foo"),
+			newTestPackage("app", "bar", "1.0")),
+		newTestRepository(t, "testing", 1))
+	updateSnapshot(true, func(s *Snapshot) {
+		st := s.Status["testing"]
+		st.Synced = false
+		st.LastError = "timeout"
+		st.Failures = 2
+		delete(s.synced, "testing")
+	})
+
+	server := newTestServer(t, func(m *macaron.Macaron) {
+		registerMetricsRoutes(m)
+		instrumentedGet(m, "/metrics-test/:name", func(ctx *macaron.Context) {
+			if ctx.Params(":name") == "missing" {
+				ctx.Error(http.StatusNotFound)
+				return
+			}
+			ctx.PlainText(http.StatusOK, []byte("ok"))
+		})
+	})
+
+	for _, p := range []string{"/metrics-test/foo", "/metrics-test/bar", "/metrics-test/missing"} {
+		resp, err := http.Get(server.URL + p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	observeSync("metrics-test", time.Second, nil)
+	observeSync("metrics-test", time.Second, errors.New("timeout"))
+
+	resp, err := http.Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /metrics = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	metrics := map[string]bool{}
+	for _, l := range strings.Split(string(data), "\n") {
+		metrics[l] = true
+	}
+
+	for _, want := range []string{
+		// The routes are labeled with the pattern.
+		`luet_package_browser_http_request_duration_seconds_count{code="200",method="GET",route="/metrics-test/:name"} 2`,
+		`luet_package_browser_http_request_duration_seconds_count{code="404",method="GET",route="/metrics-test/:name"} 1`,
+		`luet_package_browser_syncs_total{repository="metrics-test"} 2`,
+		`luet_package_browser_sync_failures_total{repository="metrics-test"} 1`,
+		`luet_package_browser_sync_duration_seconds_count{repository="metrics-test"} 2`,
+		`luet_package_browser_repository_up{repository="stable"} 1`,
+		`luet_package_browser_repository_stale{repository="stable"} 0`,
+		`luet_package_browser_repository_consecutive_failures{repository="stable"} 0`,
+		`luet_package_browser_repository_packages{repository="stable"} 2`,
+		`luet_package_browser_repository_artefacts{repository="stable"} 2`,
+		`luet_package_browser_repository_revision{repository="stable"} 3`,
+		`luet_package_browser_repository_up{repository="testing"} 0`,
+		`luet_package_browser_repository_stale{repository="testing"} 1`,
+		`luet_package_browser_repository_consecutive_failures{repository="testing"} 2`,
+		`luet_package_browser_search_index_size{kind="documents"} 2`,
+		// The files are indexed by path and by basename.
+		`luet_package_browser_search_index_size{kind="files"} 2`,
+	} {
+		if !metrics[want] {
+			t.Errorf("metric %s not found", want)
+		}
+	}
+
+	// The data of the repositories not synced are not exported.
+	for _, notWant := range []string{
+		`luet_package_browser_repository_packages{repository="testing"}`,
+		`luet_package_browser_repository_last_sync_timestamp_seconds{repository="testing"}`,
+	} {
+		if strings.Contains(string(data), notWant) {
+			t.Errorf("metric %s found, want it missing", notWant)
+		}
+	}
+}
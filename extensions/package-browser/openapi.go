@@ -0,0 +1,240 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/macaron.v1"
+)
+
+// ApiParamDoc describes a query parameter of an API route.
+type ApiParamDoc struct {
+	Name        string
+	Description string
+	// Type of the parameter. Default is string.
+	Type string
+}
+
+// ApiRouteDoc describes an API route for the OpenAPI document.
+// The schema of the response is generated from the Response value.
+type ApiRouteDoc struct {
+	Summary  string
+	Query    []ApiParamDoc
+	Response interface{}
+	// Content type of the response. Default is application/json.
+	ContentType string
+}
+
+type apiRoute struct {
+	Method string
+	Path   string
+	Doc    ApiRouteDoc
+}
+
+// ApiRouter registers the API routes on macaron and keeps the
+// documentation of the routes to generate the OpenAPI document.
+type ApiRouter struct {
+	m      *macaron.Macaron
+	prefix string
+	routes []apiRoute
+}
+
+var pathParamRegex = regexp.MustCompile(`:([a-zA-Z0-9_]+)`)
+
+func NewApiRouter(m *macaron.Macaron, prefix string) *ApiRouter {
+	return &ApiRouter{m: m, prefix: prefix, routes: []apiRoute{}}
+}
+
+func (a *ApiRouter) Get(path string, doc ApiRouteDoc, h ...macaron.Handler) {
+	a.routes = append(a.routes, apiRoute{Method: "get", Path: path, Doc: doc})
+	instrumentedGet(a.m, a.prefix+path, h...)
+}
+
+// ServeOpenApi registers the route that returns the OpenAPI document
+// of the routes registered until now.
+func (a *ApiRouter) ServeOpenApi(path, title, version string) {
+	doc := a.OpenApi(title, version)
+	instrumentedGet(a.m, a.prefix+path, func(ctx *macaron.Context) {
+		ctx.JSON(http.StatusOK, doc)
+	})
+}
+
+// OpenApi generates the OpenAPI 3 document of the routes.
+func (a *ApiRouter) OpenApi(title, version string) map[string]interface{} {
+	schemas := map[string]interface{}{}
+	paths := map[string]interface{}{}
+
+	for _, r := range a.routes {
+		params := []interface{}{}
+
+		for _, p := range pathParamRegex.FindAllStringSubmatch(r.Path, -1) {
+			params = append(params, map[string]interface{}{
+				"name":     p[1],
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]interface{}{"type": "string"},
+			})
+		}
+
+		for _, q := range r.Doc.Query {
+			t := q.Type
+			if t == "" {
+				t = "string"
+			}
+			params = append(params, map[string]interface{}{
+				"name":        q.Name,
+				"in":          "query",
+				"description": q.Description,
+				"schema":      map[string]interface{}{"type": t},
+			})
+		}
+
+		contentType := r.Doc.ContentType
+		if contentType == "" {
+			contentType = "application/json"
+		}
+
+		content := map[string]interface{}{}
+		if r.Doc.Response != nil {
+			content["schema"] = jsonSchema(reflect.TypeOf(r.Doc.Response), schemas)
+		} else {
+			content["schema"] = map[string]interface{}{"type": "string"}
+		}
+
+		responses := map[string]interface{}{
+			"200": map[string]interface{}{
+				"description": "Successful response",
+				"content": map[string]interface{}{
+					contentType: content,
+				},
+			},
+		}
+		if len(pathParamRegex.FindAllString(r.Path, -1)) > 0 {
+			responses["404"] = map[string]interface{}{
+				"description": "Resource not found",
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{
+						"schema": jsonSchema(reflect.TypeOf(ApiError{}), schemas),
+					},
+				},
+			}
+		}
+
+		path := a.prefix + pathParamRegex.ReplaceAllString(r.Path, "{$1}")
+		item, ok := paths[path].(map[string]interface{})
+		if !ok {
+			item = map[string]interface{}{}
+			paths[path] = item
+		}
+		item[r.Method] = map[string]interface{}{
+			"summary":    r.Doc.Summary,
+			"parameters": params,
+			"responses":  responses,
+		}
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   title,
+			"version": version,
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+		},
+	}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// jsonSchema returns the JSON schema of the type. The named structs
+// are added to the schemas map and referenced.
+func jsonSchema(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case t.Kind() == reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case t.Kind() == reflect.Slice || t.Kind() == reflect.Array:
+		return map[string]interface{}{
+			"type":  "array",
+			"items": jsonSchema(t.Elem(), schemas),
+		}
+	case t.Kind() == reflect.Map:
+		return map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": jsonSchema(t.Elem(), schemas),
+		}
+	case t.Kind() == reflect.Struct:
+		ref := map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+		if _, ok := schemas[t.Name()]; ok || t.Name() == "" {
+			if t.Name() != "" {
+				return ref
+			}
+		} else {
+			// Register the name before to visit the fields
+			// to support recursive types.
+			schemas[t.Name()] = nil
+		}
+
+		properties := map[string]interface{}{}
+		structSchemaFields(t, properties, schemas)
+		schema := map[string]interface{}{
+			"type":       "object",
+			"properties": properties,
+		}
+
+		if t.Name() == "" {
+			return schema
+		}
+		schemas[t.Name()] = schema
+		return ref
+	}
+
+	return map[string]interface{}{}
+}
+
+func structSchemaFields(t reflect.Type, properties, schemas map[string]interface{}) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" && !f.Anonymous {
+			continue
+		}
+
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			for ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				structSchemaFields(ft, properties, schemas)
+				continue
+			}
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+		properties[name] = jsonSchema(f.Type, schemas)
+	}
+}
@@ -0,0 +1,492 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	config "github.com/mudler/luet/pkg/config"
+	installer "github.com/mudler/luet/pkg/installer"
+	"github.com/pkg/errors"
+)
+
+// RepositoryStatus describes the state of the sync of a repository.
+type RepositoryStatus struct {
+	Name         string    `json:"name"`
+	Synced       bool      `json:"synced"`
+	Syncing      bool      `json:"syncing"`
+	LastSync     time.Time `json:"last_sync"`
+	LastAttempt  time.Time `json:"last_attempt"`
+	LastError    string    `json:"last_error,omitempty"`
+	SyncDuration float64   `json:"sync_duration_seconds"`
+	SyncInterval int       `json:"sync_interval_seconds"`
+	// Failures is the number of consecutive failed syncs.
+	Failures int       `json:"failures"`
+	NextSync time.Time `json:"next_sync"`
+	// Stale is true if the last sync failed or if the data
+	// are older than two sync intervals.
+	Stale bool `json:"stale"`
+}
+
+// Snapshot is an immutable view of the synced repositories.
+// A new snapshot is created on every change and swapped atomically,
+// the handlers never block on the sync of the repositories.
+type Snapshot struct {
+	// Synced repositories sorted by priority.
+	Repositories installer.Repositories
+	// Status of all the configured repositories.
+	Status map[string]*RepositoryStatus
+	// Names of the configured repositories in config order.
+	Names []string
+	// Data contains the informations of the repositories
+	// defined in the config file.
+	Data  map[string]map[string]string
+	Index SearchIndexes
+
+	synced  map[string]*installer.LuetSystemRepository
+	graphs  map[string]*DependencyGraph
+	indexes map[string]*SearchIndex
+}
+
+const (
+	// Delay of the first retry of a failed sync. It's doubled
+	// on every consecutive failure until maxRetryDelay.
+	retryDelay    = 30 * time.Second
+	maxRetryDelay = time.Hour
+)
+
+var snapshot atomic.Value
+
+// swapLock serializes the writers of the snapshot.
+var swapLock = &sync.Mutex{}
+
+// historyLock serializes the records of the history with the prune
+// of the snapshots of the removed repositories.
+var historyLock = &sync.Mutex{}
+
+// runningSyncs contains the repositories with a running sync, the
+// syncs abandoned after the timeout too. It's protected by syncsLock.
+var (
+	runningSyncs = map[string]bool{}
+	syncsLock    = &sync.Mutex{}
+)
+
+func init() {
+	snapshot.Store(&Snapshot{
+		Repositories: installer.Repositories{},
+		Status:       map[string]*RepositoryStatus{},
+		Names:        []string{},
+		Data:         map[string]map[string]string{},
+		Index:        SearchIndexes{},
+		synced:       map[string]*installer.LuetSystemRepository{},
+		graphs:       map[string]*DependencyGraph{},
+		indexes:      map[string]*SearchIndex{},
+	})
+}
+
+func getSnapshot() *Snapshot {
+	return snapshot.Load().(*Snapshot)
+}
+
+func (s *Snapshot) GetRepository(name string) *installer.LuetSystemRepository {
+	return s.synced[name]
+}
+
+// GetGraph returns the dependency graph of the repository.
+func (s *Snapshot) GetGraph(name string) *DependencyGraph {
+	return s.graphs[name]
+}
+
+// GetStatus returns a copy of the status of the repository
+// with the stale flag updated.
+func (s *Snapshot) GetStatus(name string) *RepositoryStatus {
+	st, ok := s.Status[name]
+	if !ok {
+		return nil
+	}
+	ans := *st
+	interval := time.Duration(ans.SyncInterval) * time.Second
+	ans.Stale = ans.LastError != "" ||
+		(ans.Synced && time.Since(ans.LastSync) > 2*interval)
+	return &ans
+}
+
+// GetStatusList returns the status of the repositories in config order.
+func (s *Snapshot) GetStatusList() []*RepositoryStatus {
+	ans := []*RepositoryStatus{}
+	for _, name := range s.Names {
+		ans = append(ans, s.GetStatus(name))
+	}
+	return ans
+}
+
+func (s *Snapshot) clone() *Snapshot {
+	ans := &Snapshot{
+		Repositories: s.Repositories,
+		Status:       make(map[string]*RepositoryStatus, len(s.Status)),
+		Names:        s.Names,
+		Data:         s.Data,
+		Index:        s.Index,
+		synced:       make(map[string]*installer.LuetSystemRepository, len(s.synced)),
+		graphs:       make(map[string]*DependencyGraph, len(s.graphs)),
+		indexes:      make(map[string]*SearchIndex, len(s.indexes)),
+	}
+	for k, v := range s.Status {
+		st := *v
+		ans.Status[k] = &st
+	}
+	for k, v := range s.synced {
+		ans.synced[k] = v
+	}
+	for k, v := range s.graphs {
+		ans.graphs[k] = v
+	}
+	for k, v := range s.indexes {
+		ans.indexes[k] = v
+	}
+	return ans
+}
+
+// updateSnapshot applies the changes to a copy of the current
+// snapshot and swaps it. If reindex is true the repositories
+// list and the search indexes are updated.
+func updateSnapshot(reindex bool, fn func(s *Snapshot)) {
+	swapLock.Lock()
+	defer swapLock.Unlock()
+
+	updateSnapshotLocked(reindex, fn)
+}
+
+func updateSnapshotLocked(reindex bool, fn func(s *Snapshot)) {
+	s := getSnapshot().clone()
+	fn(s)
+
+	if reindex {
+		repos := installer.Repositories{}
+		for _, name := range s.Names {
+			if r, ok := s.synced[name]; ok {
+				repos = append(repos, r)
+			}
+		}
+		sort.Sort(repos)
+		s.Repositories = repos
+
+		// The indexes are built by the watchers, only the
+		// list is updated here.
+		s.Index = SearchIndexes{}
+		for _, r := range repos {
+			if idx, ok := s.indexes[r.GetName()]; ok {
+				s.Index = append(s.Index, idx)
+			}
+		}
+	}
+
+	snapshot.Store(s)
+}
+
+// initLuetSystem sets the luet system directories to a private
+// directory, luet uses them for the temporary files and the cached
+// repositories. It returns the directory to remove on exit.
+func initLuetSystem() (string, error) {
+	dir, err := ioutil.TempDir(os.TempDir(), "luet-package-browser")
+	if err != nil {
+		return "", errors.Wrap(err, "Failed creating luet directory")
+	}
+
+	// The rootfs isn't changed, the paths of the disk
+	// repositories are relative to it.
+	system := config.LuetCfg.GetSystem()
+	system.DatabasePath = filepath.Join(dir, "db")
+	system.TmpDirBase = filepath.Join(dir, "tmp")
+	if err := os.MkdirAll(system.TmpDirBase, os.ModePerm); err != nil {
+		os.RemoveAll(dir)
+		return "", errors.Wrap(err, "Failed creating luet directory")
+	}
+
+	return dir, nil
+}
+
+// syncRepository syncs a copy of the repository in cached mode with
+// the tree and the metadata in a directory of the sync, removed when
+// the sync ends because they are loaded in memory. The syncs of the
+// repositories run in parallel. The luet sync can't be interrupted,
+// after the timeout it's abandoned and its result discarded. A new
+// sync doesn't start until the abandoned sync ends.
+func syncRepository(r *installer.LuetSystemRepository, timeout time.Duration) (*installer.LuetSystemRepository, error) {
+	name := r.GetName()
+
+	syncsLock.Lock()
+	if runningSyncs[name] {
+		syncsLock.Unlock()
+		return nil, errors.New(fmt.Sprintf("Failed syncing repository: %s: the previous sync is still running", name))
+	}
+	runningSyncs[name] = true
+	syncsLock.Unlock()
+
+	done := func() {
+		syncsLock.Lock()
+		delete(runningSyncs, name)
+		syncsLock.Unlock()
+	}
+
+	dir, err := config.LuetCfg.GetSystem().TempDir("sync-" + name)
+	if err != nil {
+		done()
+		return nil, errors.Wrap(err, "Failed creating temporary directory")
+	}
+
+	c := *r.LuetRepository
+	c.Cached = true
+	c.TreePath = filepath.Join(dir, "treefs")
+	c.MetaPath = filepath.Join(dir, "metafs")
+
+	type syncResult struct {
+		repo *installer.LuetSystemRepository
+		err  error
+	}
+	result := make(chan syncResult, 1)
+	go func() {
+		defer done()
+		defer os.RemoveAll(dir)
+		// The sync is forced, the cached tree of the previous
+		// sync is already removed.
+		repo, err := installer.NewSystemRepository(c).Sync(true)
+		result <- syncResult{repo: repo, err: err}
+	}()
+
+	select {
+	case res := <-result:
+		if res.err != nil {
+			return nil, errors.Wrap(res.err, "Failed syncing repository: "+name)
+		}
+		return res.repo, nil
+	case <-time.After(timeout):
+		return nil, errors.New(fmt.Sprintf("Failed syncing repository: %s: timeout after %s",
+			name, timeout))
+	}
+}
+
+// nextSyncDelay returns the delay before the next sync. The failing
+// repositories are retried with an exponential backoff.
+func nextSyncDelay(interval time.Duration, failures int) time.Duration {
+	if failures == 0 {
+		return interval
+	}
+
+	delay := retryDelay
+	if interval < delay {
+		delay = interval
+	}
+	for i := 1; i < failures && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	return delay
+}
+
+// repoWatcher syncs a repository until it's stopped.
+type repoWatcher struct {
+	config Repository
+	stop   chan struct{}
+}
+
+// watchers contains the running watchers by repository.
+// It's protected by swapLock.
+var watchers = map[string]*repoWatcher{}
+
+func (w *repoWatcher) stopped() bool {
+	select {
+	case <-w.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// watchRepository syncs the repository every interval and publishes
+// the result until the watcher is stopped.
+func watchRepository(w *repoWatcher, r *installer.LuetSystemRepository) {
+	name := r.GetName()
+	interval := time.Duration(w.config.SyncInterval) * time.Second
+	timeout := time.Duration(w.config.SyncTimeout) * time.Second
+	failures := 0
+
+	// update applies the changes only if the watcher is
+	// still the watcher of the repository.
+	update := func(reindex bool, fn func(s *Snapshot)) bool {
+		applied := false
+		updateSnapshot(reindex, func(s *Snapshot) {
+			if watchers[name] == w {
+				fn(s)
+				applied = true
+			}
+		})
+		return applied
+	}
+
+	for !w.stopped() {
+		start := time.Now()
+		update(false, func(s *Snapshot) {
+			s.Status[name].Syncing = true
+			s.Status[name].LastAttempt = start
+		})
+
+		repo, err := syncRepository(r, timeout)
+		observeSync(name, time.Since(start), err)
+		var graph *DependencyGraph
+		var index *SearchIndex
+		var hsnap *repositorySnapshot
+		if err == nil {
+			graph = NewDependencyGraph(repo)
+			index = NewSearchIndex(installer.Repositories{repo})
+			hsnap = newRepositorySnapshot(repo)
+		}
+		if err != nil {
+			failures++
+		} else {
+			failures = 0
+		}
+		delay := nextSyncDelay(interval, failures)
+
+		if w.stopped() {
+			return
+		}
+
+		applied := update(err == nil, func(s *Snapshot) {
+			st := s.Status[name]
+			st.Syncing = false
+			st.SyncDuration = time.Since(start).Seconds()
+			st.Failures = failures
+			st.NextSync = time.Now().Add(delay)
+			if err != nil {
+				// POST: the last synced data are kept.
+				st.LastError = err.Error()
+				return
+			}
+			st.Synced = true
+			st.LastSync = time.Now()
+			st.LastError = ""
+			s.synced[name] = repo
+			s.graphs[name] = graph
+			s.indexes[name] = index
+		})
+
+		if applied && err == nil {
+			recordHistory(w, name, hsnap)
+		}
+
+		if err != nil {
+			fmt.Println("failed refreshing repository", err, "- retry in", delay)
+		}
+
+		select {
+		case <-w.stop:
+		case <-time.After(delay):
+		}
+	}
+}
+
+// recordHistory records the synced repository in the history. The
+// snapshot of a repository removed during the sync is not created
+// again, the prune of the removed repositories waits for the record.
+func recordHistory(w *repoWatcher, name string, hsnap *repositorySnapshot) {
+	if history == nil {
+		return
+	}
+
+	historyLock.Lock()
+	defer historyLock.Unlock()
+
+	if w.stopped() || getSnapshot().Status[name] == nil {
+		return
+	}
+	if _, err := history.Record(name, hsnap); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// applyRepositories starts the sync of the new repositories, stops
+// the sync of the removed repositories and restarts the sync of the
+// changed repositories. The synced data of the changed repositories
+// are available until the next sync. The history snapshots of the
+// removed repositories are deleted.
+func applyRepositories(repos []Repository) {
+	swapLock.Lock()
+	defer swapLock.Unlock()
+
+	configured := map[string]Repository{}
+	for _, r := range repos {
+		configured[r.Name] = r
+	}
+
+	for name, w := range watchers {
+		if r, ok := configured[name]; !ok || !r.Equal(w.config) {
+			close(w.stop)
+			delete(watchers, name)
+		}
+	}
+
+	updateSnapshotLocked(true, func(s *Snapshot) {
+		names := []string{}
+		data := map[string]map[string]string{}
+
+		for _, r := range repos {
+			names = append(names, r.Name)
+			data[r.Name] = map[string]string{
+				"github":      r.Github,
+				"description": r.Description,
+				"url":         r.Url,
+				"type":        r.Type,
+			}
+
+			st, ok := s.Status[r.Name]
+			if !ok {
+				st = &RepositoryStatus{Name: r.Name}
+				s.Status[r.Name] = st
+			}
+			st.SyncInterval = r.SyncInterval
+
+			if _, ok := watchers[r.Name]; ok {
+				continue
+			}
+
+			repo, err := GetRepo(r)
+			if err != nil {
+				st.LastError = err.Error()
+				fmt.Println("Failed getting repo", r.Name, err)
+				continue
+			}
+			w := &repoWatcher{config: r, stop: make(chan struct{})}
+			watchers[r.Name] = w
+			go watchRepository(w, repo)
+		}
+
+		for name := range s.Status {
+			if _, ok := configured[name]; !ok {
+				delete(s.Status, name)
+				delete(s.synced, name)
+				delete(s.graphs, name)
+				delete(s.indexes, name)
+			}
+		}
+
+		s.Names = names
+		s.Data = data
+	})
+
+	if history != nil {
+		historyLock.Lock()
+		defer historyLock.Unlock()
+
+		if err := history.PruneSnapshots(getSnapshot().Names); err != nil {
+			fmt.Println(err)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	compiler "github.com/mudler/luet/pkg/compiler"
+	artifact "github.com/mudler/luet/pkg/compiler/types/artifact"
+	compilerspec "github.com/mudler/luet/pkg/compiler/types/spec"
+	config "github.com/mudler/luet/pkg/config"
+	installer "github.com/mudler/luet/pkg/installer"
+	pkg "github.com/mudler/luet/pkg/package"
+	tree "github.com/mudler/luet/pkg/tree"
+)
+
+// testPackage is a package of a test repository with the files
+// of its artefact.
+type testPackage struct {
+	*pkg.DefaultPackage
+	files []string
+}
+
+// newTestRepository returns a synced repository with the packages
+// loaded in memory.
+func newTestRepository(t *testing.T, name string, revision int, packages ...testPackage) *installer.LuetSystemRepository {
+	db := pkg.NewInMemoryDatabase(false)
+	index := compiler.ArtifactIndex{}
+	for _, p := range packages {
+		if _, err := db.CreatePackage(p.DefaultPackage); err != nil {
+			t.Fatal(err)
+		}
+		index = append(index, &artifact.PackageArtifact{
+			CompileSpec: &compilerspec.LuetCompilationSpec{Package: p.DefaultPackage},
+			Files:       p.files,
+		})
+	}
+
+	r := installer.NewSystemRepository(config.LuetRepository{
+		Name:     name,
+		Revision: revision,
+	})
+	r.SetTree(tree.NewInstallerRecipe(db))
+	r.SetIndex(index)
+	return r
+}
+
+func newTestPackage(category, name, version string, files ...string) testPackage {
+	return testPackage{
+		DefaultPackage: &pkg.DefaultPackage{
+			Category: category,
+			Name:     name,
+			Version:  version,
+		},
+		files: files,
+	}
+}
+
+// setTestSnapshot publishes a snapshot with the repositories synced
+// now. The previous snapshot is restored at the end of the test.
+func setTestSnapshot(t *testing.T, repos ...*installer.LuetSystemRepository) {
+	old := getSnapshot()
+	t.Cleanup(func() { snapshot.Store(old) })
+
+	updateSnapshot(true, func(s *Snapshot) {
+		s.Names = []string{}
+		s.Data = map[string]map[string]string{}
+		s.Status = map[string]*RepositoryStatus{}
+		s.synced = map[string]*installer.LuetSystemRepository{}
+		s.graphs = map[string]*DependencyGraph{}
+		s.indexes = map[string]*SearchIndex{}
+
+		for _, r := range repos {
+			name := r.GetName()
+			s.Names = append(s.Names, name)
+			s.Data[name] = map[string]string{"type": "http"}
+			s.Status[name] = &RepositoryStatus{
+				Name:         name,
+				Synced:       true,
+				LastSync:     time.Now(),
+				SyncInterval: 3600,
+			}
+			s.synced[name] = r
+			s.graphs[name] = NewDependencyGraph(r)
+			s.indexes[name] = NewSearchIndex(installer.Repositories{r})
+		}
+	})
+}
+
+func TestSyncRepositoryRunning(t *testing.T) {
+	syncsLock.Lock()
+	runningSyncs["stable"] = true
+	syncsLock.Unlock()
+	t.Cleanup(func() {
+		syncsLock.Lock()
+		delete(runningSyncs, "stable")
+		syncsLock.Unlock()
+	})
+
+	_, err := syncRepository(newTestRepository(t, "stable", 1), time.Second)
+	if err == nil || !strings.Contains(err.Error(), "the previous sync is still running") {
+		t.Errorf("syncRepository() error = %v, want the previous sync running", err)
+	}
+}
+
+func TestRecordHistory(t *testing.T) {
+	setTestSnapshot(t, newTestRepository(t, "stable", 1))
+	history = newTestHistory(t)
+	t.Cleanup(func() { history = nil })
+
+	changes := func() int {
+		ans, err := history.Changes(ChangeFilter{}, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(ans)
+	}
+
+	w := &repoWatcher{stop: make(chan struct{})}
+	recordHistory(w, "stable", newTestSnapshot(1, map[string][]string{"app/foo": {"1.0"}}))
+	recordHistory(w, "stable", newTestSnapshot(2, map[string][]string{"app/foo": {"2.0"}}))
+	if got := changes(); got != 1 {
+		t.Fatalf("changes = %d, want 1", got)
+	}
+
+	// The removed repositories and the stopped watchers
+	// are not recorded.
+	removed := &repoWatcher{stop: make(chan struct{})}
+	recordHistory(removed, "removed", newTestSnapshot(1, map[string][]string{"app/foo": {"1.0"}}))
+	recordHistory(removed, "removed", newTestSnapshot(2, map[string][]string{"app/foo": {"2.0"}}))
+	close(w.stop)
+	recordHistory(w, "stable", newTestSnapshot(3, map[string][]string{"app/foo": {"3.0"}}))
+	if got := changes(); got != 1 {
+		t.Errorf("changes = %d, want 1", got)
+	}
+}
+
+func TestNextSyncDelay(t *testing.T) {
+	for _, tc := range []struct {
+		interval time.Duration
+		failures int
+		want     time.Duration
+	}{
+		{time.Hour, 0, time.Hour},
+		{time.Hour, 1, retryDelay},
+		{time.Hour, 2, 2 * retryDelay},
+		{time.Hour, 3, 4 * retryDelay},
+		{time.Hour, 7, 64 * retryDelay},
+		// The backoff is capped.
+		{time.Hour, 8, maxRetryDelay},
+		{time.Hour, 100, maxRetryDelay},
+		// The first retry doesn't wait more than the interval.
+		{10 * time.Second, 1, 10 * time.Second},
+		{10 * time.Second, 2, 20 * time.Second},
+		{10 * time.Second, 0, 10 * time.Second},
+	} {
+		if got := nextSyncDelay(tc.interval, tc.failures); got != tc.want {
+			t.Errorf("nextSyncDelay(%s, %d) = %s, want %s", tc.interval, tc.failures, got, tc.want)
+		}
+	}
+}
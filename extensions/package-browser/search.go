@@ -0,0 +1,274 @@
+package main
+
+import (
+	"path"
+	"sort"
+	"strings"
+	"unicode"
+
+	installer "github.com/mudler/luet/pkg/installer"
+)
+
+const (
+	scoreName        = 10
+	scoreNamePrefix  = 5
+	scoreCategory    = 3
+	scoreLabel       = 2
+	scoreDescription = 1
+)
+
+// SearchResult is a package matched by a search.
+type SearchResult struct {
+	ApiPackageSummary
+	Score int `json:"score"`
+}
+
+// FileOwner is a package that ships a file.
+type FileOwner struct {
+	ApiPackageRef
+	Repository string `json:"repository"`
+	File       string `json:"file"`
+}
+
+type searchDoc struct {
+	summary ApiPackageSummary
+	files   []string
+}
+
+// SearchIndex is an inverted index of the packages of the
+// repositories. It's built on every sync and it's read only.
+type SearchIndex struct {
+	docs []searchDoc
+	// term -> doc -> score
+	terms map[string]map[int]int
+	// file path and file basename -> docs
+	files map[string][]int
+}
+
+func tokenize(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+func normalizeFile(f string) string {
+	return strings.TrimPrefix(path.Clean("/"+f), "/")
+}
+
+func NewSearchIndex(repos installer.Repositories) *SearchIndex {
+	idx := &SearchIndex{
+		docs:  []searchDoc{},
+		terms: map[string]map[int]int{},
+		files: map[string][]int{},
+	}
+
+	for _, r := range repos {
+		// The files are available only in the artefacts index.
+		files := map[string][]string{}
+		for _, a := range r.GetIndex() {
+			if a.CompileSpec == nil || a.CompileSpec.GetPackage() == nil {
+				continue
+			}
+			files[a.CompileSpec.GetPackage().GetFingerPrint()] = a.Files
+		}
+
+		for _, p := range r.GetTree().GetDatabase().World() {
+			doc := searchDoc{
+				summary: newApiPackageSummary(r.GetName(), p),
+				files:   files[p.GetFingerPrint()],
+			}
+			id := len(idx.docs)
+			idx.docs = append(idx.docs, doc)
+
+			idx.addTerm(strings.ToLower(p.GetName()), id, scoreName)
+			for _, t := range tokenize(p.GetName()) {
+				idx.addTerm(t, id, scoreNamePrefix)
+			}
+			for _, t := range tokenize(p.GetCategory()) {
+				idx.addTerm(t, id, scoreCategory)
+			}
+			for k, v := range p.GetLabels() {
+				for _, t := range append(tokenize(k), tokenize(v)...) {
+					idx.addTerm(t, id, scoreLabel)
+				}
+			}
+			for _, t := range tokenize(p.GetDescription()) {
+				idx.addTerm(t, id, scoreDescription)
+			}
+
+			seen := map[string]bool{}
+			for _, f := range doc.files {
+				f = normalizeFile(f)
+				for _, k := range []string{f, path.Base(f)} {
+					if !seen[k] {
+						seen[k] = true
+						idx.files[k] = append(idx.files[k], id)
+					}
+				}
+			}
+		}
+	}
+
+	return idx
+}
+
+// Size returns the number of documents, terms and files of the index.
+func (idx *SearchIndex) Size() (int, int, int) {
+	return len(idx.docs), len(idx.terms), len(idx.files)
+}
+
+func (idx *SearchIndex) addTerm(term string, doc, score int) {
+	docs, ok := idx.terms[term]
+	if !ok {
+		docs = map[int]int{}
+		idx.terms[term] = docs
+	}
+	if docs[doc] < score {
+		docs[doc] = score
+	}
+}
+
+// Search returns the packages matching all the words of the query.
+// A word matches the terms starting with it. The results are sorted
+// by score.
+func (idx *SearchIndex) Search(q string) []SearchResult {
+	ans := []SearchResult{}
+	words := tokenize(q)
+	if len(words) == 0 {
+		return ans
+	}
+
+	var scores map[int]int
+	for _, w := range words {
+		wordScores := map[int]int{}
+		for term, docs := range idx.terms {
+			if !strings.HasPrefix(term, w) {
+				continue
+			}
+			for doc, score := range docs {
+				// Exact matches are worth more than prefix matches.
+				if term == w {
+					score *= 2
+				}
+				if wordScores[doc] < score {
+					wordScores[doc] = score
+				}
+			}
+		}
+
+		if scores == nil {
+			scores = wordScores
+			continue
+		}
+		for doc := range scores {
+			if s, ok := wordScores[doc]; ok {
+				scores[doc] += s
+			} else {
+				delete(scores, doc)
+			}
+		}
+	}
+
+	// A query equal to the package name is the best match.
+	name := strings.ToLower(strings.TrimSpace(q))
+	for doc, score := range scores {
+		s := idx.docs[doc].summary
+		if name == strings.ToLower(s.Name) ||
+			name == strings.ToLower(s.Category+"/"+s.Name) {
+			score += scoreName * 10
+		}
+		ans = append(ans, SearchResult{ApiPackageSummary: s, Score: score})
+	}
+
+	sortSearchResults(ans)
+	return ans
+}
+
+func sortSearchResults(ans []SearchResult) {
+	sort.SliceStable(ans, func(i, j int) bool {
+		if ans[i].Score != ans[j].Score {
+			return ans[i].Score > ans[j].Score
+		}
+		if ans[i].Category+"/"+ans[i].Name != ans[j].Category+"/"+ans[j].Name {
+			return ans[i].Category+"/"+ans[i].Name < ans[j].Category+"/"+ans[j].Name
+		}
+		return ans[i].Repository < ans[j].Repository
+	})
+}
+
+// Owners returns the packages that ship the file. If the file
+// doesn't contain a slash the packages with a file with the
+// same basename are returned.
+func (idx *SearchIndex) Owners(file string) []FileOwner {
+	ans := []FileOwner{}
+	if strings.TrimSpace(file) == "" {
+		return ans
+	}
+
+	f := normalizeFile(file)
+	byName := !strings.Contains(strings.Trim(file, "/"), "/")
+
+	for _, doc := range idx.files[f] {
+		d := idx.docs[doc]
+		for _, df := range d.files {
+			df = normalizeFile(df)
+			if df == f || (byName && path.Base(df) == f) {
+				ans = append(ans, FileOwner{
+					ApiPackageRef: d.summary.ApiPackageRef,
+					Repository:    d.summary.Repository,
+					File:          "/" + df,
+				})
+			}
+		}
+	}
+
+	sortFileOwners(ans)
+	return ans
+}
+
+func sortFileOwners(ans []FileOwner) {
+	sort.SliceStable(ans, func(i, j int) bool {
+		if ans[i].File != ans[j].File {
+			return ans[i].File < ans[j].File
+		}
+		return ans[i].Repository < ans[j].Repository
+	})
+}
+
+// SearchIndexes are the indexes of the synced repositories. Every
+// repository is indexed on its own after the sync, the score of a
+// package doesn't depend on the other packages so the results of
+// the indexes are merged.
+type SearchIndexes []*SearchIndex
+
+// Size returns the number of documents, terms and files of the indexes.
+func (l SearchIndexes) Size() (int, int, int) {
+	docs, terms, files := 0, 0, 0
+	for _, idx := range l {
+		d, t, f := idx.Size()
+		docs += d
+		terms += t
+		files += f
+	}
+	return docs, terms, files
+}
+
+// Search returns the packages of all the indexes matching the query.
+func (l SearchIndexes) Search(q string) []SearchResult {
+	ans := []SearchResult{}
+	for _, idx := range l {
+		ans = append(ans, idx.Search(q)...)
+	}
+	sortSearchResults(ans)
+	return ans
+}
+
+// Owners returns the packages of all the indexes that ship the file.
+func (l SearchIndexes) Owners(file string) []FileOwner {
+	ans := []FileOwner{}
+	for _, idx := range l {
+		ans = append(ans, idx.Owners(file)...)
+	}
+	sortFileOwners(ans)
+	return ans
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	installer "github.com/mudler/luet/pkg/installer"
+)
+
+func newTestRepositories(t *testing.T) installer.Repositories {
+	foo := newTestPackage("app", "foo", "1.0",
+		"usr/bin/foo", "usr/share/doc/foo/README")
+	foo.Description = "A foo tool"
+	libs := newTestPackage("lib", "foo-libs", "1.0",
+		"usr/lib/libfoo.so", "etc/foo/README")
+	libs.Description = "Libraries of foo"
+	bar := newTestPackage("dev", "bar", "1.0", "usr/bin/bar")
+	bar.Description = "Uses foo"
+	bar.Labels = map[string]string{"homepage": "example"}
+
+	return installer.Repositories{
+		newTestRepository(t, "stable", 1,
+			foo, libs, bar, newTestPackage("app", "foobar", "2.0", "usr/bin/foobar")),
+		newTestRepository(t, "testing", 1,
+			newTestPackage("app", "foo", "1.1", "usr/bin/foo")),
+	}
+}
+
+func newTestSearchIndex(t *testing.T) *SearchIndex {
+	return NewSearchIndex(newTestRepositories(t))
+}
+
+func TestSearch(t *testing.T) {
+	idx := newTestSearchIndex(t)
+
+	for _, tc := range []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{}},
+		{"missing", []string{}},
+		// The exact name gets the bonus, the prefix matches of
+		// the name and the description follow.
+		{"foo", []string{
+			"stable:app/foo:120",
+			"testing:app/foo:120",
+			"stable:app/foobar:10",
+			"stable:lib/foo-libs:10",
+			"stable:dev/bar:2",
+		}},
+		{"FOO", []string{
+			"stable:app/foo:120",
+			"testing:app/foo:120",
+			"stable:app/foobar:10",
+			"stable:lib/foo-libs:10",
+			"stable:dev/bar:2",
+		}},
+		{"foob", []string{"stable:app/foobar:10"}},
+		{"app/foo", []string{
+			"stable:app/foo:126",
+			"testing:app/foo:126",
+			"stable:app/foobar:16",
+		}},
+		// All the words must match.
+		{"foo lib", []string{"stable:lib/foo-libs:16"}},
+		{"foo missing", []string{}},
+		{"example", []string{"stable:dev/bar:4"}},
+		{"tool", []string{"stable:app/foo:2"}},
+	} {
+		got := []string{}
+		for _, r := range idx.Search(tc.query) {
+			got = append(got, fmt.Sprintf("%s:%s/%s:%d",
+				r.Repository, r.Category, r.Name, r.Score))
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("Search(%q) = %v, want %v", tc.query, got, tc.want)
+		}
+	}
+}
+
+func TestOwners(t *testing.T) {
+	idx := newTestSearchIndex(t)
+
+	for _, tc := range []struct {
+		file string
+		want []string
+	}{
+		{"", []string{}},
+		{"missing", []string{}},
+		// A name without slash matches the basename.
+		{"foo", []string{
+			"stable:app/foo-1.0:/usr/bin/foo",
+			"testing:app/foo-1.1:/usr/bin/foo",
+		}},
+		{"README", []string{
+			"stable:lib/foo-libs-1.0:/etc/foo/README",
+			"stable:app/foo-1.0:/usr/share/doc/foo/README",
+		}},
+		// A path matches only the full path.
+		{"/usr/bin/foo", []string{
+			"stable:app/foo-1.0:/usr/bin/foo",
+			"testing:app/foo-1.1:/usr/bin/foo",
+		}},
+		{"usr/bin/foobar", []string{"stable:app/foobar-2.0:/usr/bin/foobar"}},
+		{"/usr/bin/../bin/bar", []string{"stable:dev/bar-1.0:/usr/bin/bar"}},
+		{"bin/foo", []string{}},
+		{"/etc/foo", []string{}},
+	} {
+		got := []string{}
+		for _, o := range idx.Owners(tc.file) {
+			got = append(got, fmt.Sprintf("%s:%s/%s-%s:%s",
+				o.Repository, o.Category, o.Name, o.Version, o.File))
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("Owners(%q) = %v, want %v", tc.file, got, tc.want)
+		}
+	}
+}
+
+func TestSearchIndexes(t *testing.T) {
+	repos := newTestRepositories(t)
+	idx := NewSearchIndex(repos)
+	indexes := SearchIndexes{}
+	for _, r := range repos {
+		indexes = append(indexes, NewSearchIndex(installer.Repositories{r}))
+	}
+
+	for _, q := range []string{"", "foo", "app/foo", "foo lib", "example"} {
+		if got, want := indexes.Search(q), idx.Search(q); !reflect.DeepEqual(got, want) {
+			t.Errorf("SearchIndexes.Search(%q) = %v, want %v", q, got, want)
+		}
+	}
+	for _, f := range []string{"", "foo", "README", "/usr/bin/foo"} {
+		if got, want := indexes.Owners(f), idx.Owners(f); !reflect.DeepEqual(got, want) {
+			t.Errorf("SearchIndexes.Owners(%q) = %v, want %v", f, got, want)
+		}
+	}
+
+	docs, _, _ := indexes.Size()
+	if want, _, _ := idx.Size(); docs != want {
+		t.Errorf("SearchIndexes.Size() documents = %d, want %d", docs, want)
+	}
+}
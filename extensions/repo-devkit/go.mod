@@ -12,17 +12,30 @@ replace github.com/jaguilar/vt100 => github.com/tonistiigi/vt100 v0.0.0-20190402
 
 replace github.com/opencontainers/runc => github.com/opencontainers/runc v1.0.0-rc9.0.20200221051241-688cf6d43cc4
 
+// luet requires the grab API with Response.Size(), an old luet
+// version of the dependencies selects v2.0.0+incompatible.
+replace github.com/cavaliercoder/grab => github.com/cavaliercoder/grab v1.0.1-0.20201108051000-98a5bfe305ec
+
 require (
 	github.com/Luet-lab/luet-portage-converter v0.4.2-0.20210811064616-ed4133e4bdd6
 	github.com/MottainaiCI/mottainai-server v0.0.2-0.20210531211337-27f12a56ea5f
 	github.com/geaaru/time-master v0.3.1
+	github.com/go-task/slim-sprig v0.0.0-20210107165309-348f09dbbbc0 // indirect
+	github.com/golang/protobuf v1.5.2 // indirect
 	github.com/jaypipes/ghw v0.6.1 // indirect
+	github.com/johannesboyne/gofakes3 v0.0.0-20210608054100-92d5d4af5fde
 	github.com/minio/minio-go/v7 v7.0.10
 	github.com/mitchellh/hashstructure/v2 v2.0.1 // indirect
 	github.com/mudler/luet v0.0.0-20210604142351-a7b4ae67c9b8
+	github.com/nxadm/tail v1.4.8 // indirect
+	github.com/onsi/ginkgo v1.14.2
+	github.com/onsi/gomega v1.10.3
 	github.com/rickb777/date v1.13.0 // indirect
 	github.com/spf13/cobra v1.1.3
+	github.com/spf13/pflag v1.0.5
 	github.com/stevenle/topsort v0.0.0-20130922064739-8130c1d7596b // indirect
+	gocloud.dev v0.22.0
+	golang.org/x/net v0.0.0-20210428140749-89ef3d95e781 // indirect
 	gopkg.in/src-d/go-git.v4 v4.13.1 // indirect
 	gopkg.in/yaml.v2 v2.4.0
 	mvdan.cc/sh/v3 v3.0.0-beta1 // indirect
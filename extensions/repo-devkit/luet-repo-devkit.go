@@ -86,6 +86,11 @@ func Execute() {
 	rootCmd.AddCommand(
 		devkitcmd.NewCleanCommand(),
 		devkitcmd.NewPkgsCommand(),
+		devkitcmd.NewVerifyCommand(),
+		devkitcmd.NewDiffCommand(),
+		devkitcmd.NewPromoteCommand(),
+		devkitcmd.NewReindexCommand(),
+		devkitcmd.NewStatsCommand(),
 	)
 
 	if err := rootCmd.Execute(); err != nil {
@@ -0,0 +1,310 @@
+/*
+Copyright (C) 2020-2021  Daniele Rondina <geaaru@sabayonlinux.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+*/
+package backends
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/Luet-lab/extensions/extensions/repo-devkit/pkg/specs"
+
+	artifact "github.com/mudler/luet/pkg/compiler/types/artifact"
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+
+	// Register the URL openers of the supported blob stores.
+	_ "gocloud.dev/blob/azureblob"
+	_ "gocloud.dev/blob/fileblob"
+	_ "gocloud.dev/blob/gcsblob"
+	_ "gocloud.dev/blob/memblob"
+	_ "gocloud.dev/blob/s3blob"
+)
+
+// BackendBlob manages a repository stored in a generic object storage
+// (S3 compatible stores, Google Cloud Storage, Azure Blob or a local
+// directory). The bucket is defined by an URL like:
+//
+//	s3://bucket?region=us-east-1&endpoint=minio.example.com:9000
+//	gs://bucket
+//	azblob://container
+//	file:///srv/bucket
+//	mem://
+//
+// The credentials are read by every driver from the standard
+// environment variables of the provider.
+type BackendBlob struct {
+	Specs        *specs.LuetRDConfig
+	ArtefactPath string
+
+	Url    string
+	Bucket *blob.Bucket
+	// Prefix of the objects of the repository inside the bucket.
+	// The files names are relative to the prefix.
+	Prefix string
+
+	// Objects informations retrieved by the last listing.
+	objects map[string]*specs.RepoFileInfo
+	mutex   sync.Mutex
+}
+
+// NewBackendBlob creates the blob backend. The path is used as
+// prefix of the objects if the option blob-prefix is not defined.
+func NewBackendBlob(specs *specs.LuetRDConfig, path string, opts map[string]string) (*BackendBlob, error) {
+	if opts["blob-url"] == "" {
+		return nil, errors.New("Blob bucket URL is mandatory")
+	}
+
+	ans := &BackendBlob{
+		Specs:        specs,
+		ArtefactPath: path,
+		Url:          opts["blob-url"],
+		Prefix:       GetBlobPrefix(path, opts),
+	}
+
+	bucket, err := blob.OpenBucket(context.Background(), ans.Url)
+	if err != nil {
+		return nil, errors.New(
+			fmt.Sprintf("Error on open bucket %s: %s", ans.Url, err.Error()))
+	}
+
+	accessible, err := bucket.IsAccessible(context.Background())
+	if err != nil {
+		bucket.Close()
+		return nil, errors.New(
+			fmt.Sprintf("Error on check if the bucket %s is accessible: %s",
+				ans.Url, err.Error()))
+	}
+
+	if !accessible {
+		bucket.Close()
+		return nil, errors.New(fmt.Sprintf("Bucket %s not found", ans.Url))
+	}
+
+	if ans.Prefix != "" {
+		ans.Bucket = blob.PrefixedBucket(bucket, ans.Prefix)
+	} else {
+		ans.Bucket = bucket
+	}
+
+	return ans, nil
+}
+
+// GetBlobPrefix returns the prefix of the objects from the
+// option blob-prefix or from the path. The prefix is returned
+// without the leading slash and with the trailing slash.
+func GetBlobPrefix(path string, opts map[string]string) string {
+	prefix := path
+	if p, ok := opts["blob-prefix"]; ok && p != "" {
+		prefix = p
+	}
+
+	return normalizePrefix(prefix)
+}
+
+func normalizePrefix(prefix string) string {
+	prefix = strings.Trim(prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	return prefix
+}
+
+// Close releases the resources of the bucket.
+func (b *BackendBlob) Close() error {
+	return b.Bucket.Close()
+}
+
+func (b *BackendBlob) listObjects() ([]*specs.RepoFileInfo, error) {
+	ans := []*specs.RepoFileInfo{}
+	objects := make(map[string]*specs.RepoFileInfo, 0)
+
+	// The iterator follows the pages of the listing.
+	iter := b.Bucket.List(nil)
+	for {
+		object, err := iter.Next(context.Background())
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.New("Error on retrieve list of objects: " + err.Error())
+		}
+
+		if object.IsDir || strings.HasSuffix(object.Key, "/") {
+			continue
+		}
+
+		info := &specs.RepoFileInfo{
+			Name:    object.Key,
+			Size:    object.Size,
+			ModTime: object.ModTime,
+		}
+		if len(object.MD5) > 0 {
+			info.ETag = hex.EncodeToString(object.MD5)
+		}
+
+		if _, ok := objects[info.Name]; ok {
+			return nil, errors.New(
+				"Error on retrieve list of objects: duplicate object " + object.Key)
+		}
+
+		ans = append(ans, info)
+		objects[info.Name] = info
+	}
+
+	b.mutex.Lock()
+	b.objects = objects
+	b.mutex.Unlock()
+
+	return ans, nil
+}
+
+func (b *BackendBlob) GetFilesList() ([]string, error) {
+	ans := []string{}
+
+	objects, err := b.listObjects()
+	if err != nil {
+		return ans, err
+	}
+
+	for _, o := range objects {
+		ans = append(ans, o.Name)
+	}
+
+	return ans, nil
+}
+
+func (b *BackendBlob) GetFilesInfo() ([]*specs.RepoFileInfo, error) {
+	return b.listObjects()
+}
+
+func (b *BackendBlob) GetMetadata(file string) (*artifact.PackageArtifact, error) {
+	content, err := b.Bucket.ReadAll(context.Background(), file)
+	if err != nil {
+		return nil, blobError("read", file, err)
+	}
+
+	return artifact.NewPackageArtifactFromYaml(content)
+}
+
+func (b *BackendBlob) CleanFile(file string) error {
+	err := b.Bucket.Delete(context.Background(), file)
+	if err != nil {
+		return blobError("remove", file, err)
+	}
+
+	b.mutex.Lock()
+	delete(b.objects, file)
+	b.mutex.Unlock()
+
+	return nil
+}
+
+func (b *BackendBlob) MoveFile(src, dst string) error {
+	err := b.Bucket.Copy(context.Background(), dst, src, nil)
+	if err != nil {
+		return errors.New(
+			fmt.Sprintf("Error on copy object %s to %s: %s", src, dst, err.Error()))
+	}
+
+	b.mutex.Lock()
+	delete(b.objects, dst)
+	b.mutex.Unlock()
+
+	return b.CleanFile(src)
+}
+
+func (b *BackendBlob) OpenFile(file string) (io.ReadCloser, error) {
+	r, err := b.Bucket.NewReader(context.Background(), file, nil)
+	if err != nil {
+		return nil, blobError("open", file, err)
+	}
+	return r, nil
+}
+
+func (b *BackendBlob) GetFileInfo(file string) (*specs.RepoFileInfo, error) {
+	b.mutex.Lock()
+	info, ok := b.objects[file]
+	b.mutex.Unlock()
+	if ok {
+		return info, nil
+	}
+
+	attrs, err := b.Bucket.Attributes(context.Background(), file)
+	if err != nil {
+		return nil, blobError("stat", file, err)
+	}
+
+	info = &specs.RepoFileInfo{
+		Name:        file,
+		Size:        attrs.Size,
+		ModTime:     attrs.ModTime,
+		ETag:        strings.Trim(attrs.ETag, "\""),
+		ContentType: attrs.ContentType,
+	}
+
+	return info, nil
+}
+
+func (b *BackendBlob) UploadFile(src, dst string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	w, err := b.Bucket.NewWriter(ctx, dst, nil)
+	if err != nil {
+		return blobError("upload", dst, err)
+	}
+
+	if _, err = io.Copy(w, f); err != nil {
+		// Cancel the context to abort the upload without
+		// creating the object.
+		cancel()
+		w.Close()
+		return blobError("upload", dst, err)
+	}
+
+	// The object is created only on close.
+	if err = w.Close(); err != nil {
+		return blobError("upload", dst, err)
+	}
+
+	// Drop the informations of the previous object.
+	b.mutex.Lock()
+	delete(b.objects, dst)
+	b.mutex.Unlock()
+
+	return nil
+}
+
+func blobError(op, file string, err error) error {
+	if gcerrors.Code(err) == gcerrors.NotFound {
+		return errors.New(fmt.Sprintf("Error on %s object %s: not found", op, file))
+	}
+	return errors.New(fmt.Sprintf("Error on %s object %s: %s", op, file, err.Error()))
+}
@@ -0,0 +1,228 @@
+/*
+Copyright (C) 2020-2021  Daniele Rondina <geaaru@sabayonlinux.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+*/
+
+package backends_test
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	. "github.com/Luet-lab/extensions/extensions/repo-devkit/pkg/backends"
+	specs "github.com/Luet-lab/extensions/extensions/repo-devkit/pkg/specs"
+
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Blob Backend", func() {
+
+	Context("Filesystem bucket", func() {
+		var bucketDir string
+		var opts map[string]string
+
+		writeObject := func(key, content string) {
+			f := filepath.Join(bucketDir, filepath.FromSlash(key))
+			Expect(os.MkdirAll(filepath.Dir(f), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(f, []byte(content), 0644)).To(Succeed())
+		}
+
+		objectExists := func(key string) bool {
+			_, err := os.Stat(filepath.Join(bucketDir, filepath.FromSlash(key)))
+			return err == nil
+		}
+
+		newBackend := func(path string) *BackendBlob {
+			b, err := NewBackendBlob(specs.NewLuetRDConfig(), path, opts)
+			Expect(err).ToNot(HaveOccurred())
+			return b
+		}
+
+		BeforeEach(func() {
+			var err error
+			bucketDir, err = ioutil.TempDir("", "repo-devkit-blob")
+			Expect(err).ToNot(HaveOccurred())
+
+			opts = map[string]string{
+				"blob-url": "file://" + bucketDir,
+			}
+
+			writeObject("stable/foo-app-1.0.metadata.yaml", testMetadata)
+			writeObject("stable/foo-app-1.0.package.tar", "tarball")
+			writeObject("stable/repository.yaml", "name: stable")
+			writeObject("testing/bar-app-1.0.package.tar", "other repo")
+			writeObject("root-file", "root")
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(bucketDir)
+		})
+
+		It("Requires the bucket URL", func() {
+			_, err := NewBackendBlob(specs.NewLuetRDConfig(), "", map[string]string{})
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("Fails with an unsupported URL scheme", func() {
+			_, err := NewBackendBlob(specs.NewLuetRDConfig(), "", map[string]string{
+				"blob-url": "foo://bucket",
+			})
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("List files relative to the prefix", func() {
+			opts["blob-prefix"] = "/stable/"
+			b := newBackend("ignored")
+			defer b.Close()
+
+			files, err := b.GetFilesList()
+			Expect(err).ToNot(HaveOccurred())
+			sort.Strings(files)
+			Expect(files).To(Equal([]string{
+				"foo-app-1.0.metadata.yaml",
+				"foo-app-1.0.package.tar",
+				"repository.yaml",
+			}))
+		})
+
+		It("Use the path as prefix", func() {
+			b := newBackend("testing")
+			defer b.Close()
+
+			files, err := b.GetFilesList()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(files).To(Equal([]string{"bar-app-1.0.package.tar"}))
+		})
+
+		It("Returns the files informations", func() {
+			b := newBackend("stable")
+			defer b.Close()
+
+			infos, err := b.GetFilesInfo()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(len(infos)).To(Equal(3))
+
+			info, err := b.GetFileInfo("foo-app-1.0.package.tar")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(info.Size).To(Equal(int64(len("tarball"))))
+			Expect(info.ModTime.IsZero()).To(BeFalse())
+
+			_, err = b.GetFileInfo("missing.yaml")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("not found"))
+		})
+
+		It("Read metadata and files", func() {
+			b := newBackend("stable")
+			defer b.Close()
+
+			art, err := b.GetMetadata("foo-app-1.0.metadata.yaml")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(art.CompileSpec.Package.GetPackageName()).To(Equal("foo-app"))
+
+			r, err := b.OpenFile("foo-app-1.0.package.tar")
+			Expect(err).ToNot(HaveOccurred())
+			defer r.Close()
+
+			data, err := ioutil.ReadAll(r)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(data)).To(Equal("tarball"))
+		})
+
+		It("Move, upload and clean files inside the prefix", func() {
+			b := newBackend("stable")
+			defer b.Close()
+
+			Expect(b.MoveFile("foo-app-1.0.package.tar",
+				".trash/1/foo-app-1.0.package.tar")).To(Succeed())
+			Expect(objectExists("stable/foo-app-1.0.package.tar")).To(BeFalse())
+			Expect(objectExists("stable/.trash/1/foo-app-1.0.package.tar")).To(BeTrue())
+
+			src := filepath.Join(bucketDir, "upload")
+			Expect(ioutil.WriteFile(src, []byte("new"), 0644)).To(Succeed())
+			Expect(b.UploadFile(src, "new-app-1.0.package.tar")).To(Succeed())
+			Expect(objectExists("stable/new-app-1.0.package.tar")).To(BeTrue())
+
+			Expect(b.CleanFile("repository.yaml")).To(Succeed())
+			Expect(objectExists("stable/repository.yaml")).To(BeFalse())
+			Expect(objectExists("root-file")).To(BeTrue())
+		})
+	})
+
+	Context("Memory bucket", func() {
+
+		It("Upload and list files", func() {
+			b, err := NewBackendBlob(specs.NewLuetRDConfig(), "repo", map[string]string{
+				"blob-url": "mem://",
+			})
+			Expect(err).ToNot(HaveOccurred())
+			defer b.Close()
+
+			tmpdir, err := ioutil.TempDir("", "repo-devkit")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(tmpdir)
+
+			src := filepath.Join(tmpdir, "upload")
+			Expect(ioutil.WriteFile(src, []byte(testMetadata), 0644)).To(Succeed())
+			Expect(b.UploadFile(src, "foo-app-1.0.metadata.yaml")).To(Succeed())
+
+			files, err := b.GetFilesList()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(files).To(Equal([]string{"foo-app-1.0.metadata.yaml"}))
+
+			art, err := b.GetMetadata("foo-app-1.0.metadata.yaml")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(art.CompileSpec.Package.GetPackageName()).To(Equal("foo-app"))
+		})
+	})
+
+	Context("S3 bucket", func() {
+
+		It("List files of an S3 compatible store", func() {
+			s3 := s3mem.New()
+			server := httptest.NewServer(gofakes3.New(s3).Server())
+			defer server.Close()
+
+			Expect(s3.CreateBucket(testBucket)).To(Succeed())
+			_, err := s3.PutObject(testBucket, "stable/repository.yaml",
+				map[string]string{}, strings.NewReader("name: stable"), 12)
+			Expect(err).ToNot(HaveOccurred())
+
+			os.Setenv("AWS_ACCESS_KEY_ID", "id")
+			os.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+			defer os.Unsetenv("AWS_ACCESS_KEY_ID")
+			defer os.Unsetenv("AWS_SECRET_ACCESS_KEY")
+
+			b, err := NewBackendBlob(specs.NewLuetRDConfig(), "stable", map[string]string{
+				"blob-url": "s3://" + testBucket + "?region=us-east-1&disableSSL=true&s3ForcePathStyle=true&endpoint=" +
+					strings.TrimPrefix(server.URL, "http://"),
+			})
+			Expect(err).ToNot(HaveOccurred())
+			defer b.Close()
+
+			files, err := b.GetFilesList()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(files).To(Equal([]string{"repository.yaml"}))
+		})
+	})
+})
@@ -20,6 +20,7 @@ package backends
 import (
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -96,3 +97,92 @@ func (b *BackendLocal) CleanFile(file string) error {
 	absFile := filepath.Join(b.Path, file)
 	return os.Remove(absFile)
 }
+
+func (b *BackendLocal) MoveFile(src, dst string) error {
+	absDst := filepath.Join(b.Path, dst)
+
+	err := os.MkdirAll(filepath.Dir(absDst), os.ModePerm)
+	if err != nil {
+		return errors.New(
+			fmt.Sprintf("Error on create directory %s: %s",
+				filepath.Dir(absDst), err.Error()))
+	}
+
+	return os.Rename(filepath.Join(b.Path, src), absDst)
+}
+
+func (b *BackendLocal) OpenFile(file string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(b.Path, file))
+}
+
+func (b *BackendLocal) GetFileInfo(file string) (*specs.RepoFileInfo, error) {
+	info, err := os.Stat(filepath.Join(b.Path, file))
+	if err != nil {
+		return nil, err
+	}
+
+	return &specs.RepoFileInfo{
+		Name:    file,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	}, nil
+}
+
+func (b *BackendLocal) GetFilesInfo() ([]*specs.RepoFileInfo, error) {
+	ans := []*specs.RepoFileInfo{}
+
+	files, err := b.GetFilesList()
+	if err != nil {
+		return ans, err
+	}
+
+	for _, f := range files {
+		info, err := b.GetFileInfo(f)
+		if err != nil {
+			return ans, err
+		}
+		ans = append(ans, info)
+	}
+
+	return ans, nil
+}
+
+func (b *BackendLocal) UploadFile(src, dst string) error {
+	absDst := filepath.Join(b.Path, dst)
+
+	err := os.MkdirAll(filepath.Dir(absDst), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	// Write a temporary file to avoid partial files on errors.
+	out, err := ioutil.TempFile(filepath.Dir(absDst), "."+filepath.Base(dst))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+
+	_, err = io.Copy(out, in)
+	if err != nil {
+		out.Close()
+		return err
+	}
+
+	err = out.Close()
+	if err != nil {
+		return err
+	}
+
+	err = os.Chmod(out.Name(), 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(out.Name(), absDst)
+}
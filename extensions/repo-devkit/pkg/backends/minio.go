@@ -20,10 +20,16 @@ package backends
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"net/http"
 	"os"
+	"strings"
+	"sync"
 
 	"github.com/Luet-lab/extensions/extensions/repo-devkit/pkg/specs"
 
@@ -38,8 +44,17 @@ type BackendMinio struct {
 
 	MinioClient *minio.Client
 	Bucket      string
+	// Prefix of the objects of the repository inside the bucket.
+	// The files names are relative to the prefix.
+	Prefix string
+
+	// Objects informations retrieved by the last listing.
+	objects map[string]*specs.RepoFileInfo
+	mutex   sync.Mutex
 }
 
+// NewBackendMinio creates the minio backend. The prefix of the objects
+// is defined only by the option minio-prefix.
 func NewBackendMinio(specs *specs.LuetRDConfig, path string, opts map[string]string) (*BackendMinio, error) {
 
 	if path != "" {
@@ -77,6 +92,7 @@ func NewBackendMinio(specs *specs.LuetRDConfig, path string, opts map[string]str
 		Specs:        specs,
 		ArtefactPath: path,
 		Bucket:       opts["minio-bucket"],
+		Prefix:       GetMinioPrefix(opts),
 	}
 
 	minioRegion := ""
@@ -98,7 +114,7 @@ func NewBackendMinio(specs *specs.LuetRDConfig, path string, opts map[string]str
 		Creds: credentials.NewStaticV4(
 			opts["minio-keyid"],
 			opts["minio-secret"],
-			"",
+			opts["minio-session-token"],
 		),
 		Secure: minioSsl,
 	}
@@ -106,6 +122,13 @@ func NewBackendMinio(specs *specs.LuetRDConfig, path string, opts map[string]str
 		mOpts.Region = minioRegion
 	}
 
+	if caBundle, ok := opts["minio-ca-bundle"]; ok && caBundle != "" {
+		mOpts.Transport, err = newTransportWithCaBundle(caBundle)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	mClient, err = minio.New(
 		opts["minio-endpoint"],
 		mOpts,
@@ -130,34 +153,113 @@ func NewBackendMinio(specs *specs.LuetRDConfig, path string, opts map[string]str
 	return ans, nil
 }
 
-func (b *BackendMinio) GetFilesList() ([]string, error) {
-	ans := []string{}
+// GetMinioPrefix returns the prefix of the objects from the
+// option minio-prefix. The prefix is returned without the leading
+// slash and with the trailing slash.
+func GetMinioPrefix(opts map[string]string) string {
+	return normalizePrefix(opts["minio-prefix"])
+}
+
+func newTransportWithCaBundle(caBundle string) (*http.Transport, error) {
+	pem, err := ioutil.ReadFile(caBundle)
+	if err != nil {
+		return nil, errors.New(
+			fmt.Sprintf("Error on read CA bundle %s: %s", caBundle, err.Error()))
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("No valid certificates found in the CA bundle " + caBundle)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{
+		RootCAs:    pool,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	return transport, nil
+}
+
+func (b *BackendMinio) key(file string) string { return b.Prefix + file }
+
+func (b *BackendMinio) listObjects() ([]*specs.RepoFileInfo, error) {
+	ans := []*specs.RepoFileInfo{}
+	objects := make(map[string]*specs.RepoFileInfo, 0)
 	opts := minio.ListObjectsOptions{
 		Recursive: true,
-		Prefix:    "",
+		Prefix:    b.Prefix,
 	}
 
+	// The context is cancelled on return to stop the listing
+	// goroutine of the client also on error.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
 	// List all objects from a bucket-name with a matching prefix.
-	for object := range b.MinioClient.ListObjects(context.Background(), b.Bucket, opts) {
+	// The client follows the continuation tokens of the pages.
+	for object := range b.MinioClient.ListObjects(ctx, b.Bucket, opts) {
 		if object.Err != nil {
-			return ans, errors.New("Error on retrieve list of objects: " + object.Err.Error())
+			return nil, errors.New("Error on retrieve list of objects: " + object.Err.Error())
+		}
+
+		if !strings.HasPrefix(object.Key, b.Prefix) ||
+			strings.HasSuffix(object.Key, "/") {
+			continue
+		}
+
+		info := objectInfo2FileInfo(&object)
+		info.Name = strings.TrimPrefix(object.Key, b.Prefix)
+
+		if _, ok := objects[info.Name]; ok {
+			return nil, errors.New(
+				"Error on retrieve list of objects: duplicate object " + object.Key)
 		}
 
-		ans = append(ans, object.Key)
+		ans = append(ans, info)
+		objects[info.Name] = info
+	}
+
+	b.mutex.Lock()
+	b.objects = objects
+	b.mutex.Unlock()
+
+	return ans, nil
+}
+
+func (b *BackendMinio) GetFilesList() ([]string, error) {
+	ans := []string{}
+
+	objects, err := b.listObjects()
+	if err != nil {
+		return ans, err
+	}
+
+	for _, o := range objects {
+		ans = append(ans, o.Name)
 	}
 
 	return ans, nil
 }
 
+func (b *BackendMinio) GetFilesInfo() ([]*specs.RepoFileInfo, error) {
+	return b.listObjects()
+}
+
 func (b *BackendMinio) GetMetadata(file string) (*artifact.PackageArtifact, error) {
 	var outBuffer bytes.Buffer
 
 	object, err := b.MinioClient.GetObject(
-		context.Background(), b.Bucket, file, minio.GetObjectOptions{},
+		context.Background(), b.Bucket, b.key(file), minio.GetObjectOptions{},
 	)
 	if err != nil {
 		return nil, err
 	}
+	defer object.Close()
 
 	if _, err = io.Copy(&outBuffer, object); err != nil {
 		return nil, err
@@ -172,6 +274,85 @@ func (b *BackendMinio) CleanFile(file string) error {
 	opts := minio.RemoveObjectOptions{
 		GovernanceBypass: true,
 	}
-	return b.MinioClient.RemoveObject(context.Background(),
-		b.Bucket, file, opts)
+	err := b.MinioClient.RemoveObject(context.Background(),
+		b.Bucket, b.key(file), opts)
+	if err != nil {
+		return err
+	}
+
+	b.mutex.Lock()
+	delete(b.objects, file)
+	b.mutex.Unlock()
+
+	return nil
+}
+
+func (b *BackendMinio) MoveFile(src, dst string) error {
+	_, err := b.MinioClient.CopyObject(context.Background(),
+		minio.CopyDestOptions{
+			Bucket: b.Bucket,
+			Object: b.key(dst),
+		},
+		minio.CopySrcOptions{
+			Bucket: b.Bucket,
+			Object: b.key(src),
+		},
+	)
+	if err != nil {
+		return errors.New(
+			fmt.Sprintf("Error on copy object %s to %s: %s", src, dst, err.Error()))
+	}
+
+	return b.CleanFile(src)
+}
+
+func (b *BackendMinio) OpenFile(file string) (io.ReadCloser, error) {
+	return b.MinioClient.GetObject(
+		context.Background(), b.Bucket, b.key(file), minio.GetObjectOptions{},
+	)
+}
+
+func (b *BackendMinio) GetFileInfo(file string) (*specs.RepoFileInfo, error) {
+	b.mutex.Lock()
+	info, ok := b.objects[file]
+	b.mutex.Unlock()
+	if ok {
+		return info, nil
+	}
+
+	object, err := b.MinioClient.StatObject(context.Background(),
+		b.Bucket, b.key(file), minio.StatObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	info = objectInfo2FileInfo(&object)
+	info.Name = file
+
+	return info, nil
+}
+
+func objectInfo2FileInfo(o *minio.ObjectInfo) *specs.RepoFileInfo {
+	return &specs.RepoFileInfo{
+		Name:        o.Key,
+		Size:        o.Size,
+		ModTime:     o.LastModified,
+		ETag:        o.ETag,
+		ContentType: o.ContentType,
+	}
+}
+
+func (b *BackendMinio) UploadFile(src, dst string) error {
+	_, err := b.MinioClient.FPutObject(context.Background(),
+		b.Bucket, b.key(dst), src, minio.PutObjectOptions{})
+	if err != nil {
+		return err
+	}
+
+	// Drop the informations of the previous object.
+	b.mutex.Lock()
+	delete(b.objects, dst)
+	b.mutex.Unlock()
+
+	return nil
 }
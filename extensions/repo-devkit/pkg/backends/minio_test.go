@@ -0,0 +1,291 @@
+/*
+Copyright (C) 2020-2021  Daniele Rondina <geaaru@sabayonlinux.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+*/
+
+package backends_test
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	. "github.com/Luet-lab/extensions/extensions/repo-devkit/pkg/backends"
+	specs "github.com/Luet-lab/extensions/extensions/repo-devkit/pkg/specs"
+
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const testBucket = "repos"
+
+const testMetadata = `path: /repo/foo-app-1.0.package.tar
+compilespec:
+  package:
+    name: foo
+    version: "1.0"
+    category: app
+checksums:
+  sha256: 505e63fe42554be6d3ac4103db8ed0df5a880fec318d6d298cc4aa7d0f9ae508
+compressiontype: none
+`
+
+var _ = Describe("Minio Backend", func() {
+	var server *httptest.Server
+	var s3 *s3mem.Backend
+	var opts map[string]string
+
+	putObject := func(key, content string) {
+		// The metadata are set by gofakes3 only on upload through the API.
+		meta := map[string]string{
+			"Last-Modified": time.Now().UTC().Format(http.TimeFormat),
+		}
+		_, err := s3.PutObject(testBucket, key, meta,
+			strings.NewReader(content), int64(len(content)))
+		Expect(err).ToNot(HaveOccurred())
+	}
+
+	objectExists := func(key string) bool {
+		_, err := s3.HeadObject(testBucket, key)
+		return err == nil
+	}
+
+	newBackend := func(path string) *BackendMinio {
+		b, err := NewBackendMinio(specs.NewLuetRDConfig(), path, opts)
+		Expect(err).ToNot(HaveOccurred())
+		return b
+	}
+
+	BeforeEach(func() {
+		s3 = s3mem.New()
+		faker := gofakes3.New(s3).Server()
+		server = httptest.NewServer(http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				// gofakes3 handles an empty delimiter as a real delimiter
+				// and minio-go always sends it on recursive listing.
+				q := r.URL.Query()
+				if v, ok := q["delimiter"]; ok && len(v) == 1 && v[0] == "" {
+					q.Del("delimiter")
+					r.URL.RawQuery = q.Encode()
+				}
+				faker.ServeHTTP(w, r)
+			}))
+		Expect(s3.CreateBucket(testBucket)).To(Succeed())
+
+		opts = map[string]string{
+			"minio-endpoint": strings.TrimPrefix(server.URL, "http://"),
+			"minio-bucket":   testBucket,
+			"minio-keyid":    "id",
+			"minio-secret":   "secret",
+			// Set the region to avoid the bucket location request.
+			"minio-region": "us-east-1",
+			"minio-ssl":    "false",
+		}
+
+		putObject("stable/foo-app-1.0.metadata.yaml", testMetadata)
+		putObject("stable/foo-app-1.0.package.tar", "tarball")
+		putObject("stable/repository.yaml", "name: stable")
+		putObject("testing/bar-app-1.0.package.tar", "other repo")
+		putObject("root-file", "root")
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	Context("Prefix", func() {
+
+		It("Normalize prefix", func() {
+			Expect(GetMinioPrefix(map[string]string{})).To(Equal(""))
+			Expect(GetMinioPrefix(map[string]string{
+				"minio-prefix": "/stable/",
+			})).To(Equal("stable/"))
+			Expect(GetMinioPrefix(map[string]string{
+				"minio-prefix": "repos/stable",
+			})).To(Equal("repos/stable/"))
+		})
+
+		It("List files relative to the prefix", func() {
+			opts["minio-prefix"] = "stable"
+			b := newBackend("")
+
+			files, err := b.GetFilesList()
+			Expect(err).ToNot(HaveOccurred())
+			sort.Strings(files)
+			Expect(files).To(Equal([]string{
+				"foo-app-1.0.metadata.yaml",
+				"foo-app-1.0.package.tar",
+				"repository.yaml",
+			}))
+		})
+
+		It("Doesn't use the path as prefix", func() {
+			tmpdir, err := ioutil.TempDir("", "repo-devkit")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(tmpdir)
+
+			opts["minio-prefix"] = "testing"
+			b := newBackend(tmpdir)
+
+			files, err := b.GetFilesList()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(files).To(Equal([]string{"bar-app-1.0.package.tar"}))
+		})
+
+		It("List all the bucket without prefix", func() {
+			b := newBackend("")
+
+			files, err := b.GetFilesList()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(len(files)).To(Equal(5))
+		})
+	})
+
+	Context("Files informations", func() {
+
+		It("Returns size and etag from the listing", func() {
+			opts["minio-prefix"] = "stable"
+			b := newBackend("")
+
+			infos, err := b.GetFilesInfo()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(len(infos)).To(Equal(3))
+
+			for _, info := range infos {
+				if info.Name == "foo-app-1.0.package.tar" {
+					Expect(info.Size).To(Equal(int64(len("tarball"))))
+					Expect(info.ETag).ToNot(BeEmpty())
+					Expect(info.ModTime.IsZero()).To(BeFalse())
+				}
+			}
+		})
+
+		It("Returns informations of a file not listed", func() {
+			opts["minio-prefix"] = "stable"
+			b := newBackend("")
+
+			info, err := b.GetFileInfo("repository.yaml")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(info.Name).To(Equal("repository.yaml"))
+			Expect(info.Size).To(Equal(int64(len("name: stable"))))
+
+			_, err = b.GetFileInfo("missing.yaml")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("Files operations", func() {
+
+		It("Read metadata", func() {
+			opts["minio-prefix"] = "stable"
+			b := newBackend("")
+
+			art, err := b.GetMetadata("foo-app-1.0.metadata.yaml")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(art.CompileSpec.Package.GetPackageName()).To(Equal("foo-app"))
+		})
+
+		It("Open a file", func() {
+			opts["minio-prefix"] = "stable"
+			b := newBackend("")
+
+			r, err := b.OpenFile("foo-app-1.0.package.tar")
+			Expect(err).ToNot(HaveOccurred())
+			defer r.Close()
+
+			data, err := ioutil.ReadAll(r)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(data)).To(Equal("tarball"))
+		})
+
+		It("Move, upload and clean files inside the prefix", func() {
+			opts["minio-prefix"] = "stable"
+			b := newBackend("")
+
+			Expect(b.MoveFile("foo-app-1.0.package.tar",
+				".trash/1/foo-app-1.0.package.tar")).To(Succeed())
+			Expect(objectExists("stable/foo-app-1.0.package.tar")).To(BeFalse())
+			Expect(objectExists("stable/.trash/1/foo-app-1.0.package.tar")).To(BeTrue())
+
+			tmpdir, err := ioutil.TempDir("", "repo-devkit")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(tmpdir)
+
+			src := filepath.Join(tmpdir, "upload")
+			Expect(ioutil.WriteFile(src, []byte("new"), 0644)).To(Succeed())
+			Expect(b.UploadFile(src, "new-app-1.0.package.tar")).To(Succeed())
+			Expect(objectExists("stable/new-app-1.0.package.tar")).To(BeTrue())
+
+			Expect(b.CleanFile("repository.yaml")).To(Succeed())
+			Expect(objectExists("stable/repository.yaml")).To(BeFalse())
+			Expect(objectExists("root-file")).To(BeTrue())
+		})
+	})
+
+	Context("Pagination", func() {
+
+		It("List more objects of a page", func() {
+			var buf bytes.Buffer
+			for i := 0; i < 1200; i++ {
+				buf.Reset()
+				buf.WriteString("x")
+				putObject(fmt.Sprintf("big/file-%04d", i), buf.String())
+			}
+
+			opts["minio-prefix"] = "big"
+			b := newBackend("")
+
+			files, err := b.GetFilesList()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(len(files)).To(Equal(1200))
+		})
+	})
+
+	Context("Options", func() {
+
+		It("Fails with an invalid CA bundle", func() {
+			tmpdir, err := ioutil.TempDir("", "repo-devkit")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(tmpdir)
+
+			caBundle := filepath.Join(tmpdir, "ca.pem")
+			Expect(ioutil.WriteFile(caBundle, []byte("invalid"), 0644)).To(Succeed())
+			opts["minio-ca-bundle"] = caBundle
+
+			_, err = NewBackendMinio(specs.NewLuetRDConfig(), "", opts)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("Accept a session token", func() {
+			opts["minio-session-token"] = "token"
+			opts["minio-prefix"] = "stable"
+			b := newBackend("")
+
+			files, err := b.GetFilesList()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(len(files)).To(Equal(3))
+		})
+	})
+})
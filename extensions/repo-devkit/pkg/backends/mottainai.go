@@ -19,11 +19,17 @@ package backends
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/Luet-lab/extensions/extensions/repo-devkit/pkg/specs"
 
@@ -44,6 +50,12 @@ type BackendMottainai struct {
 	Config          *setting.Config
 	MottainaiClient client.HttpClient
 	Namespace       string
+
+	// Number of retries of the requests that fail with
+	// a temporary error. The delay between the retries
+	// is doubled on every retry.
+	Retries    int
+	RetryDelay time.Duration
 }
 
 func setupMottainaiCliConfig(opts map[string]string) (*setting.Config, error) {
@@ -144,6 +156,14 @@ func NewBackendMottainai(specs *specs.LuetRDConfig, path string, opts map[string
 		return nil, err
 	}
 
+	retries := 3
+	if v, ok := opts["mottainai-retries"]; ok && v != "" {
+		retries, err = strconv.Atoi(v)
+		if err != nil || retries < 0 {
+			return nil, errors.New("Invalid mottainai retries value " + v)
+		}
+	}
+
 	ans := &BackendMottainai{
 		Specs:        specs,
 		ArtefactPath: path,
@@ -153,49 +173,125 @@ func NewBackendMottainai(specs *specs.LuetRDConfig, path string, opts map[string
 			config.Viper.GetString("apikey"),
 			config,
 		),
-		Namespace: opts["mottainai-namespace"],
+		Namespace:  opts["mottainai-namespace"],
+		Retries:    retries,
+		RetryDelay: time.Second,
 	}
 
 	return ans, nil
 }
 
+// retry executes the function until it doesn't fail with
+// a temporary error or the retries are exhausted.
+func (b *BackendMottainai) retry(fn func() error) error {
+	delay := b.RetryDelay
+
+	err := fn()
+	for i := 1; i <= b.Retries && IsMottainaiTemporary(err); i++ {
+		Warning(fmt.Sprintf("%s. Retry %d/%d in %s...",
+			err.Error(), i, b.Retries, delay))
+		time.Sleep(delay)
+		delay *= 2
+
+		err = fn()
+	}
+
+	return err
+}
+
+func (b *BackendMottainai) fileUrl(file string) string {
+	return b.MottainaiClient.GetBaseURL() +
+		path.Join("/namespace/", b.Namespace, utils.PathEscape(file))
+}
+
+// GetFilesList returns the files of the namespace. The namespace API
+// returns the complete list in a single response. An error is returned
+// on every failure to avoid that an incomplete list is used on clean.
 func (b *BackendMottainai) GetFilesList() ([]string, error) {
 	var tlist []string
 	ans := []string{}
 
+	err := b.retry(func() error {
+		var err error
+		tlist, err = b.listFiles()
+		return err
+	})
+	if err != nil {
+		return ans, err
+	}
+
+	for _, f := range tlist {
+		// The paths are returned with the initial slash.
+		f = strings.TrimPrefix(f, "/")
+		if f == "" || strings.HasSuffix(f, "/") {
+			continue
+		}
+		ans = append(ans, f)
+	}
+
+	return ans, nil
+}
+
+func (b *BackendMottainai) listFiles() ([]string, error) {
+	op := "list files of namespace " + b.Namespace
+	tlist := []string{}
+
 	req := &schema.Request{
-		Route:  v1.Schema.GetNamespaceRoute("show_artefacts"),
-		Target: &tlist,
+		Route: v1.Schema.GetNamespaceRoute("show_artefacts"),
 		Options: map[string]interface{}{
 			":name": b.Namespace,
 		},
 	}
-	err := b.MottainaiClient.Handle(req)
-	if err != nil {
 
-		if req.Response != nil {
-			Error("HTTP CODE: ", req.Response.StatusCode)
-			Error(string(req.ResponseRaw))
-			return ans, err
+	var statusErr *MottainaiError
+	err := b.MottainaiClient.HandleRaw(req, func(body io.ReadCloser) error {
+		data, err := ioutil.ReadAll(body)
+		if err != nil {
+			return err
+		}
+		req.ResponseRaw = data
+
+		if req.Response.StatusCode < 200 || req.Response.StatusCode > 299 {
+			statusErr = newMottainaiStatusError(op, req.Response.StatusCode, data)
+			return statusErr
 		}
+
+		if err = json.Unmarshal(data, &tlist); err != nil {
+			statusErr = &MottainaiError{
+				Op:         op,
+				StatusCode: req.Response.StatusCode,
+				Kind:       ErrMottainaiResponse,
+				Err:        err,
+			}
+			return statusErr
+		}
+
+		return nil
+	})
+
+	if statusErr != nil {
+		return nil, statusErr
 	}
 
-	// Drop initial slash
-	for _, f := range tlist {
-		ans = append(ans, f[1:])
+	if err != nil {
+		// POST: the request is failed or the body is not
+		//       completely read.
+		return nil, &MottainaiError{
+			Op:   op,
+			Kind: ErrMottainaiNetwork,
+			Err:  err,
+		}
 	}
 
-	return ans, nil
+	return tlist, nil
 }
 
+// GetMetadata downloads the metadata file without retries: the
+// RepoKnife already retries the metadata downloads.
 func (b *BackendMottainai) GetMetadata(file string) (*artifact.PackageArtifact, error) {
 	var outBuffer bytes.Buffer
 
-	url := b.MottainaiClient.GetBaseURL() +
-		path.Join("/namespace/", b.Namespace, utils.PathEscape(file))
-
-	_, err := b.MottainaiClient.DownloadResource(url, &outBuffer,
-		b.Config.GetAgent().DownloadRateLimit)
+	err := b.download(file, &outBuffer)
 	if err != nil {
 		return nil, err
 	}
@@ -205,9 +301,165 @@ func (b *BackendMottainai) GetMetadata(file string) (*artifact.PackageArtifact,
 	return artifact.NewPackageArtifactFromYaml([]byte(fileContent))
 }
 
+func (b *BackendMottainai) download(file string, dst io.Writer) error {
+	_, err := b.MottainaiClient.DownloadResource(b.fileUrl(file), dst,
+		b.Config.GetAgent().DownloadRateLimit)
+	if err != nil {
+		return newMottainaiDownloadError("download file "+file, err)
+	}
+	return nil
+}
+
 func (b *BackendMottainai) CleanFile(file string) error {
-	_, err := b.MottainaiClient.NamespaceRemovePath(b.Namespace,
+	attempts := 0
+	return b.retry(func() error {
+		attempts++
+		err := b.removeFile(file)
+		// The file could be removed by a previous attempt
+		// failed after the remove.
+		if attempts > 1 && errors.Is(err, ErrMottainaiNotFound) {
+			return nil
+		}
+		return err
+	})
+}
+
+func (b *BackendMottainai) removeFile(file string) error {
+	op := "remove file " + file
+
+	resp, err := b.MottainaiClient.NamespaceRemovePath(b.Namespace,
 		"/"+file,
 	)
-	return err
+
+	if resp.Request != nil && resp.Request.Response != nil {
+		code := resp.Request.Response.StatusCode
+		if code < 200 || code > 299 {
+			return newMottainaiStatusError(op, code, resp.Request.ResponseRaw)
+		}
+	} else if err != nil {
+		return &MottainaiError{
+			Op:   op,
+			Kind: ErrMottainaiNetwork,
+			Err:  err,
+		}
+	}
+
+	if err != nil {
+		return &MottainaiError{
+			Op:   op,
+			Kind: ErrMottainaiResponse,
+			Err:  err,
+		}
+	}
+
+	if resp.Status == "ko" || resp.Error != "" {
+		return &MottainaiError{
+			Op:   op,
+			Kind: ErrMottainaiResponse,
+			Err:  errors.New(resp.Error),
+		}
+	}
+
+	return nil
+}
+
+func (b *BackendMottainai) MoveFile(src, dst string) error {
+	// The namespace API doesn't support the rename of a file.
+	// I download the file and I upload it to the new path.
+	tmpdir, err := ioutil.TempDir("", "repo-devkit")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpdir)
+
+	tmpfile := filepath.Join(tmpdir, path.Base(dst))
+
+	err = b.retry(func() error {
+		out, err := os.Create(tmpfile)
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+
+		return b.download(src, out)
+	})
+	if err != nil {
+		return err
+	}
+
+	err = b.upload(tmpfile, dst)
+	if err != nil {
+		return err
+	}
+
+	return b.CleanFile(src)
+}
+
+func (b *BackendMottainai) OpenFile(file string) (io.ReadCloser, error) {
+	reader, writer := io.Pipe()
+	go func() {
+		// The download is streamed and couldn't be retried.
+		writer.CloseWithError(b.download(file, writer))
+	}()
+
+	return reader, nil
+}
+
+func (b *BackendMottainai) GetFileInfo(file string) (*specs.RepoFileInfo, error) {
+	// The namespace API doesn't expose file informations, the
+	// metadata cache isn't supported.
+	return nil, nil
+}
+
+func (b *BackendMottainai) GetFilesInfo() ([]*specs.RepoFileInfo, error) {
+	ans := []*specs.RepoFileInfo{}
+
+	files, err := b.GetFilesList()
+	if err != nil {
+		return ans, err
+	}
+
+	for _, f := range files {
+		ans = append(ans, &specs.RepoFileInfo{Name: f})
+	}
+
+	return ans, nil
+}
+
+func (b *BackendMottainai) UploadFile(src, dst string) error {
+	// The namespace API uses the name of the local file.
+	if filepath.Base(src) != path.Base(dst) {
+		tmpdir, err := ioutil.TempDir("", "repo-devkit")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(tmpdir)
+
+		absSrc, err := filepath.Abs(src)
+		if err != nil {
+			return err
+		}
+
+		tmpfile := filepath.Join(tmpdir, path.Base(dst))
+		err = os.Symlink(absSrc, tmpfile)
+		if err != nil {
+			return err
+		}
+		src = tmpfile
+	}
+
+	return b.upload(src, dst)
+}
+
+// upload uploads the local file src with the same name of dst.
+// The upload overwrites the file and it could be retried.
+func (b *BackendMottainai) upload(src, dst string) error {
+	return b.retry(func() error {
+		err := b.MottainaiClient.UploadNamespaceFile(b.Namespace, src,
+			"/"+path.Dir(dst))
+		if err != nil {
+			return newMottainaiUploadError("upload file "+dst, err)
+		}
+		return nil
+	})
 }
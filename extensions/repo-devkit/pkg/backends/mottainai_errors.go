@@ -0,0 +1,150 @@
+/*
+Copyright (C) 2020-2021  Daniele Rondina <geaaru@sabayonlinux.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+*/
+package backends
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrMottainaiAuth is returned when the API key is missing,
+	// invalid or without the permissions for the operation.
+	ErrMottainaiAuth = errors.New("authentication failed")
+	// ErrMottainaiNotFound is returned when the namespace or the
+	// file doesn't exist.
+	ErrMottainaiNotFound = errors.New("not found")
+	// ErrMottainaiNetwork is returned when the master is not reachable.
+	ErrMottainaiNetwork = errors.New("network error")
+	// ErrMottainaiServer is returned on internal errors of the master.
+	ErrMottainaiServer = errors.New("server error")
+	// ErrMottainaiResponse is returned when the master replies
+	// with an unexpected status or an invalid body.
+	ErrMottainaiResponse = errors.New("invalid response")
+)
+
+// MottainaiError describes a failed request to the Mottainai master.
+// The kind of the error could be checked with errors.Is and one of
+// the ErrMottainai* errors.
+type MottainaiError struct {
+	Op         string
+	StatusCode int
+	Kind       error
+	Err        error
+}
+
+func (e *MottainaiError) Error() string {
+	msg := fmt.Sprintf("Error on %s: %s", e.Op, e.Kind.Error())
+	if e.StatusCode > 0 {
+		msg += fmt.Sprintf(" (HTTP %d)", e.StatusCode)
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *MottainaiError) Is(target error) bool { return target == e.Kind }
+func (e *MottainaiError) Unwrap() error        { return e.Err }
+
+// Temporary returns true if the request could be retried.
+func (e *MottainaiError) Temporary() bool {
+	return e.Kind == ErrMottainaiNetwork || e.Kind == ErrMottainaiServer
+}
+
+// IsMottainaiTemporary returns true if the error is a temporary
+// error of the Mottainai master.
+func IsMottainaiTemporary(err error) bool {
+	var merr *MottainaiError
+	return errors.As(err, &merr) && merr.Temporary()
+}
+
+func newMottainaiStatusError(op string, statusCode int, body []byte) *MottainaiError {
+	ans := &MottainaiError{
+		Op:         op,
+		StatusCode: statusCode,
+	}
+
+	switch {
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden:
+		ans.Kind = ErrMottainaiAuth
+	case statusCode == http.StatusNotFound:
+		ans.Kind = ErrMottainaiNotFound
+	case statusCode == http.StatusTooManyRequests || statusCode >= 500:
+		ans.Kind = ErrMottainaiServer
+	default:
+		ans.Kind = ErrMottainaiResponse
+	}
+
+	if msg := strings.TrimSpace(string(body)); msg != "" {
+		if len(msg) > 256 {
+			msg = msg[0:256] + "..."
+		}
+		ans.Err = errors.New(msg)
+	}
+
+	return ans
+}
+
+// newMottainaiDownloadError converts the errors of the download
+// of the client. The client reports only the HTTP status of the
+// failed downloads in the format "Error: 404 Not Found".
+func newMottainaiDownloadError(op string, err error) *MottainaiError {
+	msg := err.Error()
+	if strings.HasPrefix(msg, "Error: ") {
+		fields := strings.Fields(strings.TrimPrefix(msg, "Error: "))
+		if len(fields) > 0 {
+			if code, e := strconv.Atoi(fields[0]); e == nil {
+				return newMottainaiStatusError(op, code, nil)
+			}
+		}
+	}
+
+	return &MottainaiError{
+		Op:   op,
+		Kind: ErrMottainaiNetwork,
+		Err:  err,
+	}
+}
+
+// newMottainaiUploadError converts the errors of the upload of the
+// client that reports the status code at the end of the message.
+func newMottainaiUploadError(op string, err error) *MottainaiError {
+	msg := err.Error()
+	if strings.HasPrefix(msg, "[Upload] ") {
+		if idx := strings.LastIndex(msg, ": "); idx >= 0 {
+			if code, e := strconv.Atoi(msg[idx+2:]); e == nil {
+				return newMottainaiStatusError(op, code, nil)
+			}
+		}
+		return &MottainaiError{
+			Op:   op,
+			Kind: ErrMottainaiResponse,
+			Err:  err,
+		}
+	}
+
+	return &MottainaiError{
+		Op:   op,
+		Kind: ErrMottainaiNetwork,
+		Err:  err,
+	}
+}
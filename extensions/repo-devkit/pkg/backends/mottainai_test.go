@@ -0,0 +1,360 @@
+/*
+Copyright (C) 2020-2021  Daniele Rondina <geaaru@sabayonlinux.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+*/
+
+package backends_test
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/Luet-lab/extensions/extensions/repo-devkit/pkg/backends"
+	specs "github.com/Luet-lab/extensions/extensions/repo-devkit/pkg/specs"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const testApiKey = "secret-key"
+
+// fakeNamespaceApi is a fake of the namespace API of the Mottainai master.
+type fakeNamespaceApi struct {
+	mutex sync.Mutex
+	files map[string]string
+	// Number of requests that fail with the status before
+	// to reply correctly.
+	failures   int
+	failStatus int
+	requests   int
+	// Number of removes that fail with the status after
+	// the remove of the file.
+	lostRemoves int
+	// Number of uploads that fail with the status after
+	// the read of the file.
+	uploadFailures int
+	// Body of the list response if not empty.
+	listBody string
+}
+
+func (f *fakeNamespaceApi) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.requests++
+
+	if r.Header.Get("Authorization") != "token "+testApiKey {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"error":"unauthorized"}`))
+		return
+	}
+
+	if f.failures > 0 {
+		f.failures--
+		w.WriteHeader(f.failStatus)
+		return
+	}
+
+	switch {
+	case r.Method == "GET" && r.URL.Path == "/api/namespace/stable/list":
+		if f.listBody != "" {
+			w.Write([]byte(f.listBody))
+			return
+		}
+		list := []string{}
+		for k := range f.files {
+			list = append(list, "/"+k)
+		}
+		data, _ := json.Marshal(list)
+		w.Write(data)
+
+	case r.Method == "POST" && r.URL.Path == "/api/namespace/remove":
+		r.ParseForm()
+		file := strings.TrimPrefix(r.Form.Get("path"), "/")
+		if _, ok := f.files[file]; !ok || r.Form.Get("name") != "stable" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(f.files, file)
+		if f.lostRemoves > 0 {
+			f.lostRemoves--
+			w.WriteHeader(f.failStatus)
+			return
+		}
+		w.Write([]byte(`{"status":"ok","processed":"true"}`))
+
+	case r.Method == "POST" && r.URL.Path == "/api/namespace/upload":
+		file, _, err := r.FormFile("file")
+		if err != nil || r.FormValue("namespace") != "stable" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		content, err := ioutil.ReadAll(file)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if f.uploadFailures > 0 {
+			f.uploadFailures--
+			w.WriteHeader(f.failStatus)
+			return
+		}
+		name := strings.TrimPrefix(
+			path.Join(r.FormValue("path"), r.FormValue("name")), "/")
+		f.files[name] = string(content)
+		w.Write([]byte(`{"status":"ok","processed":"true"}`))
+
+	case r.Method == "GET" && strings.HasPrefix(r.URL.Path, "/namespace/stable/"):
+		content, ok := f.files[strings.TrimPrefix(r.URL.Path, "/namespace/stable/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(content))
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+var _ = Describe("Mottainai Backend", func() {
+	var server *httptest.Server
+	var api *fakeNamespaceApi
+	var opts map[string]string
+
+	newBackend := func() *BackendMottainai {
+		b, err := NewBackendMottainai(specs.NewLuetRDConfig(), "", opts)
+		Expect(err).ToNot(HaveOccurred())
+		b.RetryDelay = time.Millisecond
+		return b
+	}
+
+	BeforeEach(func() {
+		api = &fakeNamespaceApi{
+			files: map[string]string{
+				"foo-app-1.0.metadata.yaml": testMetadata,
+				"foo-app-1.0.package.tar":   "tarball",
+				"repository.yaml":           "name: stable",
+			},
+			failStatus: http.StatusInternalServerError,
+		}
+		server = httptest.NewServer(api)
+
+		opts = map[string]string{
+			"mottainai-master":    server.URL,
+			"mottainai-apikey":    testApiKey,
+			"mottainai-namespace": "stable",
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	Context("List files", func() {
+
+		It("Returns the files without the initial slash", func() {
+			b := newBackend()
+
+			files, err := b.GetFilesList()
+			Expect(err).ToNot(HaveOccurred())
+			sort.Strings(files)
+			Expect(files).To(Equal([]string{
+				"foo-app-1.0.metadata.yaml",
+				"foo-app-1.0.package.tar",
+				"repository.yaml",
+			}))
+		})
+
+		It("Accepts paths without the initial slash", func() {
+			api.listBody = `["repository.yaml", "/foo-app-1.0.package.tar", ""]`
+			b := newBackend()
+
+			files, err := b.GetFilesList()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(files).To(Equal([]string{
+				"repository.yaml", "foo-app-1.0.package.tar",
+			}))
+		})
+
+		It("Fails with an invalid API key", func() {
+			opts["mottainai-apikey"] = "invalid"
+			b := newBackend()
+
+			files, err := b.GetFilesList()
+			Expect(err).To(HaveOccurred())
+			Expect(errors.Is(err, ErrMottainaiAuth)).To(BeTrue())
+			Expect(files).To(BeEmpty())
+			// Authentication errors are not retried.
+			Expect(api.requests).To(Equal(1))
+		})
+
+		It("Fails with an invalid body", func() {
+			api.listBody = `<html>Maintenance</html>`
+			b := newBackend()
+
+			_, err := b.GetFilesList()
+			Expect(err).To(HaveOccurred())
+			Expect(errors.Is(err, ErrMottainaiResponse)).To(BeTrue())
+		})
+
+		It("Retries on server errors", func() {
+			api.failures = 2
+			b := newBackend()
+
+			files, err := b.GetFilesList()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(len(files)).To(Equal(3))
+			Expect(api.requests).To(Equal(3))
+		})
+
+		It("Fails when the retries are exhausted", func() {
+			api.failures = 10
+			opts["mottainai-retries"] = "2"
+			b := newBackend()
+
+			_, err := b.GetFilesList()
+			Expect(err).To(HaveOccurred())
+			Expect(errors.Is(err, ErrMottainaiServer)).To(BeTrue())
+			Expect(IsMottainaiTemporary(err)).To(BeTrue())
+			Expect(api.requests).To(Equal(3))
+		})
+
+		It("Fails when the master is not reachable", func() {
+			opts["mottainai-retries"] = "1"
+			b := newBackend()
+			server.Close()
+
+			_, err := b.GetFilesList()
+			Expect(err).To(HaveOccurred())
+			Expect(errors.Is(err, ErrMottainaiNetwork)).To(BeTrue())
+		})
+	})
+
+	Context("Files operations", func() {
+
+		It("Read metadata", func() {
+			b := newBackend()
+
+			art, err := b.GetMetadata("foo-app-1.0.metadata.yaml")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(art.CompileSpec.Package.GetPackageName()).To(Equal("foo-app"))
+		})
+
+		It("Read metadata without retry", func() {
+			api.failures = 1
+			api.failStatus = http.StatusBadGateway
+			b := newBackend()
+
+			_, err := b.GetMetadata("foo-app-1.0.metadata.yaml")
+			Expect(err).To(HaveOccurred())
+			Expect(IsMottainaiTemporary(err)).To(BeTrue())
+			Expect(api.requests).To(Equal(1))
+		})
+
+		It("Fails to read a missing file", func() {
+			b := newBackend()
+
+			_, err := b.GetMetadata("missing.metadata.yaml")
+			Expect(err).To(HaveOccurred())
+			Expect(errors.Is(err, ErrMottainaiNotFound)).To(BeTrue())
+			Expect(api.requests).To(Equal(1))
+
+			r, err := b.OpenFile("missing.package.tar")
+			Expect(err).ToNot(HaveOccurred())
+			_, err = ioutil.ReadAll(r)
+			Expect(errors.Is(err, ErrMottainaiNotFound)).To(BeTrue())
+		})
+
+		It("Clean files", func() {
+			b := newBackend()
+
+			Expect(b.CleanFile("foo-app-1.0.package.tar")).To(Succeed())
+			Expect(api.files).ToNot(HaveKey("foo-app-1.0.package.tar"))
+
+			err := b.CleanFile("foo-app-1.0.package.tar")
+			Expect(errors.Is(err, ErrMottainaiNotFound)).To(BeTrue())
+		})
+
+		It("Clean files removed by a failed attempt", func() {
+			api.lostRemoves = 1
+			b := newBackend()
+
+			Expect(b.CleanFile("foo-app-1.0.package.tar")).To(Succeed())
+			Expect(api.files).ToNot(HaveKey("foo-app-1.0.package.tar"))
+			Expect(api.requests).To(Equal(2))
+		})
+
+		It("Upload files", func() {
+			tmpdir, err := ioutil.TempDir("", "repo-devkit")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(tmpdir)
+
+			src := filepath.Join(tmpdir, "tarball")
+			Expect(ioutil.WriteFile(src, []byte("new tarball"), 0644)).To(Succeed())
+
+			api.uploadFailures = 1
+			b := newBackend()
+
+			Expect(b.UploadFile(src, "foo-app-1.1.package.tar")).To(Succeed())
+			Expect(api.files).To(HaveKeyWithValue("foo-app-1.1.package.tar", "new tarball"))
+			Expect(api.requests).To(Equal(2))
+		})
+
+		It("Move files", func() {
+			api.uploadFailures = 1
+			b := newBackend()
+
+			Expect(b.MoveFile("foo-app-1.0.package.tar", "foo-app-1.0.package.tar.bak")).To(Succeed())
+			Expect(api.files).To(HaveKeyWithValue("foo-app-1.0.package.tar.bak", "tarball"))
+			Expect(api.files).ToNot(HaveKey("foo-app-1.0.package.tar"))
+			// Download, the failed upload, upload and remove.
+			Expect(api.requests).To(Equal(4))
+		})
+
+		It("Fails to upload a file with a client error", func() {
+			api.uploadFailures = 1
+			api.failStatus = http.StatusBadRequest
+			b := newBackend()
+
+			err := b.MoveFile("foo-app-1.0.package.tar", "foo-app-1.0.package.tar.bak")
+			Expect(err).To(HaveOccurred())
+			Expect(IsMottainaiTemporary(err)).To(BeFalse())
+			Expect(api.files).To(HaveKey("foo-app-1.0.package.tar"))
+			Expect(api.requests).To(Equal(2))
+		})
+	})
+
+	Context("Options", func() {
+
+		It("Fails with invalid retries", func() {
+			opts["mottainai-retries"] = "foo"
+			_, err := NewBackendMottainai(specs.NewLuetRDConfig(), "", opts)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
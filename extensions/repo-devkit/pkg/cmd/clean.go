@@ -21,9 +21,9 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"time"
 
 	devkit "github.com/Luet-lab/extensions/extensions/repo-devkit/pkg/devkit"
-	specs "github.com/Luet-lab/extensions/extensions/repo-devkit/pkg/specs"
 
 	cobra "github.com/spf13/cobra"
 )
@@ -34,95 +34,80 @@ func NewCleanCommand() *cobra.Command {
 		Short: "Clean repository files.",
 		PreRun: func(cmd *cobra.Command, args []string) {
 			treePath, _ := cmd.Flags().GetStringArray("tree")
+			undo, _ := cmd.Flags().GetString("undo")
+			purge, _ := cmd.Flags().GetString("purge-older-than")
 
-			if len(treePath) == 0 {
+			if undo != "" && purge != "" {
+				fmt.Println("The options --undo and --purge-older-than are mutually exclusive.")
+				os.Exit(1)
+			}
+
+			if len(treePath) == 0 && undo == "" && purge == "" {
 				fmt.Println("At least one tree path is needed.")
 				os.Exit(1)
 			}
 		},
 		Run: func(cmd *cobra.Command, args []string) {
-			var s *specs.LuetRDConfig
-			var err error
-
-			specsFile, _ := cmd.Flags().GetString("specs-file")
-			backend, _ := cmd.Flags().GetString("backend")
-			path, _ := cmd.Flags().GetString("path")
+			s := loadSpecs(cmd)
+			backend, path, opts := mustResolveBackend(cmd, s)
 			treePath, _ := cmd.Flags().GetStringArray("tree")
 			dryRun, _ := cmd.Flags().GetBool("dry-run")
 			quiet, _ := cmd.Flags().GetBool("quiet")
-			mottainaiProfile, _ := cmd.Flags().GetString("mottainai-profile")
-			mottainaiMaster, _ := cmd.Flags().GetString("mottainai-master")
-			mottainaiApiKey, _ := cmd.Flags().GetString("mottainai-apikey")
-			mottainaiNamespace, _ := cmd.Flags().GetString("mottainai-namespace")
-
-			minioBucket, _ := cmd.Flags().GetString("minio-bucket")
-			minioAccessId, _ := cmd.Flags().GetString("minio-keyid")
-			minioSecret, _ := cmd.Flags().GetString("minio-secret")
-			minioEndpoint, _ := cmd.Flags().GetString("minio-endpoint")
-			minioRegion, _ := cmd.Flags().GetString("minio-region")
-
-			if specsFile == "" {
-				s = specs.NewLuetRDConfig()
-			} else {
-				s, err = specs.LoadSpecsFile(specsFile)
-				if err != nil {
-					fmt.Println("Error on load specs: " + err.Error())
-					os.Exit(1)
-				}
+			trash, _ := cmd.Flags().GetBool("trash")
+			journal, _ := cmd.Flags().GetString("journal")
+			journalDir, _ := cmd.Flags().GetString("journal-dir")
+			undo, _ := cmd.Flags().GetString("undo")
+			purge, _ := cmd.Flags().GetString("purge-older-than")
+			excludeExprs, _ := cmd.Flags().GetStringArray("exclude-expr")
+
+			err := s.GetCleaner().AddExcludeFilters(excludeExprs)
+			if err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
 			}
 
-			opts := make(map[string]string, 0)
-			if backend == "mottainai" {
-				if mottainaiProfile != "" {
-					opts["mottainai-profile"] = mottainaiProfile
-				}
-				if mottainaiMaster != "" {
-					opts["mottainai-master"] = mottainaiMaster
-				}
-				if mottainaiApiKey != "" {
-					opts["mottainai-apikey"] = mottainaiApiKey
-				}
-				if mottainaiNamespace != "" {
-					opts["mottainai-namespace"] = mottainaiNamespace
-				}
-			} else if backend == "minio" {
+			repoCleaner, err := devkit.NewRepoCleaner(s, backend, path, opts, dryRun)
+			if err != nil {
+				fmt.Println("Error on initialize repo cleaner: " + err.Error())
+				os.Exit(1)
+			}
 
-				if minioEndpoint != "" {
-					opts["minio-endpoint"] = minioEndpoint
-				} else {
-					opts["minio-endpoint"] = os.Getenv("MINIO_URL")
-				}
+			if !quiet {
+				repoCleaner.Verbose = true
+			}
 
-				if minioBucket != "" {
-					opts["minio-bucket"] = minioBucket
-				} else {
-					opts["minio-bucket"] = os.Getenv("MINIO_BUCKET")
-				}
+			setupAnalyze(cmd, repoCleaner.RepoKnife)
 
-				if minioAccessId != "" {
-					opts["minio-keyid"] = minioAccessId
-				} else {
-					opts["minio-keyid"] = os.Getenv("MINIO_ID")
+			if undo != "" {
+				err = repoCleaner.Undo(undo)
+				if err != nil {
+					fmt.Println("Error on restore files: " + err.Error())
+					os.Exit(1)
 				}
+				fmt.Println("All done.")
+				return
+			}
 
-				if minioSecret != "" {
-					opts["minio-secret"] = minioSecret
-				} else {
-					opts["minio-secret"] = os.Getenv("MINIO_SECRET")
+			if purge != "" {
+				olderThan, err := time.ParseDuration(purge)
+				if err != nil {
+					fmt.Println("Invalid duration " + purge + ": " + err.Error())
+					os.Exit(1)
 				}
 
-				opts["minio-region"] = minioRegion
-
-			}
-
-			repoCleaner, err := devkit.NewRepoCleaner(s, backend, path, opts, dryRun)
-			if err != nil {
-				fmt.Println("Error on initialize repo cleaner: " + err.Error())
-				os.Exit(1)
+				n, err := repoCleaner.Purge(journalDir, olderThan)
+				if err != nil {
+					fmt.Println("Error on purge trash: " + err.Error())
+					os.Exit(1)
+				}
+				fmt.Println(fmt.Sprintf("All done. Purged journals %d.", n))
+				return
 			}
 
-			if !quiet {
-				repoCleaner.Verbose = true
+			if trash {
+				repoCleaner.Trash = true
+				repoCleaner.JournalFile = journal
+				repoCleaner.JournalDir = journalDir
 			}
 
 			// Loading tree in memory
@@ -144,6 +129,13 @@ func NewCleanCommand() *cobra.Command {
 					repoCleaner.ProcessedFiles,
 					len(repoCleaner.Files2Remove),
 				))
+			} else if trash {
+				fmt.Println(fmt.Sprintf(
+					"All done. Processed file %d. Moved to trash files %d. Journal %s.",
+					repoCleaner.ProcessedFiles,
+					len(repoCleaner.Files2Remove),
+					repoCleaner.JournalFile,
+				))
 			} else {
 				fmt.Println(fmt.Sprintf(
 					"All done. Processed file %d. Removed files %d.",
@@ -155,25 +147,20 @@ func NewCleanCommand() *cobra.Command {
 	}
 
 	var flags = cmd.Flags()
-	flags.StringP("backend", "b", "local", "Select backend repository: local|mottainai.")
-	flags.StringP("path", "p", "", "Path of the repository artefacts.")
+	addBackendFlags(flags)
 	flags.Bool("dry-run", false, "Only check files to remove.")
 	flags.Bool("quiet", false, "Quiet output.")
-	flags.String("mottainai-profile", "", "Set mottainai profile to use.")
-	flags.String("mottainai-master", "", "Set mottainai Server to use.")
-	flags.String("mottainai-apikey", "", "Set mottainai API Key to use.")
-	flags.String("mottainai-namespace", "", "Set mottainai namespace to use.")
-
-	// Minio options
-	flags.String("minio-bucket", "",
-		"Set minio bucket to use or set env MINIO_BUCKET.")
-	flags.String("minio-endpoint", "",
-		"Set minio endpoint to use or set env MINIO_URL.")
-	flags.String("minio-keyid", "",
-		"Set minio Access Key to use or set env MINIO_ID.")
-	flags.String("minio-secret", "",
-		"Set minio Access Key to use or set env MINIO_SECRET.")
-	flags.String("minio-region", "", "Optinally define the minio region.")
+	flags.StringArray("exclude-expr", []string{},
+		"Define one or more filter expressions of the packages to keep.")
+	flags.Bool("trash", false,
+		"Move files to the trash area of the backend and write a journal instead of removing them.")
+	flags.String("journal", "",
+		"Path of the journal file to write with --trash. Default is inside --journal-dir.")
+	flags.String("journal-dir", ".", "Directory where journals are written and read.")
+	flags.String("undo", "", "Restore the files moved to trash by the journal.")
+	flags.String("purge-older-than", "",
+		"Remove definitively the trash files of the journals older than the duration (ex. 72h).")
+	addAnalyzeFlags(flags)
 
 	return cmd
 }
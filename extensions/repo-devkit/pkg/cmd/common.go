@@ -0,0 +1,312 @@
+/*
+Copyright (C) 2020-2021  Daniele Rondina <geaaru@sabayonlinux.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+*/
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	devkit "github.com/Luet-lab/extensions/extensions/repo-devkit/pkg/devkit"
+	specs "github.com/Luet-lab/extensions/extensions/repo-devkit/pkg/specs"
+
+	. "github.com/mudler/luet/pkg/logger"
+	cobra "github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+func addBackendFlags(flags *pflag.FlagSet) {
+	flags.String("profile", "", "Use the backend profile defined in the specs file.")
+	flags.StringP("backend", "b", "local", "Select backend repository: local|mottainai|minio|blob.")
+	flags.StringP("path", "p", "", "Path of the repository artefacts.")
+	flags.String("mottainai-profile", "", "Set mottainai profile to use.")
+	flags.String("mottainai-master", "", "Set mottainai Server to use.")
+	flags.String("mottainai-apikey", "", "Set mottainai API Key to use.")
+	flags.String("mottainai-namespace", "", "Set mottainai namespace to use.")
+	flags.String("mottainai-retries", "",
+		"Set the number of retries of the mottainai requests on temporary errors (default 3).")
+	flags.String("minio-bucket", "",
+		"Set minio bucket to use or set env MINIO_BUCKET.")
+	flags.String("minio-endpoint", "",
+		"Set minio endpoint to use or set env MINIO_URL.")
+	flags.String("minio-keyid", "",
+		"Set minio Access Key to use or set env MINIO_ID.")
+	flags.String("minio-secret", "",
+		"Set minio Access Key to use or set env MINIO_SECRET.")
+	flags.String("minio-region", "", "Optinally define the minio region.")
+	flags.String("minio-prefix", "",
+		"Optionally define the prefix of the repository objects inside the bucket.")
+	flags.String("minio-session-token", "",
+		"Optionally define the S3 session token or set env MINIO_SESSION_TOKEN.")
+	flags.String("minio-ca-bundle", "",
+		"Optionally define a PEM file with the CA certificates to trust.")
+	flags.String("blob-url", "",
+		"Set the URL of the blob bucket (s3://, gs://, azblob://, file://) or set env BLOB_URL.")
+	flags.String("blob-prefix", "",
+		"Optionally define the prefix of the repository objects inside the blob bucket.")
+}
+
+// backendFlags contains the backend flags for every backend type.
+var backendFlags = map[string][]string{
+	"mottainai": []string{
+		"mottainai-profile", "mottainai-master",
+		"mottainai-apikey", "mottainai-namespace",
+		"mottainai-retries",
+	},
+	"minio": []string{
+		"minio-endpoint", "minio-bucket", "minio-keyid",
+		"minio-secret", "minio-region", "minio-prefix",
+		"minio-session-token", "minio-ca-bundle",
+	},
+	"blob": []string{
+		"blob-url", "blob-prefix",
+	},
+}
+
+// resolveBackend returns the backend, the path and the options
+// of the backend to use. The values of the profile selected with
+// --profile are overridden by the flags explicitly set.
+func resolveBackend(cmd *cobra.Command, s *specs.LuetRDConfig) (string, string, map[string]string, error) {
+	backend, _ := cmd.Flags().GetString("backend")
+	path, _ := cmd.Flags().GetString("path")
+	profileName, _ := cmd.Flags().GetString("profile")
+	opts := make(map[string]string, 0)
+
+	if profileName != "" {
+		profile, err := s.GetProfile(profileName)
+		if err != nil {
+			return "", "", nil, err
+		}
+
+		pBackend, pPath, pOpts, err := profile.ToOptions()
+		if err != nil {
+			return "", "", nil, errors.New(
+				fmt.Sprintf("Invalid profile %s: %s", profileName, err.Error()))
+		}
+
+		if !cmd.Flags().Changed("backend") {
+			backend = pBackend
+		}
+		if !cmd.Flags().Changed("path") {
+			path = pPath
+		}
+		if backend == pBackend {
+			opts = pOpts
+		}
+	}
+
+	for _, k := range backendFlags[backend] {
+		v, _ := cmd.Flags().GetString(k)
+		if cmd.Flags().Changed(k) || (profileName == "" && v != "") {
+			opts[k] = v
+		}
+	}
+
+	setEnvDefaults(backend, opts)
+
+	return backend, path, opts, nil
+}
+
+// mustResolveBackend is like resolveBackend but exits on error.
+func mustResolveBackend(cmd *cobra.Command, s *specs.LuetRDConfig) (string, string, map[string]string) {
+	backend, path, opts, err := resolveBackend(cmd, s)
+	if err != nil {
+		fmt.Println("Error on resolve backend: " + err.Error())
+		os.Exit(1)
+	}
+	return backend, path, opts
+}
+
+// backendEnvs contains the environment variables used as
+// default values of the backend options.
+var backendEnvs = map[string]map[string]string{
+	"minio": map[string]string{
+		"minio-endpoint":      "MINIO_URL",
+		"minio-bucket":        "MINIO_BUCKET",
+		"minio-keyid":         "MINIO_ID",
+		"minio-secret":        "MINIO_SECRET",
+		"minio-session-token": "MINIO_SESSION_TOKEN",
+	},
+	"blob": map[string]string{
+		"blob-url": "BLOB_URL",
+	},
+}
+
+// setEnvDefaults sets the options of the backend not defined
+// with the values of the environment variables.
+func setEnvDefaults(backend string, opts map[string]string) {
+	for k, env := range backendEnvs[backend] {
+		if opts[k] == "" {
+			opts[k] = os.Getenv(env)
+		}
+	}
+}
+
+// isBackendKey returns true if the key is a valid key of a
+// backend definition.
+func isBackendKey(k string) bool {
+	switch k {
+	case "backend", "path", "profile":
+		return true
+	}
+	for _, keys := range backendFlags {
+		for _, key := range keys {
+			if k == key {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// splitBackendString splits the options of a backend definition.
+// A comma starts a new option only if it's followed by a valid
+// key, so the values could contain commas.
+func splitBackendString(def string) []string {
+	ans := []string{}
+	for _, kv := range strings.Split(def, ",") {
+		k := strings.TrimSpace(strings.SplitN(kv, "=", 2)[0])
+		if len(ans) > 0 && (!strings.Contains(kv, "=") || !isBackendKey(k)) {
+			ans[len(ans)-1] += "," + kv
+			continue
+		}
+		ans = append(ans, kv)
+	}
+	return ans
+}
+
+// parseBackendString parses a backend definition in the format
+// backend=minio,minio-bucket=mybucket,path=/path. The keys are
+// the same of the backend flags. The definition could be also
+// the name of a profile or contains the key profile=<name>
+// to use the profile values as defaults.
+func parseBackendString(s *specs.LuetRDConfig, def string) (string, string, map[string]string, error) {
+	backend := "local"
+	path := ""
+	opts := make(map[string]string, 0)
+
+	if def != "" && !strings.Contains(def, "=") {
+		def = "profile=" + def
+	}
+
+	options := splitBackendString(def)
+
+	for _, kv := range options {
+		if !strings.HasPrefix(strings.TrimSpace(kv), "profile=") {
+			continue
+		}
+
+		name := strings.TrimSpace(strings.SplitN(kv, "=", 2)[1])
+		profile, err := s.GetProfile(name)
+		if err != nil {
+			return "", "", nil, err
+		}
+
+		backend, path, opts, err = profile.ToOptions()
+		if err != nil {
+			return "", "", nil, errors.New(
+				fmt.Sprintf("Invalid profile %s: %s", name, err.Error()))
+		}
+	}
+
+	for _, kv := range options {
+		if strings.TrimSpace(kv) == "" {
+			continue
+		}
+
+		fields := strings.SplitN(kv, "=", 2)
+		if len(fields) != 2 || !isBackendKey(strings.TrimSpace(fields[0])) {
+			return "", "", nil, errors.New("Invalid backend option " + kv)
+		}
+
+		k := strings.TrimSpace(fields[0])
+		v := strings.TrimSpace(fields[1])
+
+		switch k {
+		case "profile":
+			continue
+		case "backend":
+			backend = v
+		case "path":
+			path = v
+		default:
+			opts[k] = v
+		}
+	}
+
+	setEnvDefaults(backend, opts)
+
+	return backend, path, opts, nil
+}
+
+func addAnalyzeFlags(flags *pflag.FlagSet) {
+	flags.Int("concurrency", 4, "Number of parallel metadata downloads.")
+	flags.Int("retries", 3, "Number of retries on metadata download errors.")
+	flags.String("cache-dir", "",
+		"Directory of the metadata cache. Default is the user cache directory.\n"+
+			"The cache isn't supported by the mottainai backend.")
+	flags.Bool("no-cache", false, "Disable the metadata cache.")
+}
+
+func setupAnalyze(cmd *cobra.Command, knife *devkit.RepoKnife) {
+	concurrency, _ := cmd.Flags().GetInt("concurrency")
+	retries, _ := cmd.Flags().GetInt("retries")
+	cacheDir, _ := cmd.Flags().GetString("cache-dir")
+	noCache, _ := cmd.Flags().GetBool("no-cache")
+
+	knife.Concurrency = concurrency
+	knife.Retries = retries
+
+	// The local backend doesn't need cache.
+	if noCache || (knife.Backend == "local" && cacheDir == "") {
+		return
+	}
+
+	if cacheDir == "" {
+		userCacheDir, err := os.UserCacheDir()
+		if err != nil {
+			Warning("Metadata cache disabled: " + err.Error())
+			return
+		}
+		cacheDir = filepath.Join(userCacheDir, "luet-repo-devkit")
+	}
+
+	err := knife.SetupCache(cacheDir)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+}
+
+func loadSpecs(cmd *cobra.Command) *specs.LuetRDConfig {
+	specsFile, _ := cmd.Flags().GetString("specs-file")
+	if specsFile == "" {
+		return specs.NewLuetRDConfig()
+	}
+
+	s, err := specs.LoadSpecsFile(specsFile)
+	if err != nil {
+		fmt.Println("Error on load specs: " + err.Error())
+		os.Exit(1)
+	}
+
+	return s
+}
@@ -0,0 +1,154 @@
+/*
+Copyright (C) 2020-2021  Daniele Rondina <geaaru@sabayonlinux.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+*/
+
+package cmd
+
+import (
+	"os"
+
+	specs "github.com/Luet-lab/extensions/extensions/repo-devkit/pkg/specs"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	cobra "github.com/spf13/cobra"
+)
+
+var _ = Describe("Backend definitions", func() {
+	var s *specs.LuetRDConfig
+
+	newCommand := func(args ...string) *cobra.Command {
+		cmd := &cobra.Command{}
+		addBackendFlags(cmd.Flags())
+		Expect(cmd.Flags().Parse(args)).To(Succeed())
+		return cmd
+	}
+
+	BeforeEach(func() {
+		os.Setenv("REPO_DEVKIT_TEST_SECRET", "env,secret")
+		s = specs.NewLuetRDConfig()
+		s.Profiles = map[string]specs.LuetRDCProfile{
+			"stable": {
+				Type:     "minio",
+				Path:     "/repo",
+				Endpoint: "minio:9000",
+				Bucket:   "stable",
+				KeyId:    specs.LuetRDCSecret{Value: "id"},
+				Secret:   specs.LuetRDCSecret{Env: "REPO_DEVKIT_TEST_SECRET"},
+			},
+			"broken": {
+				Type:   "minio",
+				Secret: specs.LuetRDCSecret{Env: "REPO_DEVKIT_TEST_MISSING"},
+			},
+		}
+	})
+
+	AfterEach(func() {
+		os.Unsetenv("REPO_DEVKIT_TEST_SECRET")
+	})
+
+	Context("Strings", func() {
+
+		It("Parses the options", func() {
+			backend, path, opts, err := parseBackendString(s,
+				"backend=minio,path=/repo,minio-bucket=testing,minio-secret=a,b=c,minio-keyid=id")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(backend).To(Equal("minio"))
+			Expect(path).To(Equal("/repo"))
+			Expect(opts["minio-bucket"]).To(Equal("testing"))
+			Expect(opts["minio-secret"]).To(Equal("a,b=c"))
+			Expect(opts["minio-keyid"]).To(Equal("id"))
+		})
+
+		It("Keeps the commas of the paths", func() {
+			backend, path, _, err := parseBackendString(s, "backend=local,path=/repo/a,b")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(backend).To(Equal("local"))
+			Expect(path).To(Equal("/repo/a,b"))
+		})
+
+		It("Uses the profile", func() {
+			backend, path, opts, err := parseBackendString(s, "stable")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(backend).To(Equal("minio"))
+			Expect(path).To(Equal("/repo"))
+			Expect(opts["minio-bucket"]).To(Equal("stable"))
+			Expect(opts["minio-secret"]).To(Equal("env,secret"))
+		})
+
+		It("Overrides the profile values", func() {
+			_, _, opts, err := parseBackendString(s, "minio-bucket=next,profile=stable")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(opts["minio-bucket"]).To(Equal("next"))
+			Expect(opts["minio-endpoint"]).To(Equal("minio:9000"))
+		})
+
+		It("Reports the profile of the missing secret", func() {
+			_, _, _, err := parseBackendString(s, "broken")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("broken"))
+			Expect(err.Error()).To(ContainSubstring("REPO_DEVKIT_TEST_MISSING"))
+		})
+
+		It("Fails on missing profile", func() {
+			_, _, _, err := parseBackendString(s, "missing")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("missing"))
+		})
+
+		It("Fails on invalid option", func() {
+			_, _, _, err := parseBackendString(s, "unknown=value,backend=local")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("Flags", func() {
+
+		It("Uses the profile", func() {
+			backend, path, opts, err := resolveBackend(newCommand("--profile", "stable"), s)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(backend).To(Equal("minio"))
+			Expect(path).To(Equal("/repo"))
+			Expect(opts["minio-keyid"]).To(Equal("id"))
+			Expect(opts["minio-secret"]).To(Equal("env,secret"))
+		})
+
+		It("Overrides the profile values with the flags", func() {
+			_, path, opts, err := resolveBackend(newCommand(
+				"--profile", "stable", "--path", "/other", "--minio-bucket", "next"), s)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(path).To(Equal("/other"))
+			Expect(opts["minio-bucket"]).To(Equal("next"))
+			Expect(opts["minio-endpoint"]).To(Equal("minio:9000"))
+		})
+
+		It("Drops the profile options of another backend", func() {
+			backend, _, opts, err := resolveBackend(newCommand(
+				"--profile", "stable", "--backend", "local"), s)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(backend).To(Equal("local"))
+			Expect(opts).To(BeEmpty())
+		})
+
+		It("Reports the profile of the missing secret", func() {
+			_, _, _, err := resolveBackend(newCommand("--profile", "broken"), s)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("broken"))
+			Expect(err.Error()).To(ContainSubstring("REPO_DEVKIT_TEST_MISSING"))
+		})
+	})
+})
@@ -0,0 +1,143 @@
+/*
+Copyright (C) 2020-2021  Daniele Rondina <geaaru@sabayonlinux.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+*/
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	devkit "github.com/Luet-lab/extensions/extensions/repo-devkit/pkg/devkit"
+	specs "github.com/Luet-lab/extensions/extensions/repo-devkit/pkg/specs"
+
+	cobra "github.com/spf13/cobra"
+)
+
+func newKnifeFromString(s *specs.LuetRDConfig, def string) (*devkit.RepoKnife, error) {
+	backend, path, opts, err := parseBackendString(s, def)
+	if err != nil {
+		return nil, err
+	}
+
+	return devkit.NewRepoKnife(s, backend, path, opts)
+}
+
+func NewDiffCommand() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "diff [OPTIONS]",
+		Short: "Show differences between the packages of two repositories.",
+		Long: `Show differences between the packages of two repositories.
+
+The backends are defined with a comma separated list of options
+with the same name of the backend flags. For example:
+
+  $ luet-repo-devkit diff \
+      --from backend=local,path=/repo/testing \
+      --to backend=minio,minio-bucket=stable
+
+The backend could be also the name of a profile defined in the
+specs file or use the profile=<name> option to override only a
+few values of the profile:
+
+  $ luet-repo-devkit diff --specs-file repo-devkit.yaml \
+      --from testing --to profile=stable,minio-bucket=stable-next
+
+A comma starts a new option only if it's followed by a valid option
+name, so the values could contain commas.
+
+The minio credentials could be supplied with the MINIO_* environment
+variables.`,
+		PreRun: func(cmd *cobra.Command, args []string) {
+			from, _ := cmd.Flags().GetString("from")
+			to, _ := cmd.Flags().GetString("to")
+
+			if from == "" || to == "" {
+				fmt.Println("Both --from and --to options are needed.")
+				os.Exit(1)
+			}
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			s := loadSpecs(cmd)
+			from, _ := cmd.Flags().GetString("from")
+			to, _ := cmd.Flags().GetString("to")
+			jsonOutput, _ := cmd.Flags().GetBool("json")
+
+			fromKnife, err := newKnifeFromString(s, from)
+			if err != nil {
+				fmt.Println("Error on initialize source repository: " + err.Error())
+				os.Exit(1)
+			}
+			setupAnalyze(cmd, fromKnife)
+
+			toKnife, err := newKnifeFromString(s, to)
+			if err != nil {
+				fmt.Println("Error on initialize target repository: " + err.Error())
+				os.Exit(1)
+			}
+			setupAnalyze(cmd, toKnife)
+
+			report, err := devkit.NewRepoDiff(fromKnife, toKnife).Run()
+			if err != nil {
+				fmt.Println("Error on compare repositories: " + err.Error())
+				os.Exit(1)
+			}
+
+			if jsonOutput {
+				data, _ := json.Marshal(report)
+				fmt.Println(string(data))
+				return
+			}
+
+			for _, p := range report.OnlyFrom {
+				fmt.Println(fmt.Sprintf("- %s (%s)", p.Package,
+					strings.Join(p.Versions, ", ")))
+			}
+			for _, p := range report.OnlyTo {
+				fmt.Println(fmt.Sprintf("+ %s (%s)", p.Package,
+					strings.Join(p.Versions, ", ")))
+			}
+			for _, d := range report.VersionDrift {
+				fmt.Println(fmt.Sprintf("~ %s (%s) -> (%s)", d.Package,
+					strings.Join(d.From, ", "), strings.Join(d.To, ", ")))
+			}
+			for _, d := range report.FilesDiff {
+				fmt.Println(fmt.Sprintf("~ %s files:", d.Package))
+				for _, f := range d.Removed {
+					fmt.Println("    - " + f)
+				}
+				for _, f := range d.Added {
+					fmt.Println("    + " + f)
+				}
+			}
+
+			if report.IsEmpty() {
+				fmt.Println("No differences found.")
+			}
+		},
+	}
+
+	var flags = cmd.Flags()
+	flags.String("from", "", "Definition of the source backend.")
+	flags.String("to", "", "Definition of the target backend.")
+	flags.Bool("json", false, "Show differences in JSON format.")
+	addAnalyzeFlags(flags)
+
+	return cmd
+}
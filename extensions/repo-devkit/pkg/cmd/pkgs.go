@@ -20,14 +20,17 @@ package cmd
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"regexp"
 	"sort"
+	"strings"
 
 	devkit "github.com/Luet-lab/extensions/extensions/repo-devkit/pkg/devkit"
-	specs "github.com/Luet-lab/extensions/extensions/repo-devkit/pkg/specs"
+	filter "github.com/Luet-lab/extensions/extensions/repo-devkit/pkg/filter"
 
+	. "github.com/mudler/luet/pkg/logger"
 	luet_pkg "github.com/mudler/luet/pkg/package"
 	luet_spectooling "github.com/mudler/luet/pkg/spectooling"
 	cobra "github.com/spf13/cobra"
@@ -41,101 +44,67 @@ func NewPkgsCommand() *cobra.Command {
 			treePath, _ := cmd.Flags().GetStringArray("tree")
 			listAvailables, _ := cmd.Flags().GetBool("availables")
 			listMissings, _ := cmd.Flags().GetBool("missings")
+			listOutdated, _ := cmd.Flags().GetBool("outdated")
+			rdeps, _ := cmd.Flags().GetString("rdeps")
+			buildOrder, _ := cmd.Flags().GetBool("build-ordered")
+			outputFormat, _ := cmd.Flags().GetString("output-format")
 
 			if len(treePath) == 0 {
 				fmt.Println("At least one tree path is needed.")
 				os.Exit(1)
 			}
 
-			if (listAvailables && listMissings) ||
-				(!listAvailables && !listMissings) {
+			nModes := 0
+			for _, m := range []bool{listAvailables, listMissings, listOutdated, rdeps != ""} {
+				if m {
+					nModes++
+				}
+			}
+			if nModes != 1 {
 				fmt.Println(
-					"It's needed enable or the --availables or --missings options.",
+					"It's needed enable or the --availables or --missings or --outdated or --rdeps options.",
 				)
 				os.Exit(1)
 			}
 
+			if outputFormat != "" {
+				if !listMissings || !buildOrder {
+					fmt.Println(
+						"The --output-format option is available only with --missings --build-ordered.",
+					)
+					os.Exit(1)
+				}
+
+				if _, ok := buildQueueFormats[outputFormat]; !ok {
+					fmt.Println("Invalid output format " + outputFormat)
+					os.Exit(1)
+				}
+			}
+
 		},
 		Run: func(cmd *cobra.Command, args []string) {
-			var s *specs.LuetRDConfig
-			var err error
-
-			specsFile, _ := cmd.Flags().GetString("specs-file")
-			backend, _ := cmd.Flags().GetString("backend")
-			path, _ := cmd.Flags().GetString("path")
+			s := loadSpecs(cmd)
+			backend, path, opts := mustResolveBackend(cmd, s)
 			treePath, _ := cmd.Flags().GetStringArray("tree")
 			listAvailables, _ := cmd.Flags().GetBool("availables")
 			listMissings, _ := cmd.Flags().GetBool("missings")
+			listOutdated, _ := cmd.Flags().GetBool("outdated")
+			buildValues, _ := cmd.Flags().GetStringArray("values")
+			rdeps, _ := cmd.Flags().GetString("rdeps")
+			depth, _ := cmd.Flags().GetInt("depth")
 			buildOrder, _ := cmd.Flags().GetBool("build-ordered")
 			buildOrderWithResolve, _ := cmd.Flags().GetBool("build-ordered-with-resolve")
 			filters, _ := cmd.Flags().GetStringArray("filter")
-
-			mottainaiProfile, _ := cmd.Flags().GetString("mottainai-profile")
-			mottainaiMaster, _ := cmd.Flags().GetString("mottainai-master")
-			mottainaiApiKey, _ := cmd.Flags().GetString("mottainai-apikey")
-			mottainaiNamespace, _ := cmd.Flags().GetString("mottainai-namespace")
-
-			minioBucket, _ := cmd.Flags().GetString("minio-bucket")
-			minioAccessId, _ := cmd.Flags().GetString("minio-keyid")
-			minioSecret, _ := cmd.Flags().GetString("minio-secret")
-			minioEndpoint, _ := cmd.Flags().GetString("minio-endpoint")
-			minioRegion, _ := cmd.Flags().GetString("minio-region")
+			filterExprs, _ := cmd.Flags().GetStringArray("filter-expr")
+			outputFormat, _ := cmd.Flags().GetString("output-format")
 
 			jsonOutput, _ := cmd.Flags().GetBool("json")
 			limit, _ := cmd.Flags().GetInt32("limit")
 
-			if specsFile == "" {
-				s = specs.NewLuetRDConfig()
-			} else {
-				s, err = specs.LoadSpecsFile(specsFile)
-				if err != nil {
-					fmt.Println("Error on load specs: " + err.Error())
-					os.Exit(1)
-				}
-			}
-
-			opts := make(map[string]string, 0)
-			if backend == "mottainai" {
-				if mottainaiProfile != "" {
-					opts["mottainai-profile"] = mottainaiProfile
-				}
-				if mottainaiMaster != "" {
-					opts["mottainai-master"] = mottainaiMaster
-				}
-				if mottainaiApiKey != "" {
-					opts["mottainai-apikey"] = mottainaiApiKey
-				}
-				if mottainaiNamespace != "" {
-					opts["mottainai-namespace"] = mottainaiNamespace
-				}
-			} else if backend == "minio" {
-
-				if minioEndpoint != "" {
-					opts["minio-endpoint"] = minioEndpoint
-				} else {
-					opts["minio-endpoint"] = os.Getenv("MINIO_URL")
-				}
-
-				if minioBucket != "" {
-					opts["minio-bucket"] = minioBucket
-				} else {
-					opts["minio-bucket"] = os.Getenv("MINIO_BUCKET")
-				}
-
-				if minioAccessId != "" {
-					opts["minio-keyid"] = minioAccessId
-				} else {
-					opts["minio-keyid"] = os.Getenv("MINIO_ID")
-				}
-
-				if minioSecret != "" {
-					opts["minio-secret"] = minioSecret
-				} else {
-					opts["minio-secret"] = os.Getenv("MINIO_SECRET")
-				}
-
-				opts["minio-region"] = minioRegion
-
+			pf, err := newPkgsFilter(filters, filterExprs)
+			if err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
 			}
 
 			repoList, err := devkit.NewRepoList(s, backend, path, opts)
@@ -144,6 +113,8 @@ func NewPkgsCommand() *cobra.Command {
 				os.Exit(1)
 			}
 
+			setupAnalyze(cmd, repoList.RepoKnife)
+
 			// Loading tree in memory
 			err = repoList.LoadTrees(treePath)
 			if err != nil {
@@ -153,6 +124,28 @@ func NewPkgsCommand() *cobra.Command {
 
 			var list []*luet_pkg.DefaultPackage
 
+			if rdeps != "" {
+				list, err := repoList.ListPkgsReverseDeps(rdeps, depth)
+				if err != nil {
+					fmt.Println("Error on retrieve reverse dependencies: " + err.Error())
+					os.Exit(1)
+				}
+
+				printReverseDeps(list, limit, jsonOutput)
+				return
+			}
+
+			if listOutdated {
+				outdated, err := repoList.ListPkgsOutdated(treePath, buildValues)
+				if err != nil {
+					fmt.Println("Error on retrieve outdated pkgs: " + err.Error())
+					os.Exit(1)
+				}
+
+				printOutdated(outdated, pf, limit, jsonOutput)
+				return
+			}
+
 			if listAvailables {
 				list, err = repoList.ListPkgsAvailable()
 				if err != nil {
@@ -161,6 +154,17 @@ func NewPkgsCommand() *cobra.Command {
 				}
 
 			} else if listMissings {
+				if outputFormat != "" {
+					batches, err := repoList.ListPkgsMissingByBatches(treePath, buildOrderWithResolve)
+					if err != nil {
+						fmt.Println("Error on retrieve missings pkgs: " + err.Error())
+						os.Exit(1)
+					}
+
+					printBuildQueue(batches, outputFormat, pf, limit)
+					return
+				}
+
 				if buildOrder {
 					list, err = repoList.ListPkgsMissingByDeps(treePath, buildOrderWithResolve)
 				} else {
@@ -174,38 +178,7 @@ func NewPkgsCommand() *cobra.Command {
 			}
 
 			// Filter packages
-			if len(filters) > 0 {
-				// Create regex
-				listRegex := []*regexp.Regexp{}
-
-				for _, f := range filters {
-					r := regexp.MustCompile(f)
-					if r != nil {
-						listRegex = append(listRegex, r)
-					} else {
-						fmt.Println("WARNING: Regex " + f + " not compiled.")
-					}
-				}
-
-				if len(listRegex) > 0 {
-					filterList := []*luet_pkg.DefaultPackage{}
-					for _, p := range list {
-						toSkip := true
-						for _, r := range listRegex {
-							if r.MatchString(p.GetPackageName()) {
-								toSkip = false
-								break
-							}
-						}
-						if toSkip {
-							continue
-						}
-						filterList = append(filterList, p)
-					}
-
-					list = filterList
-				}
-			}
+			list = pf.Filter(list)
 
 			if limit > 0 {
 				newList := []*luet_pkg.DefaultPackage{}
@@ -248,31 +221,201 @@ func NewPkgsCommand() *cobra.Command {
 	}
 
 	var flags = cmd.Flags()
-	flags.StringP("backend", "b", "local", "Select backend repository: local|mottainai|minio.")
-	flags.StringP("path", "p", "", "Path of the repository artefacts.")
-	flags.String("mottainai-profile", "", "Set mottainai profile to use.")
-	flags.String("mottainai-master", "", "Set mottainai Server to use.")
-	flags.String("mottainai-apikey", "", "Set mottainai API Key to use.")
-	flags.String("mottainai-namespace", "", "Set mottainai namespace to use.")
-	flags.String("minio-bucket", "",
-		"Set minio bucket to use or set env MINIO_BUCKET.")
-	flags.String("minio-endpoint", "",
-		"Set minio endpoint to use or set env MINIO_URL.")
-	flags.String("minio-keyid", "",
-		"Set minio Access Key to use or set env MINIO_ID.")
-	flags.String("minio-secret", "",
-		"Set minio Access Key to use or set env MINIO_SECRET.")
-	flags.String("minio-region", "", "Optinally define the minio region.")
+	addBackendFlags(flags)
+	addAnalyzeFlags(flags)
 	flags.Bool("availables", false, "Show list of available packages.")
 	flags.Bool("missings", false, "Show list of missing packages.")
+	flags.Bool("outdated", false,
+		"Show list of packages with the same version of the tree but a different definition.")
+	flags.String("rdeps", "",
+		"Show the reverse dependencies of the package (category/name) ordered for rebuild.")
+	flags.Int("depth", 0,
+		"Maximum depth of the reverse dependencies. 0 means no limit. To use with --rdeps.")
+	flags.StringArray("values", []string{},
+		"Build values file to use on render the build specs. To use with --outdated.")
 	flags.Bool("build-ordered", false,
 		"Show list of missing packages with a build order. To use with --missings.")
 	flags.Bool("build-ordered-with-resolve", false,
 		"Use stage4 tree resolving. Slow. To use with --build-ordered.")
+	flags.String("output-format", "",
+		"Show the missing packages as build batches: batches|json|makefile|github-matrix. To use with --build-ordered.")
 	flags.Bool("json", false, "Show packages in JSON format.")
 	flags.Int32P("limit", "l", 0, "Limit number of packages returned. 0 means no limit.")
 	flags.StringArrayP("filter", "f", []string{},
 		"Define one or more regex filter to match packages.")
+	flags.StringArray("filter-expr", []string{},
+		`Define one or more filter expressions to match packages.
+Example: 'category =~ "^dev-" and version >= 1.2 and label.arch == "amd64"'`)
 
 	return cmd
 }
+
+var buildQueueFormats = map[string]bool{
+	"batches":       true,
+	"json":          true,
+	"makefile":      true,
+	"github-matrix": true,
+}
+
+// pkgsFilter matches the packages with the regexes of --filter
+// and the expressions of --filter-expr.
+type pkgsFilter struct {
+	Regexes []*regexp.Regexp
+	Exprs   []*filter.Filter
+}
+
+func newPkgsFilter(regexes, exprs []string) (*pkgsFilter, error) {
+	var err error
+	ans := &pkgsFilter{Regexes: []*regexp.Regexp{}}
+
+	for _, f := range regexes {
+		r, err := regexp.Compile(f)
+		if err != nil {
+			return nil, errors.New("Invalid regex " + f + ": " + err.Error())
+		}
+		ans.Regexes = append(ans.Regexes, r)
+	}
+
+	ans.Exprs, err = filter.ParseAll(exprs)
+	if err != nil {
+		return nil, err
+	}
+
+	return ans, nil
+}
+
+// Match returns true if the package matches at least one of the
+// regexes and at least one of the expressions.
+func (f *pkgsFilter) Match(p *luet_pkg.DefaultPackage) bool {
+	if len(f.Regexes) > 0 {
+		match := false
+		for _, r := range f.Regexes {
+			if r.MatchString(p.GetPackageName()) {
+				match = true
+				break
+			}
+		}
+		if !match {
+			return false
+		}
+	}
+
+	if len(f.Exprs) > 0 {
+		match, err := filter.MatchAny(f.Exprs, p)
+		if err != nil {
+			Warning(err.Error())
+		}
+		return match
+	}
+
+	return true
+}
+
+func (f *pkgsFilter) Filter(list []*luet_pkg.DefaultPackage) []*luet_pkg.DefaultPackage {
+	ans := []*luet_pkg.DefaultPackage{}
+	for _, p := range list {
+		if f.Match(p) {
+			ans = append(ans, p)
+		}
+	}
+	return ans
+}
+
+func printBuildQueue(batches []*devkit.BuildBatch, format string, pf *pkgsFilter, limit int32) {
+	n := int32(0)
+	batches = devkit.FilterBuildBatches(batches, func(p *luet_pkg.DefaultPackage) bool {
+		if !pf.Match(p) {
+			return false
+		}
+		n++
+		return limit <= 0 || n <= limit
+	})
+	devkit.SortBuildBatches(batches)
+
+	switch format {
+	case "json":
+		data, err := devkit.BuildBatchesToJson(batches)
+		if err != nil {
+			fmt.Println("Error on generate JSON: " + err.Error())
+			os.Exit(1)
+		}
+		fmt.Println(string(data))
+	case "github-matrix":
+		data, err := devkit.BuildBatchesToGithubMatrix(batches)
+		if err != nil {
+			fmt.Println("Error on generate matrix: " + err.Error())
+			os.Exit(1)
+		}
+		fmt.Println(string(data))
+	case "makefile":
+		fmt.Print(string(devkit.BuildBatchesToMakefile(batches)))
+	default:
+		for _, b := range batches {
+			fmt.Println(fmt.Sprintf("# Batch %d", b.Level))
+			for _, p := range b.Packages {
+				fmt.Println(p.HumanReadableString())
+			}
+		}
+	}
+}
+
+type outdatedPackageOutput struct {
+	*luet_spectooling.DefaultPackageSanitized
+	*devkit.OutdatedPackage
+}
+
+func printOutdated(outdated []*devkit.OutdatedPackage, pf *pkgsFilter, limit int32, jsonOutput bool) {
+	ans := []*devkit.OutdatedPackage{}
+
+	for _, o := range outdated {
+		if !pf.Match(o.Package) {
+			continue
+		}
+		if limit > 0 && int32(len(ans)) >= limit {
+			break
+		}
+		ans = append(ans, o)
+	}
+
+	if jsonOutput {
+		data := []outdatedPackageOutput{}
+		for _, o := range ans {
+			data = append(data, outdatedPackageOutput{
+				DefaultPackageSanitized: luet_spectooling.NewDefaultPackageSanitized(o.Package),
+				OutdatedPackage:         o,
+			})
+		}
+		out, _ := json.Marshal(data)
+		fmt.Println(string(out))
+		return
+	}
+
+	for _, o := range ans {
+		fmt.Println(fmt.Sprintf("%s (%s)",
+			o.Package.HumanReadableString(), strings.Join(o.Reasons, ", ")))
+	}
+}
+
+func printReverseDeps(list []*devkit.ReverseDep, limit int32, jsonOutput bool) {
+	if limit > 0 && int32(len(list)) > limit {
+		list = list[:limit]
+	}
+
+	if jsonOutput {
+		data, _ := json.Marshal(list)
+		fmt.Println(string(data))
+		return
+	}
+
+	for _, r := range list {
+		where := []string{}
+		if r.InTree {
+			where = append(where, "tree")
+		}
+		if r.InRepo {
+			where = append(where, "repo")
+		}
+		fmt.Println(fmt.Sprintf("%d %s (depth %d, %s)",
+			r.Level, r.Package, r.Depth, strings.Join(where, ", ")))
+	}
+}
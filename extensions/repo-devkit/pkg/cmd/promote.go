@@ -0,0 +1,147 @@
+/*
+Copyright (C) 2020-2021  Daniele Rondina <geaaru@sabayonlinux.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+*/
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+
+	devkit "github.com/Luet-lab/extensions/extensions/repo-devkit/pkg/devkit"
+	specs "github.com/Luet-lab/extensions/extensions/repo-devkit/pkg/specs"
+
+	cobra "github.com/spf13/cobra"
+)
+
+func NewPromoteCommand() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "promote [OPTIONS]",
+		Short: "Copy packages from a repository to another.",
+		Long: `Copy packages tarballs and metadata from a repository to another.
+
+The backends are defined with the same syntax of the diff command. For example:
+
+  $ luet-repo-devkit promote \
+      --from backend=local,path=/repo/testing \
+      --to backend=minio,minio-bucket=stable \
+      --package system/luet@>=0.16 --filter '^dev-libs/'`,
+		PreRun: func(cmd *cobra.Command, args []string) {
+			from, _ := cmd.Flags().GetString("from")
+			to, _ := cmd.Flags().GetString("to")
+			filters, _ := cmd.Flags().GetStringArray("filter")
+			packages, _ := cmd.Flags().GetStringArray("package")
+
+			if from == "" || to == "" {
+				fmt.Println("Both --from and --to options are needed.")
+				os.Exit(1)
+			}
+
+			if len(filters) == 0 && len(packages) == 0 {
+				fmt.Println("At least one --filter or --package option is needed.")
+				os.Exit(1)
+			}
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			s := loadSpecs(cmd)
+			from, _ := cmd.Flags().GetString("from")
+			to, _ := cmd.Flags().GetString("to")
+			filters, _ := cmd.Flags().GetStringArray("filter")
+			packages, _ := cmd.Flags().GetStringArray("package")
+			dryRun, _ := cmd.Flags().GetBool("dry-run")
+			force, _ := cmd.Flags().GetBool("force")
+			jsonOutput, _ := cmd.Flags().GetBool("json")
+
+			fromKnife, err := newKnifeFromString(s, from)
+			if err != nil {
+				fmt.Println("Error on initialize source repository: " + err.Error())
+				os.Exit(1)
+			}
+			setupAnalyze(cmd, fromKnife)
+
+			toKnife, err := newKnifeFromString(s, to)
+			if err != nil {
+				fmt.Println("Error on initialize target repository: " + err.Error())
+				os.Exit(1)
+			}
+			setupAnalyze(cmd, toKnife)
+
+			promoter := devkit.NewRepoPromoter(fromKnife, toKnife, dryRun)
+			promoter.Force = force
+
+			for _, f := range filters {
+				r, err := regexp.Compile(f)
+				if err != nil {
+					fmt.Println("Invalid regex " + f + ": " + err.Error())
+					os.Exit(1)
+				}
+				promoter.Filters = append(promoter.Filters, r)
+			}
+
+			for _, p := range packages {
+				lp, err := specs.NewLuetPackageFromString(p)
+				if err != nil {
+					fmt.Println(err.Error())
+					os.Exit(1)
+				}
+				promoter.Packages = append(promoter.Packages, *lp)
+			}
+
+			promoted, err := promoter.Run()
+			if err != nil {
+				fmt.Println("Error on promote packages: " + err.Error())
+				os.Exit(1)
+			}
+
+			if jsonOutput {
+				data, _ := json.Marshal(promoted)
+				fmt.Println(string(data))
+				return
+			}
+
+			n := 0
+			for _, p := range promoted {
+				if !p.Skipped {
+					n++
+				}
+			}
+
+			if dryRun {
+				fmt.Println(fmt.Sprintf("All done. Promotable packages %d.", n))
+			} else {
+				fmt.Println(fmt.Sprintf("All done. Promoted packages %d.", n))
+			}
+		},
+	}
+
+	var flags = cmd.Flags()
+	flags.String("from", "", "Definition of the source backend.")
+	flags.String("to", "", "Definition of the target backend.")
+	flags.StringArrayP("filter", "f", []string{},
+		"Define one or more regex filter to match packages.")
+	flags.StringArray("package", []string{},
+		"Define one or more packages to promote (category/name[@version-selector]).")
+	flags.Bool("dry-run", false, "Only check packages to promote.")
+	flags.Bool("force", false,
+		"Overwrite the packages available in the target repository with a different checksum.")
+	flags.Bool("json", false, "Show promoted packages in JSON format.")
+	addAnalyzeFlags(flags)
+
+	return cmd
+}
@@ -0,0 +1,91 @@
+/*
+Copyright (C) 2020-2021  Daniele Rondina <geaaru@sabayonlinux.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	devkit "github.com/Luet-lab/extensions/extensions/repo-devkit/pkg/devkit"
+
+	cobra "github.com/spf13/cobra"
+)
+
+func NewReindexCommand() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "reindex [OPTIONS]",
+		Short: "Rebuild the repository metadata from the available artefacts.",
+		Long: `Rebuild the repository metadata from the available artefacts.
+
+The repository metadata is regenerated from the metadata files with
+a tarball and the repository.yaml is updated with a new revision.
+The trees files of the repository are not modified.
+
+If one or more trees are supplied the packages not available in
+the trees are excluded from the index.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			s := loadSpecs(cmd)
+			backend, path, opts := mustResolveBackend(cmd, s)
+			treePath, _ := cmd.Flags().GetStringArray("tree")
+			dryRun, _ := cmd.Flags().GetBool("dry-run")
+			quiet, _ := cmd.Flags().GetBool("quiet")
+
+			reindexer, err := devkit.NewRepoReindexer(s, backend, path,
+				opts, dryRun)
+			if err != nil {
+				fmt.Println("Error on initialize repo reindexer: " + err.Error())
+				os.Exit(1)
+			}
+
+			if !quiet {
+				reindexer.Verbose = true
+			}
+
+			setupAnalyze(cmd, reindexer.RepoKnife)
+
+			if len(treePath) > 0 {
+				err = reindexer.LoadTrees(treePath)
+				if err != nil {
+					fmt.Println("Erro on loading trees: " + err.Error())
+					os.Exit(1)
+				}
+				reindexer.FilterByTrees = true
+			}
+
+			repo, err := reindexer.Run()
+			if err != nil {
+				fmt.Println("Error on reindex repository: " + err.Error())
+				os.Exit(1)
+			}
+
+			fmt.Println(fmt.Sprintf(
+				"All done. Repository %s revision %d with %d packages.",
+				repo.GetName(), repo.GetRevision(), len(repo.GetIndex()),
+			))
+		},
+	}
+
+	var flags = cmd.Flags()
+	addBackendFlags(flags)
+	addAnalyzeFlags(flags)
+	flags.Bool("dry-run", false, "Only show the new revision without upload files.")
+	flags.Bool("quiet", false, "Quiet output.")
+
+	return cmd
+}
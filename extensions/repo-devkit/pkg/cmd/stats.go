@@ -0,0 +1,202 @@
+/*
+Copyright (C) 2020-2021  Daniele Rondina <geaaru@sabayonlinux.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+*/
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	devkit "github.com/Luet-lab/extensions/extensions/repo-devkit/pkg/devkit"
+
+	cobra "github.com/spf13/cobra"
+)
+
+func humanSize(size int64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	value := float64(size)
+	sign := ""
+	if value < 0 {
+		sign = "-"
+		value = -value
+	}
+
+	i := 0
+	for ; value >= 1024 && i < len(units)-1; i++ {
+		value /= 1024
+	}
+
+	if i == 0 {
+		return fmt.Sprintf("%s%d %s", sign, int64(value), units[i])
+	}
+	return fmt.Sprintf("%s%.1f %s", sign, value, units[i])
+}
+
+func humanSizeDelta(size int64) string {
+	if size > 0 {
+		return "+" + humanSize(size)
+	}
+	return humanSize(size)
+}
+
+func printStatsReport(report *devkit.StatsReport, top int) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintf(w, "Repository:\t%s (%s)\n", report.Target, report.Backend)
+	fmt.Fprintf(w, "Total size:\t%s\n", humanSize(report.TotalSize))
+	fmt.Fprintf(w, "Total files:\t%d\n", report.TotalFiles)
+	if report.UnknownSizeFiles > 0 {
+		fmt.Fprintf(w, "Files without size:\t%d\n", report.UnknownSizeFiles)
+	}
+	if report.TrashFiles > 0 {
+		fmt.Fprintf(w, "Trash:\t%s (%d files)\n",
+			humanSize(report.TrashSize), report.TrashFiles)
+	}
+	if report.Orphans != nil {
+		fmt.Fprintf(w, "Reclaimable by clean:\t%s (%d files)\n",
+			humanSize(report.Orphans.Size), report.Orphans.Files)
+	}
+	if report.Growth != nil {
+		fmt.Fprintf(w, "Growth since %s:\t%s (%+d files)\n",
+			report.Growth.Since.Format("2006-01-02 15:04:05"),
+			humanSizeDelta(report.Growth.SizeDelta), report.Growth.FilesDelta)
+	}
+
+	fmt.Fprintln(w, "\nCATEGORY\tPACKAGES\tVERSIONS\tFILES\tSIZE")
+	for _, c := range report.Categories {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%s\n", c.Category, c.Packages,
+			c.Versions, c.Files, humanSize(c.Size))
+	}
+
+	fmt.Fprintln(w, "\nLARGEST PACKAGES\tVERSION\tSIZE")
+	for idx, p := range report.Largest {
+		if top > 0 && idx >= top {
+			break
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", p.Package, p.Version, humanSize(p.Size))
+	}
+
+	fmt.Fprintln(w, "\nPACKAGE\tVERSIONS\tSIZE")
+	for idx, p := range report.Packages {
+		if top > 0 && idx >= top {
+			break
+		}
+		fmt.Fprintf(w, "%s\t%d (%s)\t%s\n", p.Package, len(p.Versions),
+			strings.Join(p.Versions, ", "), humanSize(p.Size))
+	}
+
+	if report.Growth != nil && len(report.Growth.Categories) > 0 {
+		fmt.Fprintln(w, "\nCATEGORY\tSIZE DELTA\tVERSIONS DELTA")
+		for _, c := range report.Growth.Categories {
+			fmt.Fprintf(w, "%s\t%s\t%+d\n", c.Category,
+				humanSizeDelta(c.SizeDelta), c.VersionsDelta)
+		}
+	}
+
+	w.Flush()
+}
+
+func NewStatsCommand() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "stats [OPTIONS]",
+		Short: "Show the storage usage of the repository.",
+		Long: `Show the storage usage of the repository.
+
+If one or more trees are supplied the size of the files reclaimable
+by the clean command is reported.
+
+The report could be saved as snapshot with --save-snapshot and
+compared in a next execution with --compare.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			s := loadSpecs(cmd)
+			backend, path, opts := mustResolveBackend(cmd, s)
+			treePath, _ := cmd.Flags().GetStringArray("tree")
+			quiet, _ := cmd.Flags().GetBool("quiet")
+			jsonOutput, _ := cmd.Flags().GetBool("json")
+			top, _ := cmd.Flags().GetInt("top")
+			snapshot, _ := cmd.Flags().GetString("save-snapshot")
+			compare, _ := cmd.Flags().GetString("compare")
+
+			repoStats, err := devkit.NewRepoStats(s, backend, path, opts)
+			if err != nil {
+				fmt.Println("Error on initialize repo stats: " + err.Error())
+				os.Exit(1)
+			}
+
+			if !quiet && !jsonOutput {
+				repoStats.Verbose = true
+			}
+
+			setupAnalyze(cmd, repoStats.RepoKnife)
+
+			if len(treePath) > 0 {
+				err = repoStats.LoadTrees(treePath)
+				if err != nil {
+					fmt.Println("Erro on loading trees: " + err.Error())
+					os.Exit(1)
+				}
+				repoStats.CheckOrphans = true
+			}
+
+			report, err := repoStats.Run()
+			if err != nil {
+				fmt.Println("Error on retrieve repository stats: " + err.Error())
+				os.Exit(1)
+			}
+
+			if compare != "" {
+				prev, err := devkit.LoadStatsReport(compare)
+				if err != nil {
+					fmt.Println("Error on load snapshot: " + err.Error())
+					os.Exit(1)
+				}
+				report.Compare(prev)
+			}
+
+			if snapshot != "" {
+				err = report.Write(snapshot)
+				if err != nil {
+					fmt.Println("Error on write snapshot: " + err.Error())
+					os.Exit(1)
+				}
+			}
+
+			if jsonOutput {
+				data, _ := json.Marshal(report)
+				fmt.Println(string(data))
+			} else {
+				printStatsReport(report, top)
+			}
+		},
+	}
+
+	var flags = cmd.Flags()
+	addBackendFlags(flags)
+	addAnalyzeFlags(flags)
+	flags.Bool("quiet", false, "Quiet output.")
+	flags.Bool("json", false, "Show the report in JSON format.")
+	flags.Int("top", 10,
+		"Number of packages to show in the tables. 0 means all packages.")
+	flags.String("save-snapshot", "", "Save the report as snapshot in the file.")
+	flags.String("compare", "", "Compare the report with a previous snapshot.")
+
+	return cmd
+}
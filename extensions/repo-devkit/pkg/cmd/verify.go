@@ -0,0 +1,111 @@
+/*
+Copyright (C) 2020-2021  Daniele Rondina <geaaru@sabayonlinux.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+*/
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	devkit "github.com/Luet-lab/extensions/extensions/repo-devkit/pkg/devkit"
+
+	cobra "github.com/spf13/cobra"
+)
+
+const (
+	// Exit code used when the repository isn't consistent.
+	verifyExitInconsistent = 2
+)
+
+func NewVerifyCommand() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "verify [OPTIONS]",
+		Short: "Verify the consistency of the repository files.",
+		Long: `Verify the consistency of the repository files.
+
+Exit status is 0 when the repository is consistent, 1 on error and
+2 when orphans, corrupted tarballs or index mismatches are found.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			s := loadSpecs(cmd)
+			backend, path, opts := mustResolveBackend(cmd, s)
+			treePath, _ := cmd.Flags().GetStringArray("tree")
+			quiet, _ := cmd.Flags().GetBool("quiet")
+			skipTarballs, _ := cmd.Flags().GetBool("skip-tarballs")
+			jsonOutput, _ := cmd.Flags().GetBool("json")
+
+			repoVerifier, err := devkit.NewRepoVerifier(s, backend, path,
+				opts, !skipTarballs)
+			if err != nil {
+				fmt.Println("Error on initialize repo verifier: " + err.Error())
+				os.Exit(1)
+			}
+
+			if !quiet && !jsonOutput {
+				repoVerifier.Verbose = true
+			}
+
+			setupAnalyze(cmd, repoVerifier.RepoKnife)
+
+			err = repoVerifier.LoadTrees(treePath)
+			if err != nil {
+				fmt.Println("Erro on loading trees: " + err.Error())
+				os.Exit(1)
+			}
+
+			report, err := repoVerifier.Run()
+			if err != nil {
+				fmt.Println("Error on verify repository: " + err.Error())
+				os.Exit(1)
+			}
+
+			if jsonOutput {
+				data, _ := json.Marshal(report)
+				fmt.Println(string(data))
+			} else {
+				for _, issues := range [][]devkit.VerifyIssue{
+					report.Orphans, report.Corrupted, report.IndexMismatches,
+				} {
+					for _, i := range issues {
+						fmt.Println(fmt.Sprintf("[%s] %s: %s", i.Type, i.File, i.Message))
+					}
+				}
+
+				fmt.Println(fmt.Sprintf(
+					"All done. Processed file %d. Packages %d. Orphans %d. Corrupted %d. Index mismatches %d.",
+					report.ProcessedFiles, report.Packages, len(report.Orphans),
+					len(report.Corrupted), len(report.IndexMismatches),
+				))
+			}
+
+			if !report.IsConsistent() {
+				os.Exit(verifyExitInconsistent)
+			}
+		},
+	}
+
+	var flags = cmd.Flags()
+	addBackendFlags(flags)
+	addAnalyzeFlags(flags)
+	flags.Bool("quiet", false, "Quiet output.")
+	flags.Bool("skip-tarballs", false,
+		"Skip the download of the tarballs and the check of their checksum.")
+	flags.Bool("json", false, "Show the report in JSON format.")
+
+	return cmd
+}
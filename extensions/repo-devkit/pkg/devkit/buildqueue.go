@@ -0,0 +1,178 @@
+/*
+Copyright (C) 2020-2021  Daniele Rondina <geaaru@sabayonlinux.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+*/
+
+package devkit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	luet_pkg "github.com/mudler/luet/pkg/package"
+	luet_spectooling "github.com/mudler/luet/pkg/spectooling"
+)
+
+// BuildBatch contains the packages that could be built in
+// parallel once the packages of the previous batches are built.
+type BuildBatch struct {
+	Level    int                        `json:"level"`
+	Packages []*luet_pkg.DefaultPackage `json:"packages"`
+}
+
+type buildBatchSanitized struct {
+	Level    int                                         `json:"level"`
+	Packages []*luet_spectooling.DefaultPackageSanitized `json:"packages"`
+}
+
+type githubMatrixEntry struct {
+	Package  string `json:"package"`
+	Category string `json:"category"`
+	Name     string `json:"name"`
+	Version  string `json:"version"`
+}
+
+type githubMatrix struct {
+	Include []githubMatrixEntry `json:"include"`
+}
+
+var makeTargetRegex = regexp.MustCompile("[^a-zA-Z0-9_.+-]")
+
+// SortBuildBatches sorts the packages of every batch by name
+// to have a reproducible output.
+func SortBuildBatches(batches []*BuildBatch) {
+	for _, b := range batches {
+		sort.Slice(b.Packages, func(i, j int) bool {
+			return b.Packages[i].HumanReadableString() < b.Packages[j].HumanReadableString()
+		})
+	}
+}
+
+// FilterBuildBatches returns the batches with only the packages
+// accepted by the filter function. Empty batches are dropped and
+// the levels are renumbered.
+func FilterBuildBatches(batches []*BuildBatch, f func(p *luet_pkg.DefaultPackage) bool) []*BuildBatch {
+	ans := []*BuildBatch{}
+
+	for _, b := range batches {
+		batch := &BuildBatch{
+			Level:    len(ans) + 1,
+			Packages: []*luet_pkg.DefaultPackage{},
+		}
+		for _, p := range b.Packages {
+			if f(p) {
+				batch.Packages = append(batch.Packages, p)
+			}
+		}
+		if len(batch.Packages) > 0 {
+			ans = append(ans, batch)
+		}
+	}
+
+	return ans
+}
+
+// BuildBatchesToJson returns the batches in JSON format.
+func BuildBatchesToJson(batches []*BuildBatch) ([]byte, error) {
+	data := []buildBatchSanitized{}
+
+	for _, b := range batches {
+		sb := buildBatchSanitized{
+			Level:    b.Level,
+			Packages: []*luet_spectooling.DefaultPackageSanitized{},
+		}
+		for _, p := range b.Packages {
+			sb.Packages = append(sb.Packages, luet_spectooling.NewDefaultPackageSanitized(p))
+		}
+		data = append(data, sb)
+	}
+
+	return json.Marshal(data)
+}
+
+// BuildBatchesToGithubMatrix returns a JSON array with a GitHub Actions
+// matrix for every batch. Every batch could be used as the matrix of a
+// job that depends on the job of the previous batch with:
+//
+//	strategy:
+//	  matrix: ${{ fromJson(needs.queue.outputs.batches)[0] }}
+func BuildBatchesToGithubMatrix(batches []*BuildBatch) ([]byte, error) {
+	data := []githubMatrix{}
+
+	for _, b := range batches {
+		m := githubMatrix{Include: []githubMatrixEntry{}}
+		for _, p := range b.Packages {
+			m.Include = append(m.Include, githubMatrixEntry{
+				Package:  fmt.Sprintf("%s/%s", p.GetCategory(), p.GetName()),
+				Category: p.GetCategory(),
+				Name:     p.GetName(),
+				Version:  p.GetVersion(),
+			})
+		}
+		data = append(data, m)
+	}
+
+	return json.Marshal(data)
+}
+
+// BuildBatchesToMakefile returns a Makefile with a target for every
+// package that depends on the batch target of the previous level.
+// The packages could be built in parallel with make -j.
+func BuildBatchesToMakefile(batches []*BuildBatch) []byte {
+	var buf bytes.Buffer
+	phony := []string{"all"}
+
+	buf.WriteString("# Generated by luet-repo-devkit.\n")
+	buf.WriteString("LUET_BUILD ?= luet build\n\n")
+
+	if len(batches) > 0 {
+		buf.WriteString(fmt.Sprintf("all: batch-%d\n\n", batches[len(batches)-1].Level))
+	} else {
+		buf.WriteString("all:\n\n")
+	}
+
+	for idx, b := range batches {
+		targets := []string{}
+
+		for _, p := range b.Packages {
+			pkgstr := fmt.Sprintf("%s/%s", p.GetCategory(), p.GetName())
+			target := "build-" + makeTargetRegex.ReplaceAllString(
+				strings.ReplaceAll(pkgstr, "/", "_"), "_")
+			targets = append(targets, target)
+
+			if idx > 0 {
+				buf.WriteString(fmt.Sprintf("%s: batch-%d\n", target, batches[idx-1].Level))
+			} else {
+				buf.WriteString(fmt.Sprintf("%s:\n", target))
+			}
+			buf.WriteString(fmt.Sprintf("\t$(LUET_BUILD) \"%s\"\n\n",
+				fmt.Sprintf("%s@%s", pkgstr, p.GetVersion())))
+		}
+
+		buf.WriteString(fmt.Sprintf("batch-%d: %s\n\n", b.Level, strings.Join(targets, " ")))
+
+		phony = append(phony, fmt.Sprintf("batch-%d", b.Level))
+		phony = append(phony, targets...)
+	}
+
+	buf.WriteString(fmt.Sprintf(".PHONY: %s\n", strings.Join(phony, " ")))
+
+	return buf.Bytes()
+}
@@ -0,0 +1,190 @@
+/*
+Copyright (C) 2020-2021  Daniele Rondina <geaaru@sabayonlinux.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+*/
+
+package devkit_test
+
+import (
+	"encoding/json"
+
+	. "github.com/Luet-lab/extensions/extensions/repo-devkit/pkg/devkit"
+	luet_pkg "github.com/mudler/luet/pkg/package"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+func newBatchPackage(category, name, version string) *luet_pkg.DefaultPackage {
+	return &luet_pkg.DefaultPackage{Category: category, Name: name, Version: version}
+}
+
+// testBuildBatches returns two batches, the second depends on
+// the first.
+func testBuildBatches() []*BuildBatch {
+	return []*BuildBatch{
+		{Level: 1, Packages: []*luet_pkg.DefaultPackage{
+			newBatchPackage("sys-libs", "zlib", "1.2.11"),
+			newBatchPackage("dev-libs", "openssl", "1.1.1k"),
+		}},
+		{Level: 2, Packages: []*luet_pkg.DefaultPackage{
+			newBatchPackage("net-misc", "curl", "7.77.0"),
+		}},
+	}
+}
+
+var _ = Describe("Build batches", func() {
+
+	It("Sorts the packages", func() {
+		batches := testBuildBatches()
+		SortBuildBatches(batches)
+		Expect(batches[0].Packages[0].GetName()).To(Equal("openssl"))
+		Expect(batches[0].Packages[1].GetName()).To(Equal("zlib"))
+	})
+
+	DescribeTable("Filters the packages",
+		func(names []string, expected map[int][]string) {
+			keep := map[string]bool{}
+			for _, n := range names {
+				keep[n] = true
+			}
+
+			batches := FilterBuildBatches(testBuildBatches(), func(p *luet_pkg.DefaultPackage) bool {
+				return keep[p.GetName()]
+			})
+
+			got := map[int][]string{}
+			for _, b := range batches {
+				for _, p := range b.Packages {
+					got[b.Level] = append(got[b.Level], p.GetName())
+				}
+			}
+			Expect(got).To(Equal(expected))
+		},
+		Entry("all the packages", []string{"zlib", "openssl", "curl"},
+			map[int][]string{1: {"zlib", "openssl"}, 2: {"curl"}}),
+		Entry("empty first batch renumbered", []string{"curl"},
+			map[int][]string{1: {"curl"}}),
+		Entry("empty last batch dropped", []string{"openssl"},
+			map[int][]string{1: {"openssl"}}),
+		Entry("no packages", []string{},
+			map[int][]string{}),
+	)
+
+	DescribeTable("Renders the batches in JSON format",
+		func(batches []*BuildBatch, expected string) {
+			SortBuildBatches(batches)
+
+			data, err := BuildBatchesToJson(batches)
+			Expect(err).ToNot(HaveOccurred())
+
+			got := []struct {
+				Level    int `json:"level"`
+				Packages []struct {
+					Category string `json:"category"`
+					Name     string `json:"name"`
+					Version  string `json:"version"`
+				} `json:"packages"`
+			}{}
+			Expect(json.Unmarshal(data, &got)).To(Succeed())
+
+			out, err := json.Marshal(got)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(out)).To(MatchJSON(expected))
+		},
+		Entry("no batches", []*BuildBatch{}, `[]`),
+		Entry("two batches", testBuildBatches(), `[
+  {"level": 1, "packages": [
+    {"category": "dev-libs", "name": "openssl", "version": "1.1.1k"},
+    {"category": "sys-libs", "name": "zlib", "version": "1.2.11"}
+  ]},
+  {"level": 2, "packages": [
+    {"category": "net-misc", "name": "curl", "version": "7.77.0"}
+  ]}
+]`),
+	)
+
+	DescribeTable("Renders the batches as GitHub matrix",
+		func(batches []*BuildBatch, expected string) {
+			SortBuildBatches(batches)
+
+			data, err := BuildBatchesToGithubMatrix(batches)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(data)).To(MatchJSON(expected))
+		},
+		Entry("no batches", []*BuildBatch{}, `[]`),
+		Entry("two batches", testBuildBatches(), `[
+  {"include": [
+    {"package": "dev-libs/openssl", "category": "dev-libs", "name": "openssl", "version": "1.1.1k"},
+    {"package": "sys-libs/zlib", "category": "sys-libs", "name": "zlib", "version": "1.2.11"}
+  ]},
+  {"include": [
+    {"package": "net-misc/curl", "category": "net-misc", "name": "curl", "version": "7.77.0"}
+  ]}
+]`),
+	)
+
+	DescribeTable("Renders the batches as Makefile",
+		func(batches []*BuildBatch, expected string) {
+			SortBuildBatches(batches)
+			Expect(string(BuildBatchesToMakefile(batches))).To(Equal(expected))
+		},
+		Entry("no batches", []*BuildBatch{}, `# Generated by luet-repo-devkit.
+LUET_BUILD ?= luet build
+
+all:
+
+.PHONY: all
+`),
+		Entry("two batches", testBuildBatches(), `# Generated by luet-repo-devkit.
+LUET_BUILD ?= luet build
+
+all: batch-2
+
+build-dev-libs_openssl:
+	$(LUET_BUILD) "dev-libs/openssl@1.1.1k"
+
+build-sys-libs_zlib:
+	$(LUET_BUILD) "sys-libs/zlib@1.2.11"
+
+batch-1: build-dev-libs_openssl build-sys-libs_zlib
+
+build-net-misc_curl: batch-1
+	$(LUET_BUILD) "net-misc/curl@7.77.0"
+
+batch-2: build-net-misc_curl
+
+.PHONY: all batch-1 build-dev-libs_openssl build-sys-libs_zlib batch-2 build-net-misc_curl
+`),
+		Entry("invalid target characters", []*BuildBatch{
+			{Level: 1, Packages: []*luet_pkg.DefaultPackage{
+				newBatchPackage("dev-cpp", "gtk+:3", "3.24"),
+			}},
+		}, `# Generated by luet-repo-devkit.
+LUET_BUILD ?= luet build
+
+all: batch-1
+
+build-dev-cpp_gtk+_3:
+	$(LUET_BUILD) "dev-cpp/gtk+:3@3.24"
+
+batch-1: build-dev-cpp_gtk+_3
+
+.PHONY: all batch-1 build-dev-cpp_gtk+_3
+`),
+	)
+})
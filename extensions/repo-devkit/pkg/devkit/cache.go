@@ -0,0 +1,139 @@
+/*
+Copyright (C) 2020-2021  Daniele Rondina <geaaru@sabayonlinux.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+*/
+
+package devkit
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	artifact "github.com/mudler/luet/pkg/compiler/types/artifact"
+	. "github.com/mudler/luet/pkg/logger"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	metadataCacheIndex = "index.json"
+)
+
+// MetadataCache stores the metadata of the artefacts retrieved by
+// the backend. Every entry is valid until the cache key of the
+// file (ETag or size/mtime) is the same.
+type MetadataCache struct {
+	Dir     string
+	Entries map[string]string
+
+	mutex sync.Mutex
+}
+
+func NewMetadataCache(dir string) (*MetadataCache, error) {
+	err := os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+
+	ans := &MetadataCache{
+		Dir:     dir,
+		Entries: make(map[string]string, 0),
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, metadataCacheIndex))
+	if err == nil {
+		if err := json.Unmarshal(content, &ans.Entries); err != nil {
+			Warning(fmt.Sprintf("Invalid cache index on %s. I reset it.", dir))
+			ans.Entries = make(map[string]string, 0)
+		}
+	}
+
+	return ans, nil
+}
+
+func (c *MetadataCache) entryPath(file string) string {
+	return filepath.Join(c.Dir,
+		fmt.Sprintf("%x.yaml", sha256.Sum256([]byte(file))))
+}
+
+// Get returns the cached metadata of the file if the key matches.
+func (c *MetadataCache) Get(file, key string) *artifact.PackageArtifact {
+	c.mutex.Lock()
+	k, ok := c.Entries[file]
+	c.mutex.Unlock()
+
+	if !ok || k != key {
+		return nil
+	}
+
+	content, err := ioutil.ReadFile(c.entryPath(file))
+	if err != nil {
+		return nil
+	}
+
+	art, err := artifact.NewPackageArtifactFromYaml(content)
+	if err != nil {
+		return nil
+	}
+
+	return art
+}
+
+func (c *MetadataCache) Set(file, key string, art *artifact.PackageArtifact) error {
+	data, err := yaml.Marshal(art)
+	if err != nil {
+		return err
+	}
+
+	err = ioutil.WriteFile(c.entryPath(file), data, 0644)
+	if err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	c.Entries[file] = key
+	c.mutex.Unlock()
+
+	return nil
+}
+
+// Prune drops the entries of the files not more available.
+func (c *MetadataCache) Prune(files map[string]bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for f := range c.Entries {
+		if _, ok := files[f]; !ok {
+			os.Remove(c.entryPath(f))
+			delete(c.Entries, f)
+		}
+	}
+}
+
+func (c *MetadataCache) Write() error {
+	c.mutex.Lock()
+	data, err := json.Marshal(c.Entries)
+	c.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(c.Dir, metadataCacheIndex), data, 0644)
+}
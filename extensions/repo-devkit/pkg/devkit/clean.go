@@ -19,7 +19,13 @@ along with this program. If not, see <http://www.gnu.org/licenses/>.
 package devkit
 
 import (
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
 
 	specs "github.com/Luet-lab/extensions/extensions/repo-devkit/pkg/specs"
 
@@ -29,6 +35,14 @@ import (
 type RepoCleaner struct {
 	*RepoKnife
 	DryRun bool
+
+	// Move the files to the trash area instead of removing them.
+	Trash bool
+	// JournalFile is the path of the journal. If it's empty the
+	// journal is written in JournalDir with the default name.
+	JournalFile string
+	JournalDir  string
+	Journal     *CleanJournal
 }
 
 func NewRepoCleaner(s *specs.LuetRDConfig,
@@ -51,23 +65,241 @@ func NewRepoCleaner(s *specs.LuetRDConfig,
 func (c *RepoCleaner) Run() error {
 
 	err := c.RepoKnife.Analyze()
+	if err != nil {
+		return err
+	}
+
+	if len(c.Files2Remove) == 0 {
+		InfoC("No files to remove.")
+		return nil
+	}
+
+	if c.Trash {
+		return c.moveToTrash()
+	}
+
+	for _, f := range c.Files2Remove {
+		if c.DryRun {
+			InfoC(fmt.Sprintf("[%s] Could be removed.", f))
+		} else {
+			err = c.BackendHandler.CleanFile(f)
+			if err != nil {
+				Error(fmt.Sprintf("[%s] Error on removing file: %s", f, err.Error()))
+			} else {
+				InfoC(fmt.Sprintf("[%s] Removed.", f))
+			}
+		}
+	}
+
+	return nil
+}
+
+func (c *RepoCleaner) moveToTrash() error {
+	if c.JournalFile == "" && c.JournalDir == "" {
+		return errors.New("Invalid journal file")
+	}
 
-	if len(c.Files2Remove) > 0 {
+	c.Journal = NewCleanJournal(c.Backend, c.Target,
+		c.Specs.GetCleaner().GetTrashDir(), c.JournalFile)
+	if c.JournalFile == "" {
+		c.JournalFile = GetCleanJournalFile(c.JournalDir, c.Journal.Id)
+		c.Journal.SetFile(c.JournalFile)
+	}
+
+	if c.DryRun {
 		for _, f := range c.Files2Remove {
+			InfoC(fmt.Sprintf("[%s] Could be moved to %s.", f,
+				path.Join(c.Journal.TrashDir, f)))
+		}
+		return nil
+	}
+
+	err := c.Journal.Create()
+	if err != nil {
+		return errors.New("Error on create journal: " + err.Error())
+	}
+
+	for _, f := range c.Files2Remove {
+		dst := path.Join(c.Journal.TrashDir, f)
+
+		// The entry is written before the move to restore the file
+		// also if the clean is interrupted.
+		err = c.Journal.AddEntry(f, dst)
+		if err != nil {
+			return c.abort(f, errors.New("Error on update journal: "+err.Error()))
+		}
+
+		err = c.BackendHandler.MoveFile(f, dst)
+		if err != nil {
+			Error(fmt.Sprintf("[%s] Error on moving file to trash: %s", f, err.Error()))
+			return c.abort(f, err)
+		}
+
+		err = c.Journal.SetLastEntryMoved()
+		if err != nil {
+			return c.abort(f, errors.New("Error on update journal: "+err.Error()))
+		}
+
+		InfoC(fmt.Sprintf("[%s] Moved to %s.", f, dst))
+	}
+
+	return c.Journal.SetState(JournalStateCompleted)
+}
+
+// abort rolls back the files moved to the trash area after the
+// error on the file f.
+func (c *RepoCleaner) abort(f string, err error) error {
+	rerr := c.rollback()
+	if rerr != nil {
+		return errors.New(fmt.Sprintf(
+			"Error on moving file %s: %s. Rollback failed: %s. Check journal %s.",
+			f, err.Error(), rerr.Error(), c.JournalFile))
+	}
+	return errors.New(fmt.Sprintf(
+		"Error on moving file %s: %s. Rollback done.", f, err.Error()))
+}
+
+func (c *RepoCleaner) rollback() error {
+	err := c.restoreEntries(c.Journal)
+	if err != nil {
+		return err
+	}
+	return c.Journal.SetState(JournalStateRolledBack)
+}
+
+func (c *RepoCleaner) restoreEntries(j *CleanJournal) error {
+	for i := len(j.Entries) - 1; i >= 0; i-- {
+		e := j.Entries[i]
+		err := c.BackendHandler.MoveFile(e.Destination, e.Source)
+		if err != nil && !e.Moved {
+			// The clean failed or was interrupted before or
+			// during the move of the file.
+			DebugC(fmt.Sprintf("[%s] Not moved to trash: %s", e.Source, err.Error()))
+			continue
+		}
+		if err != nil {
+			return errors.New(fmt.Sprintf("Error on restore file %s: %s",
+				e.Source, err.Error()))
+		}
+		InfoC(fmt.Sprintf("[%s] Restored.", e.Source))
+	}
+	return nil
+}
+
+func (c *RepoCleaner) checkJournal(j *CleanJournal) error {
+	if j.Backend != c.Backend || j.Target != c.Target {
+		return errors.New(fmt.Sprintf(
+			"Journal %s is related to backend %s (%s)",
+			j.GetFile(), j.Backend, j.Target))
+	}
+	return nil
+}
+
+// Undo restores the files moved in the trash area by the journal.
+func (c *RepoCleaner) Undo(journalFile string) error {
+	j, err := LoadCleanJournal(journalFile)
+	if err != nil {
+		return err
+	}
+
+	err = c.checkJournal(j)
+	if err != nil {
+		return err
+	}
+
+	if j.State != JournalStateCompleted && j.State != JournalStateRunning {
+		return errors.New(fmt.Sprintf(
+			"Journal %s is in state %s. Nothing to restore.",
+			journalFile, j.State))
+	}
+
+	if c.DryRun {
+		for _, e := range j.Entries {
+			InfoC(fmt.Sprintf("[%s] Could be restored.", e.Source))
+		}
+		return nil
+	}
+
+	err = c.restoreEntries(j)
+	if err != nil {
+		return err
+	}
+
+	return j.SetState(JournalStateRestored)
+}
+
+// Purge removes definitively the files moved in the trash area by
+// the journals of the directory journalDir older than the duration.
+func (c *RepoCleaner) Purge(journalDir string, olderThan time.Duration) (int, error) {
+	purged := 0
+
+	files, err := ioutil.ReadDir(journalDir)
+	if err != nil {
+		return purged, err
+	}
+
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+
+		j, err := LoadCleanJournal(filepath.Join(journalDir, f.Name()))
+		if err != nil {
+			DebugC(fmt.Sprintf("Ignoring file %s: %s", f.Name(), err.Error()))
+			continue
+		}
+
+		// A partially purged journal is purged again.
+		if c.checkJournal(j) != nil ||
+			(j.State != JournalStateCompleted && j.State != JournalStatePartiallyPurged) {
+			DebugC(fmt.Sprintf("Ignoring journal %s.", f.Name()))
+			continue
+		}
+
+		if time.Since(j.CreationTime) < olderThan {
+			DebugC(fmt.Sprintf("Journal %s is too recent.", f.Name()))
+			continue
+		}
+
+		for i, e := range j.Entries {
+			if !e.Moved || e.Purged {
+				continue
+			}
+
 			if c.DryRun {
-				InfoC(fmt.Sprintf("[%s] Could be removed.", f))
-			} else {
-				err = c.BackendHandler.CleanFile(f)
-				if err != nil {
-					Error(fmt.Sprintf("[%s] Error on removing file: %s", f, err.Error()))
-				} else {
-					InfoC(fmt.Sprintf("[%s] Removed.", f))
+				InfoC(fmt.Sprintf("[%s] Could be purged.", e.Destination))
+				continue
+			}
+
+			err = c.BackendHandler.CleanFile(e.Destination)
+			if err != nil {
+				// The journal can't be restored because some
+				// files could be already removed.
+				if serr := j.SetState(JournalStatePartiallyPurged); serr != nil {
+					Error(fmt.Sprintf("Error on update journal %s: %s",
+						f.Name(), serr.Error()))
 				}
+				return purged, errors.New(fmt.Sprintf(
+					"Error on purge file %s of the journal %s: %s",
+					e.Destination, f.Name(), err.Error()))
+			}
+
+			err = j.SetEntryPurged(i)
+			if err != nil {
+				return purged, errors.New(fmt.Sprintf(
+					"Error on update journal %s: %s", f.Name(), err.Error()))
 			}
+			InfoC(fmt.Sprintf("[%s] Purged.", e.Destination))
 		}
-	} else {
-		InfoC("No files to remove.")
+
+		if !c.DryRun {
+			err = j.SetState(JournalStatePurged)
+			if err != nil {
+				return purged, err
+			}
+		}
+		purged++
 	}
 
-	return nil
+	return purged, nil
 }
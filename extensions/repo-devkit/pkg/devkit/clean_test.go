@@ -0,0 +1,323 @@
+/*
+Copyright (C) 2020-2021  Daniele Rondina <geaaru@sabayonlinux.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+*/
+
+package devkit_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	. "github.com/Luet-lab/extensions/extensions/repo-devkit/pkg/devkit"
+	specs "github.com/Luet-lab/extensions/extensions/repo-devkit/pkg/specs"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const orphanMetadata = `path: /repo/foo-app-1.0.package.tar
+compilespec:
+  package:
+    name: foo
+    version: "1.0"
+    category: app
+compressiontype: none
+`
+
+// failingBackend fails the move of the file fail to the trash area
+// and the remove of the file failClean.
+type failingBackend struct {
+	specs.RepoBackendHandler
+	fail      string
+	failClean string
+}
+
+func (b *failingBackend) MoveFile(src, dst string) error {
+	if src == b.fail {
+		return errors.New("move failed")
+	}
+	return b.RepoBackendHandler.MoveFile(src, dst)
+}
+
+func (b *failingBackend) CleanFile(f string) error {
+	if f == b.failClean {
+		return errors.New("remove failed")
+	}
+	return b.RepoBackendHandler.CleanFile(f)
+}
+
+var _ = Describe("Clean", func() {
+	var repoDir, journalDir, journalFile string
+
+	writeFile := func(name, content string) {
+		f := filepath.Join(repoDir, filepath.FromSlash(name))
+		Expect(os.MkdirAll(filepath.Dir(f), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(f, []byte(content), 0644)).To(Succeed())
+	}
+
+	fileExists := func(name string) bool {
+		_, err := os.Stat(filepath.Join(repoDir, filepath.FromSlash(name)))
+		return err == nil
+	}
+
+	newCleaner := func() *RepoCleaner {
+		c, err := NewRepoCleaner(specs.NewLuetRDConfig(), "local", repoDir,
+			map[string]string{}, false)
+		Expect(err).ToNot(HaveOccurred())
+		c.Trash = true
+		c.JournalFile = journalFile
+		return c
+	}
+
+	BeforeEach(func() {
+		var err error
+		repoDir, err = ioutil.TempDir("", "repo-devkit-clean")
+		Expect(err).ToNot(HaveOccurred())
+		journalDir, err = ioutil.TempDir("", "repo-devkit-journal")
+		Expect(err).ToNot(HaveOccurred())
+		journalFile = filepath.Join(journalDir, "clean.json")
+
+		// The metadata file is selected twice because the tarball
+		// is missing and the package isn't in the tree.
+		writeFile("foo-app-1.0.metadata.yaml", orphanMetadata)
+		writeFile("bar-app-1.0.package.tar", "tarball without metadata")
+		writeFile("stale.txt", "unknown file")
+		writeFile("repository.yaml", "name: test")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(repoDir)
+		os.RemoveAll(journalDir)
+	})
+
+	Context("Journal", func() {
+
+		It("Uses a different trash directory for every clean", func() {
+			j1 := NewCleanJournal("local", repoDir, ".trash", journalFile)
+			j2 := NewCleanJournal("local", repoDir, ".trash", journalFile)
+			Expect(j1.Id).ToNot(Equal(j2.Id))
+			Expect(j1.TrashDir).ToNot(Equal(j2.TrashDir))
+			Expect(j1.TrashDir).To(Equal(".trash/" + j1.Id))
+		})
+
+		It("Writes the journal with the default name", func() {
+			c := newCleaner()
+			c.JournalFile = ""
+			c.JournalDir = journalDir
+			Expect(c.Run()).To(Succeed())
+
+			Expect(c.JournalFile).To(Equal(
+				GetCleanJournalFile(journalDir, c.Journal.Id)))
+			j, err := LoadCleanJournal(c.JournalFile)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(j.State).To(Equal(JournalStateCompleted))
+		})
+
+		It("Refuses to overwrite an existing journal", func() {
+			Expect(ioutil.WriteFile(journalFile, []byte("previous"), 0644)).To(Succeed())
+
+			Expect(newCleaner().Run()).ToNot(Succeed())
+
+			data, err := ioutil.ReadFile(journalFile)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(data)).To(Equal("previous"))
+			Expect(fileExists("stale.txt")).To(BeTrue())
+		})
+	})
+
+	Context("Trash mode", func() {
+
+		It("Moves every file once to the trash area", func() {
+			c := newCleaner()
+			Expect(c.Run()).To(Succeed())
+
+			Expect(c.Files2Remove).To(ConsistOf(
+				"foo-app-1.0.metadata.yaml",
+				"bar-app-1.0.package.tar",
+				"stale.txt",
+			))
+
+			j, err := LoadCleanJournal(journalFile)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(j.State).To(Equal(JournalStateCompleted))
+			Expect(j.Entries).To(HaveLen(3))
+			for _, e := range j.Entries {
+				Expect(e.Moved).To(BeTrue())
+				Expect(fileExists(e.Source)).To(BeFalse())
+				Expect(fileExists(e.Destination)).To(BeTrue())
+			}
+			Expect(fileExists("repository.yaml")).To(BeTrue())
+		})
+
+		It("Rolls back the moved files on errors", func() {
+			c := newCleaner()
+			c.BackendHandler = &failingBackend{
+				RepoBackendHandler: c.BackendHandler,
+				fail:               "stale.txt",
+			}
+
+			err := c.Run()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Rollback done"))
+
+			Expect(fileExists("foo-app-1.0.metadata.yaml")).To(BeTrue())
+			Expect(fileExists("bar-app-1.0.package.tar")).To(BeTrue())
+			Expect(fileExists("stale.txt")).To(BeTrue())
+
+			j, err := LoadCleanJournal(journalFile)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(j.State).To(Equal(JournalStateRolledBack))
+			last := j.Entries[len(j.Entries)-1]
+			Expect(last.Source).To(Equal("stale.txt"))
+			Expect(last.Moved).To(BeFalse())
+		})
+
+		It("Doesn't move files in dry run mode", func() {
+			c := newCleaner()
+			c.DryRun = true
+			Expect(c.Run()).To(Succeed())
+
+			Expect(fileExists("stale.txt")).To(BeTrue())
+			_, err := os.Stat(journalFile)
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+	})
+
+	Context("Undo", func() {
+
+		It("Restores the files of the journal", func() {
+			Expect(newCleaner().Run()).To(Succeed())
+			Expect(fileExists("stale.txt")).To(BeFalse())
+
+			Expect(newCleaner().Undo(journalFile)).To(Succeed())
+
+			Expect(fileExists("foo-app-1.0.metadata.yaml")).To(BeTrue())
+			Expect(fileExists("bar-app-1.0.package.tar")).To(BeTrue())
+			Expect(fileExists("stale.txt")).To(BeTrue())
+
+			j, err := LoadCleanJournal(journalFile)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(j.State).To(Equal(JournalStateRestored))
+		})
+
+		It("Skips the entries not moved of an interrupted clean", func() {
+			c := newCleaner()
+			j := NewCleanJournal(c.Backend, c.Target, ".trash", journalFile)
+			moved := path.Join(j.TrashDir, "stale.txt")
+			Expect(c.BackendHandler.MoveFile("stale.txt", moved)).To(Succeed())
+			Expect(j.AddEntry("stale.txt", moved)).To(Succeed())
+			Expect(j.SetLastEntryMoved()).To(Succeed())
+			Expect(j.AddEntry("bar-app-1.0.package.tar",
+				path.Join(j.TrashDir, "bar-app-1.0.package.tar"))).To(Succeed())
+
+			Expect(c.Undo(journalFile)).To(Succeed())
+
+			Expect(fileExists("stale.txt")).To(BeTrue())
+			Expect(fileExists("bar-app-1.0.package.tar")).To(BeTrue())
+		})
+
+		It("Refuses journals of other backends", func() {
+			Expect(newCleaner().Run()).To(Succeed())
+
+			j, err := LoadCleanJournal(journalFile)
+			Expect(err).ToNot(HaveOccurred())
+			j.Target = "/another/repo"
+			Expect(j.Write()).To(Succeed())
+
+			Expect(newCleaner().Undo(journalFile)).ToNot(Succeed())
+			Expect(fileExists("stale.txt")).To(BeFalse())
+		})
+	})
+
+	Context("Purge", func() {
+
+		It("Removes the files of the completed journals", func() {
+			Expect(newCleaner().Run()).To(Succeed())
+
+			n, err := newCleaner().Purge(journalDir, 0)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(n).To(Equal(1))
+
+			j, err := LoadCleanJournal(journalFile)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(j.State).To(Equal(JournalStatePurged))
+			for _, e := range j.Entries {
+				Expect(fileExists(e.Destination)).To(BeFalse())
+			}
+
+			// A purged journal can't be restored
+			Expect(newCleaner().Undo(journalFile)).ToNot(Succeed())
+		})
+
+		It("Records a partial purge", func() {
+			Expect(newCleaner().Run()).To(Succeed())
+
+			j, err := LoadCleanJournal(journalFile)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(len(j.Entries)).To(Equal(3))
+			failing := j.Entries[1].Destination
+
+			c := newCleaner()
+			c.BackendHandler = &failingBackend{
+				RepoBackendHandler: c.BackendHandler,
+				failClean:          failing,
+			}
+			_, err = c.Purge(journalDir, 0)
+			Expect(err).To(HaveOccurred())
+
+			j, err = LoadCleanJournal(journalFile)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(j.State).To(Equal(JournalStatePartiallyPurged))
+			Expect(j.Entries[0].Purged).To(BeTrue())
+			Expect(j.Entries[1].Purged).To(BeFalse())
+			Expect(fileExists(j.Entries[0].Destination)).To(BeFalse())
+			Expect(fileExists(failing)).To(BeTrue())
+
+			// The files can't be restored anymore.
+			Expect(newCleaner().Undo(journalFile)).ToNot(Succeed())
+
+			// The next purge removes the remaining files.
+			n, err := newCleaner().Purge(journalDir, 0)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(n).To(Equal(1))
+
+			j, err = LoadCleanJournal(journalFile)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(j.State).To(Equal(JournalStatePurged))
+			for _, e := range j.Entries {
+				Expect(e.Purged).To(BeTrue())
+				Expect(fileExists(e.Destination)).To(BeFalse())
+			}
+		})
+
+		It("Ignores the recent journals", func() {
+			Expect(newCleaner().Run()).To(Succeed())
+
+			n, err := newCleaner().Purge(journalDir, time.Hour)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(n).To(Equal(0))
+
+			j, err := LoadCleanJournal(journalFile)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(j.State).To(Equal(JournalStateCompleted))
+		})
+	})
+})
@@ -0,0 +1,222 @@
+/*
+Copyright (C) 2020-2021  Daniele Rondina <geaaru@sabayonlinux.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+*/
+
+package devkit
+
+import (
+	"fmt"
+	"sort"
+
+	artifact "github.com/mudler/luet/pkg/compiler/types/artifact"
+	luet_version "github.com/mudler/luet/pkg/versioner"
+)
+
+type RepoDiff struct {
+	From *RepoKnife
+	To   *RepoKnife
+}
+
+type RepoDiffReport struct {
+	OnlyFrom     []PackageVersions `json:"only_from"`
+	OnlyTo       []PackageVersions `json:"only_to"`
+	VersionDrift []VersionDrift    `json:"version_drift"`
+	FilesDiff    []FilesDiff       `json:"files_diff"`
+}
+
+type PackageVersions struct {
+	Package  string   `json:"package"`
+	Versions []string `json:"versions"`
+}
+
+type VersionDrift struct {
+	Package string   `json:"package"`
+	From    []string `json:"from"`
+	To      []string `json:"to"`
+}
+
+type FilesDiff struct {
+	Package string   `json:"package"`
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+}
+
+func NewRepoDiff(from, to *RepoKnife) *RepoDiff {
+	// The diff doesn't use the trees.
+	from.SkipTreesCheck = true
+	to.SkipTreesCheck = true
+	return &RepoDiff{
+		From: from,
+		To:   to,
+	}
+}
+
+func (r *RepoDiffReport) IsEmpty() bool {
+	return len(r.OnlyFrom) == 0 && len(r.OnlyTo) == 0 &&
+		len(r.VersionDrift) == 0 && len(r.FilesDiff) == 0
+}
+
+// GroupByPackage returns the artefacts of the MetaMap grouped by
+// category/name and version.
+func (c *RepoKnife) GroupByPackage() map[string]map[string]*artifact.PackageArtifact {
+	ans := make(map[string]map[string]*artifact.PackageArtifact, 0)
+
+	for _, art := range c.MetaMap {
+		p := art.CompileSpec.GetPackage()
+		key := fmt.Sprintf("%s/%s", p.GetCategory(), p.GetName())
+		if _, ok := ans[key]; !ok {
+			ans[key] = make(map[string]*artifact.PackageArtifact, 0)
+		}
+		ans[key][p.GetVersion()] = art
+	}
+
+	return ans
+}
+
+func (d *RepoDiff) Run() (*RepoDiffReport, error) {
+	ans := &RepoDiffReport{
+		OnlyFrom:     []PackageVersions{},
+		OnlyTo:       []PackageVersions{},
+		VersionDrift: []VersionDrift{},
+		FilesDiff:    []FilesDiff{},
+	}
+
+	if err := d.From.Analyze(); err != nil {
+		return nil, err
+	}
+	if err := d.To.Analyze(); err != nil {
+		return nil, err
+	}
+
+	fromPkgs := d.From.GroupByPackage()
+	toPkgs := d.To.GroupByPackage()
+
+	for _, p := range sortedGroupKeys(fromPkgs) {
+		fromVersions := fromPkgs[p]
+		toVersions, ok := toPkgs[p]
+		if !ok {
+			ans.OnlyFrom = append(ans.OnlyFrom, PackageVersions{
+				Package:  p,
+				Versions: sortedVersions(fromVersions),
+			})
+			continue
+		}
+
+		fv := sortedVersions(fromVersions)
+		tv := sortedVersions(toVersions)
+		if !sameStrings(fv, tv) {
+			ans.VersionDrift = append(ans.VersionDrift, VersionDrift{
+				Package: p,
+				From:    fv,
+				To:      tv,
+			})
+		}
+
+		for _, v := range fv {
+			toArt, ok := toVersions[v]
+			if !ok {
+				continue
+			}
+
+			added, removed := diffFiles(fromVersions[v].Files, toArt.Files)
+			if len(added) > 0 || len(removed) > 0 {
+				ans.FilesDiff = append(ans.FilesDiff, FilesDiff{
+					Package: fmt.Sprintf("%s-%s", p, v),
+					Added:   added,
+					Removed: removed,
+				})
+			}
+		}
+	}
+
+	for _, p := range sortedGroupKeys(toPkgs) {
+		if _, ok := fromPkgs[p]; !ok {
+			ans.OnlyTo = append(ans.OnlyTo, PackageVersions{
+				Package:  p,
+				Versions: sortedVersions(toPkgs[p]),
+			})
+		}
+	}
+
+	return ans, nil
+}
+
+// diffFiles returns the files available only in the
+// second list (added) and only in the first list (removed).
+func diffFiles(from, to []string) ([]string, []string) {
+	added := []string{}
+	removed := []string{}
+
+	mFrom := make(map[string]bool, len(from))
+	for _, f := range from {
+		mFrom[f] = true
+	}
+	mTo := make(map[string]bool, len(to))
+	for _, f := range to {
+		mTo[f] = true
+		if _, ok := mFrom[f]; !ok {
+			added = append(added, f)
+		}
+	}
+	for _, f := range from {
+		if _, ok := mTo[f]; !ok {
+			removed = append(removed, f)
+		}
+	}
+
+	sort.Strings(added)
+	sort.Strings(removed)
+
+	return added, removed
+}
+
+// sameStrings returns true if the lists contain the same strings
+// in any order.
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	sa := append([]string{}, a...)
+	sb := append([]string{}, b...)
+	sort.Strings(sa)
+	sort.Strings(sb)
+	for i := range sa {
+		if sa[i] != sb[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func sortedGroupKeys(m map[string]map[string]*artifact.PackageArtifact) []string {
+	ans := []string{}
+	for k := range m {
+		ans = append(ans, k)
+	}
+	sort.Strings(ans)
+	return ans
+}
+
+func sortedVersions(m map[string]*artifact.PackageArtifact) []string {
+	ans := []string{}
+	for v := range m {
+		ans = append(ans, v)
+	}
+	return luet_version.DefaultVersioner().Sort(ans)
+}
@@ -0,0 +1,140 @@
+/*
+Copyright (C) 2020-2021  Daniele Rondina <geaaru@sabayonlinux.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+*/
+
+package devkit_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/Luet-lab/extensions/extensions/repo-devkit/pkg/devkit"
+	specs "github.com/Luet-lab/extensions/extensions/repo-devkit/pkg/specs"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Diff", func() {
+	var fromDir, toDir string
+
+	// writePackage writes the tarball and the metadata of the
+	// package cat/name-version with the files.
+	writePackage := func(dir, cat, name, version string, files ...string) {
+		base := fmt.Sprintf("%s-%s-%s", name, cat, version)
+		meta := fmt.Sprintf(`path: /repo/%s.package.tar
+compilespec:
+  package:
+    name: %s
+    version: "%s"
+    category: %s
+compressiontype: none
+files: [%s]
+`, base, name, version, cat, strings.Join(files, ", "))
+
+		Expect(ioutil.WriteFile(filepath.Join(dir, base+".metadata.yaml"),
+			[]byte(meta), 0644)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(dir, base+".package.tar"),
+			[]byte("tarball"), 0644)).To(Succeed())
+	}
+
+	runDiff := func() *RepoDiffReport {
+		from, err := NewRepoKnife(specs.NewLuetRDConfig(), "local", fromDir,
+			map[string]string{})
+		Expect(err).ToNot(HaveOccurred())
+		to, err := NewRepoKnife(specs.NewLuetRDConfig(), "local", toDir,
+			map[string]string{})
+		Expect(err).ToNot(HaveOccurred())
+
+		report, err := NewRepoDiff(from, to).Run()
+		Expect(err).ToNot(HaveOccurred())
+		// The packages aren't in a tree but the diff doesn't
+		// select files to remove.
+		Expect(from.Files2Remove).To(BeEmpty())
+		Expect(to.Files2Remove).To(BeEmpty())
+		return report
+	}
+
+	BeforeEach(func() {
+		var err error
+		fromDir, err = ioutil.TempDir("", "repo-devkit-diff-from")
+		Expect(err).ToNot(HaveOccurred())
+		toDir, err = ioutil.TempDir("", "repo-devkit-diff-to")
+		Expect(err).ToNot(HaveOccurred())
+
+		for _, dir := range []string{fromDir, toDir} {
+			writePackage(dir, "app", "foo", "1.0", "usr/bin/foo")
+		}
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(fromDir)
+		os.RemoveAll(toDir)
+	})
+
+	It("Reports no differences", func() {
+		report := runDiff()
+		Expect(report.IsEmpty()).To(BeTrue())
+	})
+
+	It("Reports the added and removed packages", func() {
+		writePackage(fromDir, "app", "bar", "1.0")
+		writePackage(toDir, "app", "baz", "2.0")
+		writePackage(toDir, "app", "baz", "1.0")
+
+		report := runDiff()
+		Expect(report.OnlyFrom).To(Equal([]PackageVersions{
+			{Package: "app/bar", Versions: []string{"1.0"}},
+		}))
+		Expect(report.OnlyTo).To(Equal([]PackageVersions{
+			{Package: "app/baz", Versions: []string{"1.0", "2.0"}},
+		}))
+		Expect(report.VersionDrift).To(BeEmpty())
+		Expect(report.FilesDiff).To(BeEmpty())
+	})
+
+	It("Reports the changed versions", func() {
+		writePackage(fromDir, "app", "foo", "1.1", "usr/bin/foo")
+		writePackage(toDir, "app", "foo", "2.0", "usr/bin/foo")
+
+		report := runDiff()
+		Expect(report.VersionDrift).To(Equal([]VersionDrift{
+			{Package: "app/foo", From: []string{"1.0", "1.1"}, To: []string{"1.0", "2.0"}},
+		}))
+		Expect(report.OnlyFrom).To(BeEmpty())
+		Expect(report.OnlyTo).To(BeEmpty())
+		Expect(report.FilesDiff).To(BeEmpty())
+	})
+
+	It("Reports the files differences", func() {
+		writePackage(fromDir, "app", "foo", "1.0", "usr/bin/foo", "usr/share/foo/old")
+		writePackage(toDir, "app", "foo", "1.0", "usr/share/foo/new", "usr/bin/foo")
+
+		report := runDiff()
+		Expect(report.FilesDiff).To(Equal([]FilesDiff{
+			{
+				Package: "app/foo-1.0",
+				Added:   []string{"usr/share/foo/new"},
+				Removed: []string{"usr/share/foo/old"},
+			},
+		}))
+		Expect(report.VersionDrift).To(BeEmpty())
+	})
+})
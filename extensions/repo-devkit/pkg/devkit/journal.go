@@ -0,0 +1,168 @@
+/*
+Copyright (C) 2020-2021  Daniele Rondina <geaaru@sabayonlinux.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+*/
+
+package devkit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+const (
+	JournalStateRunning    = "running"
+	JournalStateCompleted  = "completed"
+	JournalStateRolledBack = "rolledback"
+	JournalStateRestored   = "restored"
+	JournalStatePurged     = "purged"
+	// The purge failed, a part of the files are removed.
+	JournalStatePartiallyPurged = "partially-purged"
+)
+
+type CleanJournal struct {
+	Id           string              `json:"id"`
+	Backend      string              `json:"backend"`
+	Target       string              `json:"target"`
+	TrashDir     string              `json:"trash_dir"`
+	CreationTime time.Time           `json:"creation_time"`
+	State        string              `json:"state"`
+	Entries      []CleanJournalEntry `json:"entries"`
+
+	file string
+}
+
+// CleanJournalEntry is written before moving the file. Moved is set
+// after the move, an entry not moved could be related to an interrupted
+// clean. Purged is set when the file is removed from the trash area.
+type CleanJournalEntry struct {
+	Source      string    `json:"source"`
+	Destination string    `json:"destination"`
+	Time        time.Time `json:"time"`
+	Moved       bool      `json:"moved"`
+	Purged      bool      `json:"purged,omitempty"`
+}
+
+// NewCleanJournalId returns the id of a new journal. The id contains
+// the nanoseconds to use a different trash directory for every clean.
+func NewCleanJournalId(t time.Time) string {
+	return fmt.Sprintf("%s-%09d", t.Format("20060102150405"), t.Nanosecond())
+}
+
+// GetCleanJournalFile returns the default path of the journal inside
+// the directory dir.
+func GetCleanJournalFile(dir, id string) string {
+	return filepath.Join(dir, fmt.Sprintf("repo-devkit-clean-%s.json", id))
+}
+
+func NewCleanJournal(backend, target, trashDir, file string) *CleanJournal {
+	now := time.Now().UTC()
+	id := NewCleanJournalId(now)
+	return &CleanJournal{
+		Id:           id,
+		Backend:      backend,
+		Target:       target,
+		TrashDir:     path.Join(trashDir, id),
+		CreationTime: now,
+		State:        JournalStateRunning,
+		Entries:      []CleanJournalEntry{},
+		file:         file,
+	}
+}
+
+func LoadCleanJournal(file string) (*CleanJournal, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	ans := &CleanJournal{}
+	if err = json.Unmarshal(content, ans); err != nil {
+		return nil, errors.New(
+			fmt.Sprintf("Error on parse journal %s: %s", file, err.Error()))
+	}
+	ans.file = file
+
+	return ans, nil
+}
+
+func (j *CleanJournal) GetFile() string { return j.file }
+
+// SetFile sets the path of the journal file.
+func (j *CleanJournal) SetFile(file string) { j.file = file }
+
+// Create writes the journal file. It fails if the file already exists
+// to not lose the journal of a previous clean.
+func (j *CleanJournal) Create() error {
+	data, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(j.file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// AddEntry records the move of a file before it's done.
+func (j *CleanJournal) AddEntry(src, dst string) error {
+	j.Entries = append(j.Entries, CleanJournalEntry{
+		Source:      src,
+		Destination: dst,
+		Time:        time.Now().UTC(),
+	})
+	return j.Write()
+}
+
+// SetLastEntryMoved marks the last entry as moved.
+func (j *CleanJournal) SetLastEntryMoved() error {
+	if len(j.Entries) == 0 {
+		return errors.New("No entries available")
+	}
+	j.Entries[len(j.Entries)-1].Moved = true
+	return j.Write()
+}
+
+// SetEntryPurged marks the entry i as purged.
+func (j *CleanJournal) SetEntryPurged(i int) error {
+	j.Entries[i].Purged = true
+	return j.Write()
+}
+
+func (j *CleanJournal) SetState(state string) error {
+	j.State = state
+	return j.Write()
+}
+
+func (j *CleanJournal) Write() error {
+	data, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(j.file, data, 0644)
+}
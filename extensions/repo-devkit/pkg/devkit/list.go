@@ -100,26 +100,37 @@ func (c *RepoList) ListPkgsMissing() ([]*luet_pkg.DefaultPackage, error) {
 
 func (c *RepoList) ListPkgsMissingByDeps(treePaths []string, withResolve bool) ([]*luet_pkg.DefaultPackage, error) {
 	ans := []*luet_pkg.DefaultPackage{}
+
+	batches, err := c.ListPkgsMissingByBatches(treePaths, withResolve)
+	if err != nil {
+		return ans, err
+	}
+
+	for _, b := range batches {
+		ans = append(ans, b.Packages...)
+	}
+
+	return ans, nil
+}
+
+// ListPkgsMissingByBatches returns the missing packages grouped in
+// build batches. The packages of the same batch don't depend on each
+// other and could be built in parallel after the previous batches.
+func (c *RepoList) ListPkgsMissingByBatches(treePaths []string, withResolve bool) ([]*BuildBatch, error) {
+	ans := []*BuildBatch{}
 	reciperBuild := luet_tree.NewCompilerRecipe(luet_pkg.NewInMemoryDatabase(false))
 
 	list, err := c.ListPkgsMissing()
 	if err != nil {
-		return list, err
+		return ans, err
 	}
 
 	pc := converter.NewPortageConverter("", "repoman")
 
 	// Load ReciperBuildtime
-	for _, t := range treePaths {
-		if c.Verbose {
-			InfoC(fmt.Sprintf(":evergreen_tree: Loading tree %s...", t))
-		} else {
-			DebugC(fmt.Sprintf(":evergreen_tree: Loading tree %s...", t))
-		}
-		err := reciperBuild.Load(t)
-		if err != nil {
-			return ans, errors.New("Error on load tree" + err.Error())
-		}
+	err = c.loadBuildTrees(reciperBuild, treePaths)
+	if err != nil {
+		return ans, err
 	}
 
 	// Using local load of the three to reduce log verbosity.
@@ -172,14 +183,30 @@ func (c *RepoList) ListPkgsMissingByDeps(treePaths []string, withResolve bool) (
 		worker.Levels.Resolve()
 	}
 
-	ans = c.retrieveMissingOrdered(&worker, mMissings)
+	ans = c.retrieveMissingBatches(&worker, mMissings)
 
 	return ans, nil
 }
 
-func (c *RepoList) retrieveMissingOrdered(w *converter.Stage4Worker, missings map[string]*luet_pkg.DefaultPackage) []*luet_pkg.DefaultPackage {
+func (c *RepoList) loadBuildTrees(reciperBuild luet_tree.Builder, treePaths []string) error {
+	for _, t := range treePaths {
+		if c.Verbose {
+			InfoC(fmt.Sprintf(":evergreen_tree: Loading tree %s...", t))
+		} else {
+			DebugC(fmt.Sprintf(":evergreen_tree: Loading tree %s...", t))
+		}
+		err := reciperBuild.Load(t)
+		if err != nil {
+			return errors.New("Error on load tree" + err.Error())
+		}
+	}
 
-	ans := []*luet_pkg.DefaultPackage{}
+	return nil
+}
+
+func (c *RepoList) retrieveMissingBatches(w *converter.Stage4Worker, missings map[string]*luet_pkg.DefaultPackage) []*BuildBatch {
+
+	ans := []*BuildBatch{}
 	processedDeps := make(map[string]bool, 0)
 
 	// TODO: Check why we have leaf with version >=0
@@ -190,6 +217,11 @@ func (c *RepoList) retrieveMissingOrdered(w *converter.Stage4Worker, missings ma
 
 	for i := len(w.Levels.Levels) - 1; i >= 0; i-- {
 
+		batch := &BuildBatch{
+			Level:    len(ans) + 1,
+			Packages: []*luet_pkg.DefaultPackage{},
+		}
+
 		for _, dep := range w.Levels.Levels[i].Deps {
 
 			key := fmt.Sprintf("%s/%s", dep.GetCategory(), dep.GetName())
@@ -199,7 +231,7 @@ func (c *RepoList) retrieveMissingOrdered(w *converter.Stage4Worker, missings ma
 				if v, ok := missings[key]; ok {
 
 					// Package to build
-					ans = append(ans, v)
+					batch.Packages = append(batch.Packages, v)
 
 				}
 
@@ -210,6 +242,10 @@ func (c *RepoList) retrieveMissingOrdered(w *converter.Stage4Worker, missings ma
 
 		}
 
+		if len(batch.Packages) > 0 {
+			ans = append(ans, batch)
+		}
+
 	}
 
 	return ans
@@ -0,0 +1,67 @@
+/*
+Copyright (C) 2020-2021  Daniele Rondina <geaaru@sabayonlinux.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+*/
+
+package devkit
+
+import (
+	"github.com/Luet-lab/luet-portage-converter/pkg/converter"
+	luet_pkg "github.com/mudler/luet/pkg/package"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Missing packages batches", func() {
+
+	newPkg := func(category, name, version string) *luet_pkg.DefaultPackage {
+		return &luet_pkg.DefaultPackage{Category: category, Name: name, Version: version}
+	}
+
+	It("Drops the empty levels and renumbers the batches", func() {
+		zlib := newPkg("sys-libs", "zlib", "1.2.11")
+		openssl := newPkg("dev-libs", "openssl", "1.1.1k")
+		curl := newPkg("net-misc", "curl", "7.77.0")
+
+		// The last level is built first. The middle level
+		// contains only packages already available and zlib is
+		// required by two levels.
+		w := &converter.Stage4Worker{
+			Levels: &converter.Stage4Levels{
+				Levels: []*converter.Stage4Tree{
+					{Deps: []*luet_pkg.DefaultPackage{curl}},
+					{Deps: []*luet_pkg.DefaultPackage{newPkg("dev-libs", "available", ">=0"), zlib}},
+					{Deps: []*luet_pkg.DefaultPackage{newPkg("sys-libs", "zlib", ">=0"), openssl}},
+				},
+			},
+		}
+		missings := map[string]*luet_pkg.DefaultPackage{
+			zlib.HumanReadableString():    zlib,
+			openssl.HumanReadableString(): openssl,
+			curl.HumanReadableString():    curl,
+		}
+
+		batches := (&RepoList{}).retrieveMissingBatches(w, missings)
+		SortBuildBatches(batches)
+
+		Expect(batches).To(HaveLen(2))
+		Expect(batches[0].Level).To(Equal(1))
+		Expect(batches[0].Packages).To(Equal([]*luet_pkg.DefaultPackage{openssl, zlib}))
+		Expect(batches[1].Level).To(Equal(2))
+		Expect(batches[1].Packages).To(Equal([]*luet_pkg.DefaultPackage{curl}))
+	})
+})
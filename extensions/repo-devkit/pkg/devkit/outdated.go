@@ -0,0 +1,185 @@
+/*
+Copyright (C) 2020-2021  Daniele Rondina <geaaru@sabayonlinux.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+*/
+
+package devkit
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	luet_compiler "github.com/mudler/luet/pkg/compiler"
+	luet_options "github.com/mudler/luet/pkg/compiler/types/options"
+	luet_compiler_spec "github.com/mudler/luet/pkg/compiler/types/spec"
+	. "github.com/mudler/luet/pkg/logger"
+	luet_pkg "github.com/mudler/luet/pkg/package"
+	luet_tree "github.com/mudler/luet/pkg/tree"
+)
+
+const (
+	OutdatedRequires  = "requires"
+	OutdatedConflicts = "conflicts"
+	OutdatedProvides  = "provides"
+	OutdatedBuildSpec = "build-spec"
+)
+
+// OutdatedPackage describes a package available in the repository
+// with the same version of the tree but with a different definition.
+type OutdatedPackage struct {
+	Package *luet_pkg.DefaultPackage `json:"-"`
+	// The list of the parts of the definition changed.
+	Reasons []string `json:"reasons"`
+
+	RepoSpecHash string `json:"repo_spec_hash"`
+	TreeSpecHash string `json:"tree_spec_hash"`
+}
+
+// ListPkgsOutdated returns the packages available in the repository
+// that have the same version of the tree but with requires, conflicts,
+// provides or the rendered build spec changed. The build values
+// files are used to render the build specs of the tree.
+func (c *RepoList) ListPkgsOutdated(treePaths []string, buildValues []string) ([]*OutdatedPackage, error) {
+	ans := []*OutdatedPackage{}
+	reciperBuild := luet_tree.NewCompilerRecipe(luet_pkg.NewInMemoryDatabase(false))
+
+	err := c.RepoKnife.Analyze()
+	if err != nil {
+		return ans, err
+	}
+
+	err = c.loadBuildTrees(reciperBuild, treePaths)
+	if err != nil {
+		return ans, err
+	}
+
+	compiler := luet_compiler.NewLuetCompiler(nil, reciperBuild.GetDatabase(),
+		luet_options.WithBuildValues(buildValues))
+
+	for _, k := range sortedMetaKeys(c.MetaMap) {
+		art := c.MetaMap[k]
+		p := art.CompileSpec.Package
+
+		if c.Specs.List.ToIgnore(p) {
+			Debug("Ignoring package %s", p.HumanReadableString())
+			continue
+		}
+
+		tp, err := reciperBuild.GetDatabase().FindPackage(p)
+		if err != nil {
+			DebugC(fmt.Sprintf("Package %s not available in tree.",
+				p.HumanReadableString()))
+			continue
+		}
+
+		outdated := &OutdatedPackage{
+			Package: p,
+			Reasons: []string{},
+		}
+
+		for _, d := range []struct {
+			Reason string
+			Repo   []*luet_pkg.DefaultPackage
+			Tree   []*luet_pkg.DefaultPackage
+		}{
+			{OutdatedRequires, p.GetRequires(), tp.GetRequires()},
+			{OutdatedConflicts, p.GetConflicts(), tp.GetConflicts()},
+			{OutdatedProvides, p.GetProvides(), tp.GetProvides()},
+		} {
+			if pkgsHash(d.Repo) != pkgsHash(d.Tree) {
+				outdated.Reasons = append(outdated.Reasons, d.Reason)
+			}
+		}
+
+		treeSpec, err := compiler.FromPackage(tp)
+		if err != nil {
+			return ans, errors.New(
+				fmt.Sprintf("Error on render build spec of the package %s: %s",
+					tp.HumanReadableString(), err.Error()))
+		}
+
+		outdated.TreeSpecHash = buildSpecDigest(treeSpec)
+		outdated.RepoSpecHash = buildSpecDigest(art.CompileSpec)
+
+		if outdated.TreeSpecHash != outdated.RepoSpecHash {
+			outdated.Reasons = append(outdated.Reasons, OutdatedBuildSpec)
+		}
+
+		if len(outdated.Reasons) > 0 {
+			DebugC(fmt.Sprintf("Package %s is outdated: %s",
+				p.HumanReadableString(), strings.Join(outdated.Reasons, ", ")))
+			ans = append(ans, outdated)
+		}
+	}
+
+	return ans, nil
+}
+
+// buildSpecDigest returns a digest of the build fields of the spec.
+// The package definition and the build options aren't part of the
+// digest and the lists are normalized, so the spec stored in the
+// repository metadata has the same digest of the spec rendered
+// from the tree.
+func buildSpecDigest(cs *luet_compiler_spec.LuetCompilationSpec) string {
+	copies := []string{}
+	for _, c := range cs.Copy {
+		pkg := ""
+		if c.Package != nil {
+			pkg = fmt.Sprintf("%s/%s@%s",
+				c.Package.GetCategory(), c.Package.GetName(), c.Package.GetVersion())
+		}
+		copies = append(copies, fmt.Sprintf("%s|%s|%s|%s",
+			pkg, c.Image, c.Source, c.Destination))
+	}
+
+	join := []*luet_pkg.DefaultPackage{}
+	for _, p := range cs.Join {
+		join = append(join, p)
+	}
+
+	fields := []string{
+		"image=" + cs.Image,
+		"seed=" + cs.Seed,
+		"package_dir=" + cs.PackageDir,
+		fmt.Sprintf("unpack=%t", cs.Unpack),
+		"steps=" + strings.Join(cs.Steps, "\x00"),
+		"prelude=" + strings.Join(cs.Prelude, "\x00"),
+		"env=" + strings.Join(cs.Env, "\x00"),
+		"retrieve=" + strings.Join(cs.Retrieve, "\x00"),
+		"includes=" + strings.Join(cs.Includes, "\x00"),
+		"excludes=" + strings.Join(cs.Excludes, "\x00"),
+		"copy=" + strings.Join(copies, "\x00"),
+		"join=" + pkgsHash(join),
+	}
+
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(strings.Join(fields, "\n"))))
+}
+
+// pkgsHash returns an hash of the list of packages that doesn't
+// depend on the order of the packages.
+func pkgsHash(pkgs []*luet_pkg.DefaultPackage) string {
+	list := []string{}
+	for _, p := range pkgs {
+		list = append(list, fmt.Sprintf("%s/%s@%s",
+			p.GetCategory(), p.GetName(), p.GetVersion()))
+	}
+	sort.Strings(list)
+
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(strings.Join(list, "\n"))))
+}
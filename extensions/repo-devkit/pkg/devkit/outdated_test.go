@@ -0,0 +1,152 @@
+/*
+Copyright (C) 2020-2021  Daniele Rondina <geaaru@sabayonlinux.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+*/
+
+package devkit_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/Luet-lab/extensions/extensions/repo-devkit/pkg/devkit"
+	specs "github.com/Luet-lab/extensions/extensions/repo-devkit/pkg/specs"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const outdatedDefinition = `name: foo
+category: app
+version: "1.0"
+`
+
+// The build tree uses the requires and the conflicts of the
+// build spec.
+const outdatedBuild = `image: alpine
+steps:
+- make
+- make install
+requires:
+- name: bar
+  category: app
+  version: ">=0"
+`
+
+// outdatedMetadata is the metadata of the package built from the
+// tree, with the package fields and the build options set at build
+// time.
+const outdatedMetadata = `path: /repo/foo-app-1.0.package.tar
+compilespec:
+  package:
+    name: foo
+    version: "1.0"
+    category: app
+    packagerequires:
+    - name: bar
+      category: app
+      version: ">=0"
+    labels:
+      build.date: "2021-06-01"
+    treedir: /build/tree
+  image: alpine
+  steps:
+  - make
+  - make install
+  env: []
+  build_options:
+    pull: true
+compressiontype: none
+`
+
+var _ = Describe("Outdated", func() {
+	var repoDir, treeDir string
+
+	writeFile := func(dir, name, content string) {
+		f := filepath.Join(dir, filepath.FromSlash(name))
+		Expect(os.MkdirAll(filepath.Dir(f), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(f, []byte(content), 0644)).To(Succeed())
+	}
+
+	listOutdated := func() []string {
+		l, err := NewRepoList(specs.NewLuetRDConfig(), "local", repoDir,
+			map[string]string{})
+		Expect(err).ToNot(HaveOccurred())
+
+		outdated, err := l.ListPkgsOutdated([]string{treeDir}, []string{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(len(outdated)).To(BeNumerically("<=", 1))
+		if len(outdated) == 0 {
+			return []string{}
+		}
+		Expect(outdated[0].Package.HumanReadableString()).To(Equal("app/foo-1.0"))
+		return outdated[0].Reasons
+	}
+
+	BeforeEach(func() {
+		var err error
+		repoDir, err = ioutil.TempDir("", "repo-devkit-outdated")
+		Expect(err).ToNot(HaveOccurred())
+		treeDir, err = ioutil.TempDir("", "repo-devkit-tree")
+		Expect(err).ToNot(HaveOccurred())
+
+		writeFile(treeDir, "app/foo/definition.yaml", outdatedDefinition)
+		writeFile(treeDir, "app/foo/build.yaml", outdatedBuild)
+		writeFile(treeDir, "app/bar/definition.yaml",
+			"name: bar\ncategory: app\nversion: \"1.0\"\n")
+		writeFile(treeDir, "app/bar/build.yaml", "image: alpine\n")
+
+		writeFile(repoDir, "foo-app-1.0.metadata.yaml", outdatedMetadata)
+		writeFile(repoDir, "foo-app-1.0.package.tar", "tarball")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(repoDir)
+		os.RemoveAll(treeDir)
+	})
+
+	It("Ignores the fields set at build time", func() {
+		Expect(listOutdated()).To(BeEmpty())
+	})
+
+	It("Reports the changed requires", func() {
+		writeFile(treeDir, "app/foo/build.yaml", outdatedBuild+`- name: baz
+  category: app
+  version: ">=0"
+`)
+		Expect(listOutdated()).To(Equal([]string{OutdatedRequires}))
+	})
+
+	It("Reports the changed conflicts and provides", func() {
+		writeFile(treeDir, "app/foo/build.yaml", outdatedBuild+`conflicts:
+- name: baz
+  category: app
+`)
+		writeFile(treeDir, "app/foo/definition.yaml", outdatedDefinition+`provides:
+- name: foo-compat
+  category: app
+`)
+		Expect(listOutdated()).To(Equal([]string{OutdatedConflicts, OutdatedProvides}))
+	})
+
+	It("Reports the changed build spec", func() {
+		writeFile(treeDir, "app/foo/build.yaml",
+			strings.Replace(outdatedBuild, "- make install", "- make check install", 1))
+		Expect(listOutdated()).To(Equal([]string{OutdatedBuildSpec}))
+	})
+})
@@ -0,0 +1,260 @@
+/*
+Copyright (C) 2020-2021  Daniele Rondina <geaaru@sabayonlinux.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+*/
+
+package devkit
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	specs "github.com/Luet-lab/extensions/extensions/repo-devkit/pkg/specs"
+
+	artifact "github.com/mudler/luet/pkg/compiler/types/artifact"
+	. "github.com/mudler/luet/pkg/logger"
+	luet_pkg "github.com/mudler/luet/pkg/package"
+)
+
+type RepoPromoter struct {
+	From   *RepoKnife
+	To     *RepoKnife
+	DryRun bool
+	// Overwrite the packages available in the target repository
+	// with a different checksum.
+	Force bool
+
+	// Regex to match with the package name.
+	Filters []*regexp.Regexp
+	// Package selectors.
+	Packages []specs.LuetPackage
+}
+
+type PromotedPackage struct {
+	Package  string `json:"package"`
+	Tarball  string `json:"tarball"`
+	Metadata string `json:"metadata"`
+	Skipped  bool   `json:"skipped,omitempty"`
+	// The target repository has the package with a different
+	// checksum.
+	Conflict bool `json:"conflict,omitempty"`
+}
+
+func NewRepoPromoter(from, to *RepoKnife, dryRun bool) *RepoPromoter {
+	return &RepoPromoter{
+		From:     from,
+		To:       to,
+		DryRun:   dryRun,
+		Filters:  []*regexp.Regexp{},
+		Packages: []specs.LuetPackage{},
+	}
+}
+
+func (p *RepoPromoter) isSelected(pkg *luet_pkg.DefaultPackage) bool {
+	for _, r := range p.Filters {
+		if r.MatchString(pkg.GetPackageName()) {
+			return true
+		}
+	}
+
+	for _, s := range p.Packages {
+		admit, err := s.Admit(pkg)
+		if err != nil {
+			Warning(err.Error())
+			continue
+		}
+		if admit {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p *RepoPromoter) Run() ([]PromotedPackage, error) {
+	ans := []PromotedPackage{}
+	conflicts := 0
+
+	if len(p.Filters) == 0 && len(p.Packages) == 0 {
+		return ans, errors.New("No packages selected")
+	}
+
+	if err := p.From.Analyze(); err != nil {
+		return ans, err
+	}
+	if err := p.To.Analyze(); err != nil {
+		return ans, err
+	}
+
+	tmpdir, err := ioutil.TempDir("", "repo-devkit")
+	if err != nil {
+		return ans, err
+	}
+	defer os.RemoveAll(tmpdir)
+
+	for _, meta := range sortedMetaKeys(p.From.MetaMap) {
+		art := p.From.MetaMap[meta]
+		pkg := art.CompileSpec.Package
+		tarball := filepath.Base(art.Path)
+
+		if !p.isSelected(pkg) {
+			continue
+		}
+
+		if _, ok := p.From.PkgsMap[tarball]; !ok {
+			Warning(fmt.Sprintf("[%s] Tarball %s not available. Skipped.",
+				pkg.HumanReadableString(), tarball))
+			continue
+		}
+
+		promoted := PromotedPackage{
+			Package:  pkg.HumanReadableString(),
+			Tarball:  tarball,
+			Metadata: meta,
+		}
+
+		if toArt, ok := p.To.MetaMap[meta]; ok {
+			sameChecksum := toArt.Checksums[string(artifact.SHA256)] ==
+				art.Checksums[string(artifact.SHA256)]
+
+			if _, ok := p.To.PkgsMap[tarball]; ok && sameChecksum {
+				InfoC(fmt.Sprintf("[%s] Already available.", pkg.HumanReadableString()))
+				promoted.Skipped = true
+				ans = append(ans, promoted)
+				continue
+			}
+
+			if !sameChecksum {
+				promoted.Conflict = true
+				if !p.Force {
+					Warning(fmt.Sprintf(
+						"[%s] Available in the target repository with a different checksum. Skipped.",
+						pkg.HumanReadableString()))
+					conflicts++
+					ans = append(ans, promoted)
+					continue
+				}
+				Warning(fmt.Sprintf(
+					"[%s] Available in the target repository with a different checksum. Overwriting.",
+					pkg.HumanReadableString()))
+			}
+		}
+
+		if p.DryRun {
+			InfoC(fmt.Sprintf("[%s] Could be promoted.", pkg.HumanReadableString()))
+			ans = append(ans, promoted)
+			continue
+		}
+
+		expected, ok := art.Checksums[string(artifact.SHA256)]
+		if !ok || expected == "" {
+			return ans, errors.New(fmt.Sprintf(
+				"No checksum available for package %s", pkg.HumanReadableString()))
+		}
+
+		// The tarball is copied before the metadata to avoid that
+		// the target repository references a missing tarball.
+		err = p.copyFile(tarball, filepath.Join(tmpdir, tarball), expected)
+		if err != nil {
+			return ans, err
+		}
+
+		err = p.copyFile(meta, filepath.Join(tmpdir, meta), "")
+		if err != nil {
+			return ans, err
+		}
+
+		InfoC(fmt.Sprintf("[%s] Promoted.", pkg.HumanReadableString()))
+		ans = append(ans, promoted)
+	}
+
+	if conflicts > 0 {
+		return ans, errors.New(fmt.Sprintf(
+			"%d packages are available in the target repository with a different checksum. Use the force option to overwrite them",
+			conflicts))
+	}
+
+	return ans, nil
+}
+
+// copyFile copies the file from the source backend to the
+// target backend through the local file tmpfile and checks the
+// checksum of the file uploaded. If expected is empty the checksum
+// of the downloaded file is used.
+func (p *RepoPromoter) copyFile(file, tmpfile, expected string) error {
+	defer os.Remove(tmpfile)
+
+	reader, err := p.From.BackendHandler.OpenFile(file)
+	if err != nil {
+		return errors.New(
+			fmt.Sprintf("Error on open file %s: %s", file, err.Error()))
+	}
+	defer reader.Close()
+
+	// The file could be in a subdirectory.
+	err = os.MkdirAll(filepath.Dir(tmpfile), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(tmpfile)
+	if err != nil {
+		return err
+	}
+
+	hasher := sha256.New()
+	_, err = io.Copy(io.MultiWriter(out, hasher), reader)
+	out.Close()
+	if err != nil {
+		return errors.New(
+			fmt.Sprintf("Error on download file %s: %s", file, err.Error()))
+	}
+
+	sum := fmt.Sprintf("%x", hasher.Sum(nil))
+	if expected == "" {
+		expected = sum
+	} else if sum != expected {
+		return errors.New(fmt.Sprintf(
+			"Checksum mismatch on source file %s: expected %s, found %s",
+			file, expected, sum))
+	}
+
+	err = p.To.BackendHandler.UploadFile(tmpfile, file)
+	if err != nil {
+		return errors.New(
+			fmt.Sprintf("Error on upload file %s: %s", file, err.Error()))
+	}
+
+	sum, err = p.To.FileSha256(file)
+	if err != nil {
+		return errors.New(
+			fmt.Sprintf("Error on verify file %s: %s", file, err.Error()))
+	}
+
+	if sum != expected {
+		return errors.New(fmt.Sprintf(
+			"Checksum mismatch on uploaded file %s: expected %s, found %s",
+			file, expected, sum))
+	}
+
+	return nil
+}
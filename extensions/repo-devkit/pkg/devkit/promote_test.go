@@ -0,0 +1,164 @@
+/*
+Copyright (C) 2020-2021  Daniele Rondina <geaaru@sabayonlinux.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+*/
+
+package devkit_test
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	. "github.com/Luet-lab/extensions/extensions/repo-devkit/pkg/devkit"
+	specs "github.com/Luet-lab/extensions/extensions/repo-devkit/pkg/specs"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Promote", func() {
+	var fromDir, toDir string
+
+	// writeArtefact writes the tarball with the content and the
+	// metadata with the checksum of the content, or the checksum
+	// if not empty.
+	writeArtefact := func(dir, content, checksum string) {
+		if checksum == "" {
+			checksum = fmt.Sprintf("%x", sha256.Sum256([]byte(content)))
+		}
+		meta := fmt.Sprintf(`path: /repo/foo-app-1.0.package.tar
+compilespec:
+  package:
+    name: foo
+    version: "1.0"
+    category: app
+compressiontype: none
+checksums:
+  sha256: %s
+`, checksum)
+
+		Expect(ioutil.WriteFile(filepath.Join(dir, "foo-app-1.0.metadata.yaml"),
+			[]byte(meta), 0644)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(dir, "foo-app-1.0.package.tar"),
+			[]byte(content), 0644)).To(Succeed())
+	}
+
+	readTarball := func(dir string) string {
+		data, err := ioutil.ReadFile(filepath.Join(dir, "foo-app-1.0.package.tar"))
+		if err != nil {
+			return ""
+		}
+		return string(data)
+	}
+
+	newPromoter := func(dryRun bool) *RepoPromoter {
+		from, err := NewRepoKnife(specs.NewLuetRDConfig(), "local", fromDir,
+			map[string]string{})
+		Expect(err).ToNot(HaveOccurred())
+		to, err := NewRepoKnife(specs.NewLuetRDConfig(), "local", toDir,
+			map[string]string{})
+		Expect(err).ToNot(HaveOccurred())
+
+		p := NewRepoPromoter(from, to, dryRun)
+		p.Filters = append(p.Filters, regexp.MustCompile("^foo-app$"))
+		return p
+	}
+
+	BeforeEach(func() {
+		var err error
+		fromDir, err = ioutil.TempDir("", "repo-devkit-promote-from")
+		Expect(err).ToNot(HaveOccurred())
+		toDir, err = ioutil.TempDir("", "repo-devkit-promote-to")
+		Expect(err).ToNot(HaveOccurred())
+
+		writeArtefact(fromDir, "new tarball", "")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(fromDir)
+		os.RemoveAll(toDir)
+	})
+
+	It("Promotes the package", func() {
+		promoted, err := newPromoter(false).Run()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(promoted).To(Equal([]PromotedPackage{{
+			Package:  "app/foo-1.0",
+			Tarball:  "foo-app-1.0.package.tar",
+			Metadata: "foo-app-1.0.metadata.yaml",
+		}}))
+		Expect(readTarball(toDir)).To(Equal("new tarball"))
+		Expect(filepath.Join(toDir, "foo-app-1.0.metadata.yaml")).To(BeARegularFile())
+	})
+
+	It("Only reports the packages on dry run", func() {
+		promoted, err := newPromoter(true).Run()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(promoted).To(HaveLen(1))
+		Expect(promoted[0].Skipped).To(BeFalse())
+
+		files, err := ioutil.ReadDir(toDir)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(files).To(BeEmpty())
+	})
+
+	It("Skips the packages already available", func() {
+		writeArtefact(toDir, "new tarball", "")
+
+		promoted, err := newPromoter(false).Run()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(promoted).To(HaveLen(1))
+		Expect(promoted[0].Skipped).To(BeTrue())
+	})
+
+	It("Refuses to overwrite a package with a different checksum", func() {
+		writeArtefact(toDir, "published tarball", "")
+
+		promoted, err := newPromoter(false).Run()
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("different checksum"))
+		Expect(promoted).To(HaveLen(1))
+		Expect(promoted[0].Conflict).To(BeTrue())
+		Expect(readTarball(toDir)).To(Equal("published tarball"))
+	})
+
+	It("Overwrites a package with a different checksum with force", func() {
+		writeArtefact(toDir, "published tarball", "")
+
+		p := newPromoter(false)
+		p.Force = true
+		promoted, err := p.Run()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(promoted).To(HaveLen(1))
+		Expect(promoted[0].Conflict).To(BeTrue())
+		Expect(readTarball(toDir)).To(Equal("new tarball"))
+	})
+
+	It("Fails on checksum mismatch of the source tarball", func() {
+		writeArtefact(fromDir, "corrupted tarball",
+			fmt.Sprintf("%x", sha256.Sum256([]byte("new tarball"))))
+
+		_, err := newPromoter(false).Run()
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("Checksum mismatch on source file"))
+		Expect(readTarball(toDir)).To(BeEmpty())
+		Expect(filepath.Join(toDir, "foo-app-1.0.metadata.yaml")).ToNot(BeAnExistingFile())
+	})
+})
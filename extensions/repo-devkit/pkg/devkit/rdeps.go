@@ -0,0 +1,254 @@
+/*
+Copyright (C) 2020-2021  Daniele Rondina <geaaru@sabayonlinux.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+*/
+
+package devkit
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	specs "github.com/Luet-lab/extensions/extensions/repo-devkit/pkg/specs"
+
+	luet_pkg "github.com/mudler/luet/pkg/package"
+)
+
+// ReverseDep describes a package that depends directly or
+// indirectly on the target package.
+type ReverseDep struct {
+	Package string `json:"package"`
+	// The minimum number of requires between the package and the target.
+	Depth int `json:"depth"`
+	// The rebuild order. The packages with a lower level must be
+	// rebuilt before the packages with a greater level.
+	Level        int      `json:"level"`
+	InTree       bool     `json:"in_tree"`
+	InRepo       bool     `json:"in_repo"`
+	TreeVersions []string `json:"tree_versions,omitempty"`
+	RepoVersions []string `json:"repo_versions,omitempty"`
+	// The direct requires of the package that cause the rebuild.
+	Requires []string `json:"requires"`
+}
+
+type rdepsNode struct {
+	Requires     map[string]bool
+	TreeVersions map[string]bool
+	RepoVersions map[string]bool
+}
+
+type rdepsGraph struct {
+	Nodes map[string]*rdepsNode
+	// Map of the packages that require the key.
+	RequiredBy map[string]map[string]bool
+}
+
+func newRdepsGraph() *rdepsGraph {
+	return &rdepsGraph{
+		Nodes:      make(map[string]*rdepsNode, 0),
+		RequiredBy: make(map[string]map[string]bool, 0),
+	}
+}
+
+func (g *rdepsGraph) add(p *luet_pkg.DefaultPackage, inTree bool) {
+	key := fmt.Sprintf("%s/%s", p.GetCategory(), p.GetName())
+
+	n, ok := g.Nodes[key]
+	if !ok {
+		n = &rdepsNode{
+			Requires:     make(map[string]bool, 0),
+			TreeVersions: make(map[string]bool, 0),
+			RepoVersions: make(map[string]bool, 0),
+		}
+		g.Nodes[key] = n
+	}
+
+	if inTree {
+		n.TreeVersions[p.GetVersion()] = true
+	} else {
+		n.RepoVersions[p.GetVersion()] = true
+	}
+
+	for _, r := range p.GetRequires() {
+		rkey := fmt.Sprintf("%s/%s", r.GetCategory(), r.GetName())
+		n.Requires[rkey] = true
+		if _, ok := g.RequiredBy[rkey]; !ok {
+			g.RequiredBy[rkey] = make(map[string]bool, 0)
+		}
+		g.RequiredBy[rkey][key] = true
+	}
+}
+
+// hasTarget returns true if the target package is available.
+// Without version the package could be only required by other
+// packages, with version a package version must match the selector.
+func (g *rdepsGraph) hasTarget(key string, target *specs.LuetPackage, withVersion bool) (bool, error) {
+	n, ok := g.Nodes[key]
+	if !withVersion {
+		_, required := g.RequiredBy[key]
+		return ok || required, nil
+	}
+	if !ok {
+		return false, nil
+	}
+
+	for _, versions := range []map[string]bool{n.TreeVersions, n.RepoVersions} {
+		for v := range versions {
+			admit, err := target.Admit(&luet_pkg.DefaultPackage{
+				Category: target.GetCategory(),
+				Name:     target.GetName(),
+				Version:  v,
+			})
+			if err != nil {
+				return false, err
+			}
+			if admit {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// level returns the length of the longest chain of requires
+// between the package and the target inside the selected packages.
+func (g *rdepsGraph) level(key string, selected map[string]int, levels map[string]int, stack map[string]bool) int {
+	if l, ok := levels[key]; ok {
+		return l
+	}
+
+	ans := 0
+	stack[key] = true
+	for r := range g.Nodes[key].Requires {
+		if _, ok := selected[r]; !ok || stack[r] {
+			continue
+		}
+		if l := g.level(r, selected, levels, stack) + 1; l > ans {
+			ans = l
+		}
+	}
+	delete(stack, key)
+
+	levels[key] = ans
+	return ans
+}
+
+// ListPkgsReverseDeps returns the packages of the trees and of the
+// repository that require directly or indirectly the package
+// in the format category/name, ordered for rebuild.
+// The version selector of pkgstr must match a version of the package,
+// the requires are matched by category and name without considering
+// the version selectors. With maxDepth greater than 0 only the
+// packages with a depth lower or equal to maxDepth are returned.
+func (c *RepoList) ListPkgsReverseDeps(pkgstr string, maxDepth int) ([]*ReverseDep, error) {
+	ans := []*ReverseDep{}
+
+	target, err := specs.NewLuetPackageFromString(pkgstr)
+	if err != nil {
+		return ans, err
+	}
+	pkg := fmt.Sprintf("%s/%s", target.GetCategory(), target.GetName())
+
+	err = c.RepoKnife.Analyze()
+	if err != nil {
+		return ans, err
+	}
+
+	g := newRdepsGraph()
+	for _, p := range c.ReciperRuntime.GetDatabase().World() {
+		g.add(p.(*luet_pkg.DefaultPackage), true)
+	}
+	for _, art := range c.MetaMap {
+		g.add(art.CompileSpec.Package, false)
+	}
+
+	found, err := g.hasTarget(pkg, target, strings.Contains(pkgstr, "@"))
+	if err != nil {
+		return ans, err
+	}
+	if !found {
+		return ans, errors.New(
+			fmt.Sprintf("Package %s not found in trees and repository", pkgstr))
+	}
+
+	// Visit the graph in breadth to retrieve the minimum depth
+	selected := map[string]int{pkg: 0}
+	queue := []string{pkg}
+	for len(queue) > 0 {
+		key := queue[0]
+		queue = queue[1:]
+
+		if maxDepth > 0 && selected[key] >= maxDepth {
+			continue
+		}
+
+		for rdep := range g.RequiredBy[key] {
+			if _, ok := selected[rdep]; ok {
+				continue
+			}
+			selected[rdep] = selected[key] + 1
+			queue = append(queue, rdep)
+		}
+	}
+
+	levels := map[string]int{pkg: 0}
+	for key, depth := range selected {
+		if key == pkg {
+			continue
+		}
+
+		n := g.Nodes[key]
+		rdep := &ReverseDep{
+			Package:      key,
+			Depth:        depth,
+			Level:        g.level(key, selected, levels, map[string]bool{}),
+			InTree:       len(n.TreeVersions) > 0,
+			InRepo:       len(n.RepoVersions) > 0,
+			TreeVersions: sortedKeys(n.TreeVersions),
+			RepoVersions: sortedKeys(n.RepoVersions),
+			Requires:     []string{},
+		}
+
+		for _, r := range sortedKeys(n.Requires) {
+			if _, ok := selected[r]; ok {
+				rdep.Requires = append(rdep.Requires, r)
+			}
+		}
+
+		ans = append(ans, rdep)
+	}
+
+	sort.Slice(ans, func(i, j int) bool {
+		if ans[i].Level != ans[j].Level {
+			return ans[i].Level < ans[j].Level
+		}
+		return ans[i].Package < ans[j].Package
+	})
+
+	return ans, nil
+}
+
+func sortedKeys(m map[string]bool) []string {
+	ans := []string{}
+	for k := range m {
+		ans = append(ans, k)
+	}
+	sort.Strings(ans)
+	return ans
+}
@@ -0,0 +1,151 @@
+/*
+Copyright (C) 2020-2021  Daniele Rondina <geaaru@sabayonlinux.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+*/
+
+package devkit_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/Luet-lab/extensions/extensions/repo-devkit/pkg/devkit"
+	specs "github.com/Luet-lab/extensions/extensions/repo-devkit/pkg/specs"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Reverse dependencies", func() {
+	var repoDir string
+
+	// writePackage writes the metadata of the package cat/name
+	// with the requires in the format cat/name.
+	writePackage := func(pkgstr, version string, requires ...string) {
+		fields := strings.Split(pkgstr, "/")
+		meta := fmt.Sprintf(`path: /repo/%s-%s-%s.package.tar
+compilespec:
+  package:
+    name: %s
+    version: "%s"
+    category: %s
+    packagerequires:
+`, fields[1], fields[0], version, fields[1], version, fields[0])
+		for _, r := range requires {
+			rfields := strings.Split(r, "/")
+			meta += fmt.Sprintf("    - {category: %s, name: %s, version: \">=0\"}\n",
+				rfields[0], rfields[1])
+		}
+
+		base := fmt.Sprintf("%s-%s-%s", fields[1], fields[0], version)
+		Expect(ioutil.WriteFile(filepath.Join(repoDir, base+".metadata.yaml"),
+			[]byte(meta), 0644)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(repoDir, base+".package.tar"),
+			[]byte("tarball"), 0644)).To(Succeed())
+	}
+
+	// listRdeps returns the reverse dependencies in the format
+	// package:depth:level:requires.
+	listRdeps := func(pkgstr string, maxDepth int) ([]string, error) {
+		l, err := NewRepoList(specs.NewLuetRDConfig(), "local", repoDir,
+			map[string]string{})
+		Expect(err).ToNot(HaveOccurred())
+
+		rdeps, err := l.ListPkgsReverseDeps(pkgstr, maxDepth)
+		ans := []string{}
+		for _, r := range rdeps {
+			Expect(r.InRepo).To(BeTrue())
+			Expect(r.InTree).To(BeFalse())
+			ans = append(ans, fmt.Sprintf("%s:%d:%d:%s",
+				r.Package, r.Depth, r.Level, strings.Join(r.Requires, ",")))
+		}
+		return ans, err
+	}
+
+	BeforeEach(func() {
+		var err error
+		repoDir, err = ioutil.TempDir("", "repo-devkit-rdeps")
+		Expect(err).ToNot(HaveOccurred())
+
+		// A diamond on lib/base.
+		writePackage("lib/base", "1.0")
+		writePackage("lib/left", "1.0", "lib/base")
+		writePackage("lib/right", "1.0", "lib/base")
+		writePackage("app/top", "1.0", "lib/left", "lib/right")
+		writePackage("app/other", "1.0")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(repoDir)
+	})
+
+	It("Orders the diamond for rebuild", func() {
+		rdeps, err := listRdeps("lib/base", 0)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rdeps).To(Equal([]string{
+			"lib/left:1:1:lib/base",
+			"lib/right:1:1:lib/base",
+			"app/top:2:2:lib/left,lib/right",
+		}))
+	})
+
+	It("Stops at the max depth", func() {
+		rdeps, err := listRdeps("lib/base", 1)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rdeps).To(Equal([]string{
+			"lib/left:1:1:lib/base",
+			"lib/right:1:1:lib/base",
+		}))
+	})
+
+	It("Handles the cycles", func() {
+		writePackage("lib/cycle-a", "1.0", "lib/base", "lib/cycle-b")
+		writePackage("lib/cycle-b", "1.0", "lib/cycle-a")
+
+		rdeps, err := listRdeps("lib/cycle-b", 0)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rdeps).To(Equal([]string{
+			"lib/cycle-a:1:1:lib/cycle-b",
+		}))
+
+		rdeps, err = listRdeps("lib/base", 0)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rdeps).To(ContainElement(HavePrefix("lib/cycle-a:1:")))
+		Expect(rdeps).To(ContainElement(HavePrefix("lib/cycle-b:2:")))
+		Expect(rdeps).To(HaveLen(5))
+	})
+
+	It("Matches the version of the target", func() {
+		_, err := listRdeps("lib/base@1.0", 0)
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = listRdeps("lib/base@>=2.0", 0)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("lib/base@>=2.0"))
+
+		_, err = listRdeps("lib/missing", 0)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Returns nothing for a leaf package", func() {
+		rdeps, err := listRdeps("app/other", 0)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rdeps).To(BeEmpty())
+	})
+})
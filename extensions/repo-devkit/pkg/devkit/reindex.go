@@ -0,0 +1,152 @@
+/*
+Copyright (C) 2020-2021  Daniele Rondina <geaaru@sabayonlinux.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+*/
+
+package devkit
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	specs "github.com/Luet-lab/extensions/extensions/repo-devkit/pkg/specs"
+
+	"github.com/mudler/luet/pkg/compiler"
+	luet_installer "github.com/mudler/luet/pkg/installer"
+	. "github.com/mudler/luet/pkg/logger"
+)
+
+type RepoReindexer struct {
+	*RepoKnife
+	DryRun bool
+	// Exclude from the index the packages not available in the trees.
+	FilterByTrees bool
+}
+
+func NewRepoReindexer(s *specs.LuetRDConfig,
+	backend, path string, opts map[string]string,
+	dryRun bool) (*RepoReindexer, error) {
+
+	knife, err := NewRepoKnife(s, backend, path, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	ans := &RepoReindexer{
+		RepoKnife: knife,
+		DryRun:    dryRun,
+	}
+
+	return ans, nil
+}
+
+// Run rebuilds the repository metadata from the metadata files
+// with a tarball and uploads the new repository.yaml with a bumped
+// revision.
+func (c *RepoReindexer) Run() (*luet_installer.LuetSystemRepository, error) {
+	err := c.RepoKnife.Analyze()
+	if err != nil {
+		return nil, err
+	}
+
+	toRemove := make(map[string]bool, len(c.Files2Remove))
+	for _, f := range c.Files2Remove {
+		toRemove[f] = true
+	}
+
+	tmpdir, err := ioutil.TempDir("", "repo-devkit")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpdir)
+
+	repo, err := c.ReadRepositorySpec(tmpdir)
+	if err != nil {
+		return nil, errors.New(
+			"Error on read current repository spec: " + err.Error())
+	}
+
+	index := compiler.ArtifactIndex{}
+	for _, m := range sortedMetaKeys(c.MetaMap) {
+		art := c.MetaMap[m]
+		tarball := filepath.Base(art.Path)
+
+		if _, ok := c.PkgsMap[tarball]; !ok {
+			DebugC(fmt.Sprintf("[%s] No tarball available. Ignoring it.", m))
+			continue
+		}
+
+		if c.FilterByTrees && toRemove[m] {
+			DebugC(fmt.Sprintf("[%s] Not available in the trees. Ignoring it.", m))
+			continue
+		}
+
+		index = append(index, art)
+	}
+
+	repo.SetIndex(index)
+	repo.IncrementRevision()
+	repo.SetLastUpdate(strconv.FormatInt(time.Now().Unix(), 10))
+
+	if c.DryRun {
+		InfoC(fmt.Sprintf(
+			"Repository %s: could be created revision %d with %d packages.",
+			repo.GetName(), repo.GetRevision(), len(index)))
+		return repo, nil
+	}
+
+	InfoC(fmt.Sprintf(
+		"Repository %s: creating revision %d and last update %s with %d packages...",
+		repo.GetName(), repo.GetRevision(), repo.GetLastUpdate(), len(index)))
+
+	outdir := filepath.Join(tmpdir, "out")
+	err = os.MkdirAll(outdir, os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+
+	repospec := filepath.Join(outdir, luet_installer.REPOSITORY_SPECFILE)
+	a, err := repo.AddMetadata(repospec, outdir)
+	if err != nil {
+		return nil, errors.New(
+			"Error on create repository metadata: " + err.Error())
+	}
+
+	// The metadata is uploaded before the repository spec that
+	// contains the new checksum.
+	metafile := filepath.Base(a.Path)
+	err = c.BackendHandler.UploadFile(a.Path, metafile)
+	if err != nil {
+		return nil, errors.New(
+			fmt.Sprintf("Error on upload file %s: %s", metafile, err.Error()))
+	}
+	InfoC(fmt.Sprintf("[%s] Uploaded.", metafile))
+
+	err = c.BackendHandler.UploadFile(repospec, luet_installer.REPOSITORY_SPECFILE)
+	if err != nil {
+		return nil, errors.New(
+			fmt.Sprintf("Error on upload file %s: %s",
+				luet_installer.REPOSITORY_SPECFILE, err.Error()))
+	}
+	InfoC(fmt.Sprintf("[%s] Uploaded.", luet_installer.REPOSITORY_SPECFILE))
+
+	return repo, nil
+}
@@ -0,0 +1,142 @@
+/*
+Copyright (C) 2020-2021  Daniele Rondina <geaaru@sabayonlinux.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+*/
+
+package devkit_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/Luet-lab/extensions/extensions/repo-devkit/pkg/devkit"
+	specs "github.com/Luet-lab/extensions/extensions/repo-devkit/pkg/specs"
+	config "github.com/mudler/luet/pkg/config"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// uploadsBackend records the uploaded files.
+type uploadsBackend struct {
+	specs.RepoBackendHandler
+	uploads []string
+}
+
+func (b *uploadsBackend) UploadFile(src, dst string) error {
+	b.uploads = append(b.uploads, dst)
+	return b.RepoBackendHandler.UploadFile(src, dst)
+}
+
+var _ = Describe("Reindex", func() {
+	var repoDir, luetDir string
+
+	writeFile := func(name, content string) {
+		Expect(ioutil.WriteFile(filepath.Join(repoDir, name),
+			[]byte(content), 0644)).To(Succeed())
+	}
+
+	newReindexer := func() (*RepoReindexer, *uploadsBackend) {
+		r, err := NewRepoReindexer(specs.NewLuetRDConfig(), "local", repoDir,
+			map[string]string{}, false)
+		Expect(err).ToNot(HaveOccurred())
+		backend := &uploadsBackend{RepoBackendHandler: r.BackendHandler}
+		r.BackendHandler = backend
+		return r, backend
+	}
+
+	BeforeEach(func() {
+		var err error
+		repoDir, err = ioutil.TempDir("", "repo-devkit-reindex")
+		Expect(err).ToNot(HaveOccurred())
+		// Used by luet to create the repository metadata.
+		luetDir, err = ioutil.TempDir("", "repo-devkit-luet")
+		Expect(err).ToNot(HaveOccurred())
+		config.LuetCfg.GetSystem().TmpDirBase = luetDir
+
+		writeFile("repository.yaml", "name: test\nrevision: 3\n")
+		writeFile("foo-app-1.0.metadata.yaml", packageMetadata("foo"))
+		writeFile("foo-app-1.0.package.tar", "tarball")
+		// Metadata without tarball and tarball without metadata.
+		writeFile("bar-app-1.0.metadata.yaml", packageMetadata("bar"))
+		writeFile("baz-app-1.0.package.tar", "tarball")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(repoDir)
+		os.RemoveAll(luetDir)
+	})
+
+	It("Uploads the metadata before the repository spec", func() {
+		r, backend := newReindexer()
+		repo, err := r.Run()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(repo.GetRevision()).To(Equal(4))
+
+		Expect(backend.uploads).To(HaveLen(2))
+		Expect(strings.HasPrefix(backend.uploads[0], "repository.meta.yaml")).To(BeTrue())
+		Expect(backend.uploads[1]).To(Equal("repository.yaml"))
+
+		// The index contains only the packages with metadata
+		// and tarball.
+		tmpdir, err := ioutil.TempDir("", "repo-devkit-reindex-check")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(tmpdir)
+
+		spec, err := r.ReadRepositorySpec(tmpdir)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(spec.GetRevision()).To(Equal(4))
+		rf, err := spec.GetRepositoryFile("meta")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rf.GetFileName()).To(Equal(backend.uploads[0]))
+
+		meta, err := r.ReadRepositoryMeta(rf, tmpdir)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(meta.Index).To(HaveLen(1))
+		Expect(meta.Index[0].CompileSpec.Package.GetName()).To(Equal("foo"))
+	})
+
+	It("Doesn't upload on dry run", func() {
+		r, backend := newReindexer()
+		r.DryRun = true
+		repo, err := r.Run()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(repo.GetRevision()).To(Equal(4))
+		Expect(backend.uploads).To(BeEmpty())
+	})
+
+	It("Fails on corrupted metadata", func() {
+		writeFile("foo-app-1.0.metadata.yaml", "compilespec: [corrupted")
+
+		r, backend := newReindexer()
+		_, err := r.Run()
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("foo-app-1.0.metadata.yaml"))
+		Expect(backend.uploads).To(BeEmpty())
+	})
+
+	It("Fails without repository spec", func() {
+		Expect(os.Remove(filepath.Join(repoDir, "repository.yaml"))).To(Succeed())
+
+		r, backend := newReindexer()
+		_, err := r.Run()
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("repository spec"))
+		Expect(backend.uploads).To(BeEmpty())
+	})
+})
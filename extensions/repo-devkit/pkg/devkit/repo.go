@@ -19,10 +19,14 @@ along with this program. If not, see <http://www.gnu.org/licenses/>.
 package devkit
 
 import (
+	"crypto/sha256"
 	"errors"
 	"fmt"
 	"path/filepath"
 	"regexp"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/Luet-lab/extensions/extensions/repo-devkit/pkg/backends"
 	specs "github.com/Luet-lab/extensions/extensions/repo-devkit/pkg/specs"
@@ -38,12 +42,29 @@ type RepoKnife struct {
 	Specs          *specs.LuetRDConfig
 	BackendHandler specs.RepoBackendHandler
 	ReciperRuntime luet_tree.Builder
+	Backend        string
+	Target         string
 
 	PkgsMap        map[string]string
 	MetaMap        map[string]*artifact.PackageArtifact
+	Files          []string
 	Files2Remove   []string
 	Verbose        bool
 	ProcessedFiles int
+
+	// Files that aren't package tarballs, metadata or repository files.
+	UnknownFiles []string
+	// Skip the check of the artefacts with the loaded trees.
+	SkipTreesCheck bool
+
+	// Number of parallel metadata downloads.
+	Concurrency int
+	// Number of retries on metadata download errors. The backends
+	// don't retry the metadata downloads.
+	Retries int
+	// Wait before the first retry, it's doubled on every retry.
+	RetryDelay time.Duration
+	Cache      *MetadataCache
 }
 
 func NewRepoKnife(s *specs.LuetRDConfig,
@@ -56,15 +77,31 @@ func NewRepoKnife(s *specs.LuetRDConfig,
 		ReciperRuntime: luet_tree.NewInstallerRecipe(luet_pkg.NewInMemoryDatabase(false)),
 		PkgsMap:        make(map[string]string, 0),
 		MetaMap:        make(map[string]*artifact.PackageArtifact, 0),
+		Backend:        backend,
+		Concurrency:    1,
+		Retries:        3,
+		RetryDelay:     500 * time.Millisecond,
 	}
 
 	switch backend {
 	case "local":
 		handler, err = backends.NewBackendLocal(s, path)
+		ans.Target = path
 	case "mottainai":
 		handler, err = backends.NewBackendMottainai(s, path, opts)
+		ans.Target = opts["mottainai-namespace"]
 	case "minio":
 		handler, err = backends.NewBackendMinio(s, path, opts)
+		ans.Target = fmt.Sprintf("%s/%s", opts["minio-endpoint"], opts["minio-bucket"])
+		if prefix := backends.GetMinioPrefix(opts); prefix != "" {
+			ans.Target += "/" + strings.TrimSuffix(prefix, "/")
+		}
+	case "blob":
+		handler, err = backends.NewBackendBlob(s, path, opts)
+		ans.Target = opts["blob-url"]
+		if prefix := backends.GetBlobPrefix(path, opts); prefix != "" {
+			ans.Target += "/" + strings.TrimSuffix(prefix, "/")
+		}
 	default:
 		return nil, errors.New("Invalid backend")
 	}
@@ -94,18 +131,45 @@ func (c *RepoKnife) LoadTrees(treePath []string) error {
 	return nil
 }
 
-func (c *RepoKnife) Analyze() error {
+// SetupCache enables the metadata cache. Every backend target
+// uses a different subdirectory of dir. The mottainai backend
+// doesn't expose the files informations used as cache key, so
+// the cache isn't supported and stays disabled.
+func (c *RepoKnife) SetupCache(dir string) error {
+	if c.Backend == "mottainai" {
+		Warning("The metadata cache isn't supported by the mottainai backend.")
+		return nil
+	}
 
-	// Reset previous values
-	c.PkgsMap = make(map[string]string, 0)
-	c.MetaMap = make(map[string]*artifact.PackageArtifact, 0)
-	c.Files2Remove = []string{}
+	cache, err := NewMetadataCache(filepath.Join(dir,
+		fmt.Sprintf("%x", sha256.Sum256([]byte(c.Backend+"|"+c.Target))),
+	))
+	if err != nil {
+		return errors.New("Error on setup metadata cache: " + err.Error())
+	}
+	c.Cache = cache
+	return nil
+}
 
+func (c *RepoKnife) Analyze() error {
 	// Retrieve the list of the files
 	files, err := c.BackendHandler.GetFilesList()
 	if err != nil {
 		return err
 	}
+
+	return c.AnalyzeFiles(files)
+}
+
+// AnalyzeFiles analyzes the files list retrieved from the backend.
+func (c *RepoKnife) AnalyzeFiles(files []string) error {
+	var err error
+
+	// Reset previous values
+	c.PkgsMap = make(map[string]string, 0)
+	c.MetaMap = make(map[string]*artifact.PackageArtifact, 0)
+	c.Files2Remove = []string{}
+	c.UnknownFiles = []string{}
 	c.ProcessedFiles = len(files)
 
 	if c.Specs.GetCleaner().HasExcludes() {
@@ -114,6 +178,7 @@ func (c *RepoKnife) Analyze() error {
 			return err
 		}
 	}
+	c.Files = files
 
 	// Exclude repository files
 	repoRegex := []string{
@@ -121,6 +186,8 @@ func (c *RepoKnife) Analyze() error {
 		"repository.yaml",
 		"tree.tar.*|tree.tar",
 		"compilertree.tar.*|compilertree.tar",
+		// Exclude files moved in the trash area
+		"^" + regexp.QuoteMeta(c.Specs.GetCleaner().GetTrashDir()) + "/",
 	}
 
 	metaFilesRegex := []string{
@@ -131,6 +198,8 @@ func (c *RepoKnife) Analyze() error {
 		".*package.tar|.*package.tar.*",
 	}
 
+	metaFiles := []string{}
+
 	for _, f := range files {
 		if tmtools.RegexEntry(f, repoRegex) {
 			DebugC(fmt.Sprintf("Ignoring repository file %s", f))
@@ -144,13 +213,7 @@ func (c *RepoKnife) Analyze() error {
 		}
 
 		if tmtools.RegexEntry(f, metaFilesRegex) {
-
-			art, err := c.BackendHandler.GetMetadata(f)
-			if err != nil {
-				return err
-			}
-
-			c.MetaMap[f] = art
+			metaFiles = append(metaFiles, f)
 		} else if tmtools.RegexEntry(f, pkgFilesRegex) {
 
 			replaceRegex := regexp.MustCompile(
@@ -163,9 +226,15 @@ func (c *RepoKnife) Analyze() error {
 		} else {
 			// POST: file to remove
 			c.Files2Remove = append(c.Files2Remove, f)
+			c.UnknownFiles = append(c.UnknownFiles, f)
 		}
 	}
 
+	err = c.fetchMetadata(metaFiles)
+	if err != nil {
+		return err
+	}
+
 	// Check if there are all package for every metafile
 	meta2Remove := []string{}
 	for f, art := range c.MetaMap {
@@ -206,15 +275,146 @@ func (c *RepoKnife) Analyze() error {
 		}
 	}
 
-	//
-	err = c.CheckFilesWithTrees()
-	if err != nil {
-		return err
+	if !c.SkipTreesCheck {
+		err = c.CheckFilesWithTrees()
+		if err != nil {
+			return err
+		}
 	}
 
+	c.normalizeFiles2Remove()
+
 	return nil
 }
 
+// normalizeFiles2Remove drops the duplicated entries of Files2Remove
+// and the files not available in the files list. A metadata file
+// could be selected more times and the tarball of a metadata file
+// could be missing.
+func (c *RepoKnife) normalizeFiles2Remove() {
+	available := make(map[string]bool, len(c.Files))
+	for _, f := range c.Files {
+		available[f] = true
+	}
+
+	seen := make(map[string]bool, len(c.Files2Remove))
+	ans := []string{}
+	for _, f := range c.Files2Remove {
+		if seen[f] {
+			continue
+		}
+		seen[f] = true
+
+		if !available[f] {
+			DebugC(fmt.Sprintf("[%s] Not available in the repository. Skipped.", f))
+			continue
+		}
+		ans = append(ans, f)
+	}
+
+	c.Files2Remove = ans
+}
+
+func (c *RepoKnife) fetchMetadata(files []string) error {
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	var fetchErr error
+
+	concurrency := c.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	ch := make(chan string, len(files))
+	for _, f := range files {
+		ch <- f
+	}
+	close(ch)
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for f := range ch {
+				art, err := c.getMetadata(f)
+
+				mutex.Lock()
+				if err != nil {
+					if fetchErr == nil {
+						fetchErr = err
+					}
+				} else {
+					c.MetaMap[f] = art
+				}
+				mutex.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if c.Cache != nil && fetchErr == nil {
+		mFiles := make(map[string]bool, len(files))
+		for _, f := range files {
+			mFiles[f] = true
+		}
+		c.Cache.Prune(mFiles)
+
+		if err := c.Cache.Write(); err != nil {
+			Warning("Error on write metadata cache: " + err.Error())
+		}
+	}
+
+	return fetchErr
+}
+
+func (c *RepoKnife) getMetadata(f string) (*artifact.PackageArtifact, error) {
+	var info *specs.RepoFileInfo
+	var err error
+
+	if c.Cache != nil {
+		info, err = c.BackendHandler.GetFileInfo(f)
+		if err != nil {
+			DebugC(fmt.Sprintf("[%s] Error on retrieve file info: %s", f, err.Error()))
+		} else if info != nil {
+			if art := c.Cache.Get(f, info.CacheKey()); art != nil {
+				DebugC(fmt.Sprintf("[%s] Metadata retrieved from cache.", f))
+				return art, nil
+			}
+		}
+	}
+
+	DebugC(fmt.Sprintf("[%s] Fetching metadata...", f))
+
+	art, err := c.BackendHandler.GetMetadata(f)
+	for i := 0; err != nil && i < c.Retries && !isPermanentError(err); i++ {
+		wait := c.RetryDelay * time.Duration(1<<uint(i))
+		Warning(fmt.Sprintf("[%s] Error on fetch metadata: %s. Retry in %s.",
+			f, err.Error(), wait))
+		time.Sleep(wait)
+		art, err = c.BackendHandler.GetMetadata(f)
+	}
+	if err != nil {
+		return nil, errors.New(
+			fmt.Sprintf("Error on fetch metadata %s: %s", f, err.Error()))
+	}
+
+	if c.Cache != nil && info != nil {
+		if err := c.Cache.Set(f, info.CacheKey(), art); err != nil {
+			DebugC(fmt.Sprintf("[%s] Error on store metadata in cache: %s", f, err.Error()))
+		}
+	}
+
+	return art, nil
+}
+
+// isPermanentError returns true if the error reports that the
+// request can't succeed on retry (e.g. a missing file).
+func isPermanentError(err error) bool {
+	var t interface{ Temporary() bool }
+	return errors.As(err, &t) && !t.Temporary()
+}
+
 func (c *RepoKnife) CheckFilesWithTrees() error {
 
 	for m, art := range c.MetaMap {
@@ -228,7 +428,10 @@ func (c *RepoKnife) CheckFilesWithTrees() error {
 		pkg.Category = art.CompileSpec.Package.Category
 
 		p, _ := c.ReciperRuntime.GetDatabase().FindPackage(pkg)
-		if p == nil {
+		if p == nil && c.Specs.GetCleaner().ToKeep(art.CompileSpec.Package) {
+			DebugC(fmt.Sprintf("[%s] Excluded by cleaner filters.",
+				pkg.HumanReadableString()))
+		} else if p == nil {
 
 			pkgFile := filepath.Base(art.Path)
 
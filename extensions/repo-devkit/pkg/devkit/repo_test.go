@@ -0,0 +1,257 @@
+/*
+Copyright (C) 2020-2021  Daniele Rondina <geaaru@sabayonlinux.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+*/
+
+package devkit_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	. "github.com/Luet-lab/extensions/extensions/repo-devkit/pkg/devkit"
+	specs "github.com/Luet-lab/extensions/extensions/repo-devkit/pkg/specs"
+	artifact "github.com/mudler/luet/pkg/compiler/types/artifact"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// metadataError is a metadata download error that could be
+// temporary.
+type metadataError struct {
+	temporary bool
+}
+
+func (e *metadataError) Error() string   { return "download failed" }
+func (e *metadataError) Temporary() bool { return e.temporary }
+
+// metadataBackend counts the metadata downloads and fails the
+// first downloads of the files in failures.
+type metadataBackend struct {
+	specs.RepoBackendHandler
+	delays   map[string]time.Duration
+	failures map[string]int
+	err      error
+
+	mutex sync.Mutex
+	calls map[string]int
+}
+
+func newMetadataBackend(b specs.RepoBackendHandler) *metadataBackend {
+	return &metadataBackend{
+		RepoBackendHandler: b,
+		delays:             map[string]time.Duration{},
+		failures:           map[string]int{},
+		err:                &metadataError{temporary: true},
+		calls:              map[string]int{},
+	}
+}
+
+func (b *metadataBackend) GetMetadata(f string) (*artifact.PackageArtifact, error) {
+	b.mutex.Lock()
+	b.calls[f]++
+	fail := b.failures[f] > 0
+	if fail {
+		b.failures[f]--
+	}
+	b.mutex.Unlock()
+
+	time.Sleep(b.delays[f])
+	if fail {
+		return nil, b.err
+	}
+	return b.RepoBackendHandler.GetMetadata(f)
+}
+
+func (b *metadataBackend) totalCalls() int {
+	ans := 0
+	for _, n := range b.calls {
+		ans += n
+	}
+	return ans
+}
+
+func packageMetadata(name string) string {
+	return fmt.Sprintf(`path: /repo/%s-app-1.0.package.tar
+compilespec:
+  package:
+    name: %s
+    version: "1.0"
+    category: app
+compressiontype: none
+`, name, name)
+}
+
+var _ = Describe("RepoKnife", func() {
+	var repoDir, cacheDir string
+	var names = []string{"a", "b", "c", "d", "e", "f"}
+
+	writeMetadata := func(name, content string) {
+		Expect(ioutil.WriteFile(
+			filepath.Join(repoDir, name+"-app-1.0.metadata.yaml"),
+			[]byte(content), 0644)).To(Succeed())
+	}
+
+	newKnife := func() (*RepoKnife, *metadataBackend) {
+		knife, err := NewRepoKnife(specs.NewLuetRDConfig(), "local", repoDir,
+			map[string]string{})
+		Expect(err).ToNot(HaveOccurred())
+		backend := newMetadataBackend(knife.BackendHandler)
+		knife.BackendHandler = backend
+		knife.Concurrency = 3
+		knife.RetryDelay = time.Millisecond
+		return knife, backend
+	}
+
+	BeforeEach(func() {
+		var err error
+		repoDir, err = ioutil.TempDir("", "repo-devkit-knife")
+		Expect(err).ToNot(HaveOccurred())
+		cacheDir, err = ioutil.TempDir("", "repo-devkit-cache")
+		Expect(err).ToNot(HaveOccurred())
+
+		for _, name := range names {
+			writeMetadata(name, packageMetadata(name))
+		}
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(repoDir)
+		os.RemoveAll(cacheDir)
+	})
+
+	Context("Metadata downloads", func() {
+
+		It("Maps every metadata file to its artefact", func() {
+			knife, backend := newKnife()
+			// The first files complete last.
+			for i, name := range names {
+				backend.delays[name+"-app-1.0.metadata.yaml"] =
+					time.Duration(len(names)-i) * 5 * time.Millisecond
+			}
+
+			Expect(knife.Analyze()).To(Succeed())
+			Expect(knife.MetaMap).To(HaveLen(len(names)))
+			for _, name := range names {
+				art := knife.MetaMap[name+"-app-1.0.metadata.yaml"]
+				Expect(art).ToNot(BeNil())
+				Expect(art.CompileSpec.Package.GetName()).To(Equal(name))
+			}
+			Expect(backend.totalCalls()).To(Equal(len(names)))
+		})
+
+		It("Returns the download error", func() {
+			knife, backend := newKnife()
+			knife.Retries = 0
+			backend.failures["c-app-1.0.metadata.yaml"] = 1
+
+			err := knife.Analyze()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("c-app-1.0.metadata.yaml"))
+			Expect(err.Error()).To(ContainSubstring("download failed"))
+		})
+
+		It("Retries the temporary errors", func() {
+			knife, backend := newKnife()
+			knife.Retries = 2
+			backend.failures["c-app-1.0.metadata.yaml"] = 2
+
+			Expect(knife.Analyze()).To(Succeed())
+			Expect(knife.MetaMap).To(HaveKey("c-app-1.0.metadata.yaml"))
+			Expect(backend.calls["c-app-1.0.metadata.yaml"]).To(Equal(3))
+		})
+
+		It("Fails when the retries are exhausted", func() {
+			knife, backend := newKnife()
+			knife.Retries = 2
+			backend.failures["c-app-1.0.metadata.yaml"] = 3
+
+			Expect(knife.Analyze()).ToNot(Succeed())
+			Expect(backend.calls["c-app-1.0.metadata.yaml"]).To(Equal(3))
+		})
+
+		It("Doesn't retry the permanent errors", func() {
+			knife, backend := newKnife()
+			knife.Retries = 2
+			backend.err = &metadataError{temporary: false}
+			backend.failures["c-app-1.0.metadata.yaml"] = 1
+
+			Expect(knife.Analyze()).ToNot(Succeed())
+			Expect(backend.calls["c-app-1.0.metadata.yaml"]).To(Equal(1))
+		})
+	})
+
+	Context("Metadata cache", func() {
+
+		It("Reuses the cached metadata", func() {
+			knife, backend := newKnife()
+			Expect(knife.SetupCache(cacheDir)).To(Succeed())
+			Expect(knife.Analyze()).To(Succeed())
+			Expect(backend.totalCalls()).To(Equal(len(names)))
+
+			knife, backend = newKnife()
+			Expect(knife.SetupCache(cacheDir)).To(Succeed())
+			Expect(knife.Analyze()).To(Succeed())
+			Expect(backend.totalCalls()).To(Equal(0))
+			Expect(knife.MetaMap).To(HaveLen(len(names)))
+			Expect(knife.MetaMap["c-app-1.0.metadata.yaml"].CompileSpec.Package.GetName()).To(Equal("c"))
+		})
+
+		It("Fetches again the changed files", func() {
+			knife, _ := newKnife()
+			Expect(knife.SetupCache(cacheDir)).To(Succeed())
+			Expect(knife.Analyze()).To(Succeed())
+
+			writeMetadata("c", packageMetadata("c")+"# changed\n")
+
+			knife, backend := newKnife()
+			Expect(knife.SetupCache(cacheDir)).To(Succeed())
+			Expect(knife.Analyze()).To(Succeed())
+			Expect(backend.calls).To(Equal(map[string]int{"c-app-1.0.metadata.yaml": 1}))
+		})
+
+		It("Drops the removed files", func() {
+			knife, _ := newKnife()
+			Expect(knife.SetupCache(cacheDir)).To(Succeed())
+			Expect(knife.Analyze()).To(Succeed())
+			Expect(knife.Cache.Entries).To(HaveKey("c-app-1.0.metadata.yaml"))
+
+			Expect(os.Remove(filepath.Join(repoDir, "c-app-1.0.metadata.yaml"))).To(Succeed())
+
+			knife, _ = newKnife()
+			Expect(knife.SetupCache(cacheDir)).To(Succeed())
+			Expect(knife.Analyze()).To(Succeed())
+			Expect(knife.Cache.Entries).To(HaveLen(len(names) - 1))
+			Expect(knife.Cache.Entries).ToNot(HaveKey("c-app-1.0.metadata.yaml"))
+
+			// The index is written on disk.
+			cache, err := NewMetadataCache(knife.Cache.Dir)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cache.Entries).To(Equal(knife.Cache.Entries))
+		})
+
+		It("Isn't supported by the mottainai backend", func() {
+			knife := &RepoKnife{Backend: "mottainai", Target: "test"}
+			Expect(knife.SetupCache(cacheDir)).To(Succeed())
+			Expect(knife.Cache).To(BeNil())
+		})
+	})
+})
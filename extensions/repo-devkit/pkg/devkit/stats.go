@@ -0,0 +1,301 @@
+/*
+Copyright (C) 2020-2021  Daniele Rondina <geaaru@sabayonlinux.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+*/
+
+package devkit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	specs "github.com/Luet-lab/extensions/extensions/repo-devkit/pkg/specs"
+
+	. "github.com/mudler/luet/pkg/logger"
+)
+
+type RepoStats struct {
+	*RepoKnife
+
+	// If true the orphan files are computed with the trees loaded.
+	CheckOrphans bool
+}
+
+type CategoryStats struct {
+	Category string `json:"category"`
+	Packages int    `json:"packages"`
+	Versions int    `json:"versions"`
+	Files    int    `json:"files"`
+	Size     int64  `json:"size"`
+}
+
+type PackageSize struct {
+	Package string `json:"package"`
+	Version string `json:"version"`
+	Size    int64  `json:"size"`
+}
+
+type PackageVersionsCount struct {
+	Package  string   `json:"package"`
+	Versions []string `json:"versions"`
+	Size     int64    `json:"size"`
+}
+
+type OrphansStats struct {
+	Files int   `json:"files"`
+	Size  int64 `json:"size"`
+}
+
+type CategoryGrowth struct {
+	Category      string `json:"category"`
+	SizeDelta     int64  `json:"size_delta"`
+	VersionsDelta int    `json:"versions_delta"`
+}
+
+type StatsGrowth struct {
+	Since      time.Time        `json:"since"`
+	SizeDelta  int64            `json:"size_delta"`
+	FilesDelta int              `json:"files_delta"`
+	Categories []CategoryGrowth `json:"categories"`
+}
+
+type StatsReport struct {
+	Time    time.Time `json:"time"`
+	Backend string    `json:"backend"`
+	Target  string    `json:"target"`
+
+	TotalFiles int   `json:"total_files"`
+	TotalSize  int64 `json:"total_size"`
+	// Number of files without size informations from the backend.
+	UnknownSizeFiles int   `json:"unknown_size_files"`
+	TrashFiles       int   `json:"trash_files"`
+	TrashSize        int64 `json:"trash_size"`
+
+	Categories []CategoryStats        `json:"categories"`
+	Packages   []PackageVersionsCount `json:"packages"`
+	Largest    []PackageSize          `json:"largest"`
+	Orphans    *OrphansStats          `json:"orphans,omitempty"`
+	Growth     *StatsGrowth           `json:"growth,omitempty"`
+}
+
+func NewRepoStats(s *specs.LuetRDConfig,
+	backend, path string, opts map[string]string) (*RepoStats, error) {
+
+	knife, err := NewRepoKnife(s, backend, path, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RepoStats{RepoKnife: knife}, nil
+}
+
+func (c *RepoStats) Run() (*StatsReport, error) {
+	ans := &StatsReport{
+		Time:       time.Now().UTC(),
+		Backend:    c.Backend,
+		Target:     c.Target,
+		Categories: []CategoryStats{},
+		Packages:   []PackageVersionsCount{},
+		Largest:    []PackageSize{},
+	}
+
+	err := c.RepoKnife.Analyze()
+	if err != nil {
+		return nil, err
+	}
+
+	sizes := make(map[string]int64, 0)
+	trashPrefix := c.Specs.GetCleaner().GetTrashDir() + "/"
+
+	for _, f := range c.Files {
+		info, err := c.BackendHandler.GetFileInfo(f)
+		if err != nil {
+			return nil, errors.New(
+				fmt.Sprintf("Error on retrieve informations of the file %s: %s",
+					f, err.Error()))
+		}
+
+		ans.TotalFiles++
+		if info == nil {
+			ans.UnknownSizeFiles++
+			continue
+		}
+
+		sizes[f] = info.Size
+		ans.TotalSize += info.Size
+
+		if strings.HasPrefix(f, trashPrefix) {
+			ans.TrashFiles++
+			ans.TrashSize += info.Size
+		}
+	}
+
+	if ans.UnknownSizeFiles > 0 {
+		Warning(fmt.Sprintf("Size not available for %d files.", ans.UnknownSizeFiles))
+	}
+
+	// Sizes of the packages
+	categories := make(map[string]*CategoryStats, 0)
+	tarballs := make(map[string]string, 0)
+	for pkg := range c.PkgsMap {
+		tarballs[filepath.Base(pkg)] = pkg
+	}
+
+	groups := c.GroupByPackage()
+	for _, key := range sortedGroupKeys(groups) {
+		versions := groups[key]
+		pvc := PackageVersionsCount{
+			Package:  key,
+			Versions: sortedVersions(versions),
+		}
+
+		for _, v := range pvc.Versions {
+			art := versions[v]
+			p := art.CompileSpec.Package
+
+			cs, ok := categories[p.GetCategory()]
+			if !ok {
+				cs = &CategoryStats{Category: p.GetCategory()}
+				categories[p.GetCategory()] = cs
+			}
+
+			size := int64(0)
+			if pkg, ok := tarballs[filepath.Base(art.Path)]; ok {
+				size += sizes[pkg] + sizes[c.PkgsMap[pkg]]
+				cs.Files += 2
+			}
+
+			cs.Versions++
+			cs.Size += size
+			pvc.Size += size
+
+			ans.Largest = append(ans.Largest, PackageSize{
+				Package: key,
+				Version: v,
+				Size:    size,
+			})
+		}
+
+		categories[strings.Split(key, "/")[0]].Packages++
+		ans.Packages = append(ans.Packages, pvc)
+	}
+
+	for _, cs := range categories {
+		ans.Categories = append(ans.Categories, *cs)
+	}
+	sort.Slice(ans.Categories, func(i, j int) bool {
+		if ans.Categories[i].Size != ans.Categories[j].Size {
+			return ans.Categories[i].Size > ans.Categories[j].Size
+		}
+		return ans.Categories[i].Category < ans.Categories[j].Category
+	})
+
+	sort.SliceStable(ans.Largest, func(i, j int) bool {
+		return ans.Largest[i].Size > ans.Largest[j].Size
+	})
+
+	sort.SliceStable(ans.Packages, func(i, j int) bool {
+		return len(ans.Packages[i].Versions) > len(ans.Packages[j].Versions)
+	})
+
+	if c.CheckOrphans {
+		ans.Orphans = &OrphansStats{}
+		// A file could be selected more times by the analysis.
+		orphans := make(map[string]bool, len(c.Files2Remove))
+		for _, f := range c.Files2Remove {
+			orphans[f] = true
+		}
+		for f := range orphans {
+			ans.Orphans.Files++
+			ans.Orphans.Size += sizes[f]
+		}
+	}
+
+	return ans, nil
+}
+
+// Compare sets the growth of the report compared to a previous report.
+func (r *StatsReport) Compare(prev *StatsReport) {
+	r.Growth = &StatsGrowth{
+		Since:      prev.Time,
+		SizeDelta:  r.TotalSize - prev.TotalSize,
+		FilesDelta: r.TotalFiles - prev.TotalFiles,
+		Categories: []CategoryGrowth{},
+	}
+
+	prevCategories := make(map[string]CategoryStats, 0)
+	for _, cs := range prev.Categories {
+		prevCategories[cs.Category] = cs
+	}
+
+	for _, cs := range r.Categories {
+		p := prevCategories[cs.Category]
+		delete(prevCategories, cs.Category)
+
+		if cs.Size != p.Size || cs.Versions != p.Versions {
+			r.Growth.Categories = append(r.Growth.Categories, CategoryGrowth{
+				Category:      cs.Category,
+				SizeDelta:     cs.Size - p.Size,
+				VersionsDelta: cs.Versions - p.Versions,
+			})
+		}
+	}
+
+	// Categories no more available
+	for _, p := range prevCategories {
+		r.Growth.Categories = append(r.Growth.Categories, CategoryGrowth{
+			Category:      p.Category,
+			SizeDelta:     -p.Size,
+			VersionsDelta: -p.Versions,
+		})
+	}
+
+	sort.Slice(r.Growth.Categories, func(i, j int) bool {
+		return r.Growth.Categories[i].Category < r.Growth.Categories[j].Category
+	})
+}
+
+// Write writes the report in JSON format to use it
+// as snapshot for a next comparison.
+func (r *StatsReport) Write(file string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(file, data, 0644)
+}
+
+func LoadStatsReport(file string) (*StatsReport, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	ans := &StatsReport{}
+	if err := json.Unmarshal(data, ans); err != nil {
+		return nil, errors.New(
+			fmt.Sprintf("Error on parse snapshot %s: %s", file, err.Error()))
+	}
+
+	return ans, nil
+}
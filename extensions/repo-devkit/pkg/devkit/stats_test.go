@@ -0,0 +1,67 @@
+/*
+Copyright (C) 2020-2021  Daniele Rondina <geaaru@sabayonlinux.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+*/
+
+package devkit_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/Luet-lab/extensions/extensions/repo-devkit/pkg/devkit"
+	specs "github.com/Luet-lab/extensions/extensions/repo-devkit/pkg/specs"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Stats", func() {
+	var repoDir string
+
+	BeforeEach(func() {
+		var err error
+		repoDir, err = ioutil.TempDir("", "repo-devkit-stats")
+		Expect(err).ToNot(HaveOccurred())
+
+		for name, content := range map[string]string{
+			"foo-app-1.0.metadata.yaml": orphanMetadata,
+			"bar-app-1.0.package.tar":   "tarball",
+			"stale.txt":                 "stale",
+		} {
+			Expect(ioutil.WriteFile(filepath.Join(repoDir, name),
+				[]byte(content), 0644)).To(Succeed())
+		}
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(repoDir)
+	})
+
+	It("Counts every orphan once", func() {
+		s, err := NewRepoStats(specs.NewLuetRDConfig(), "local", repoDir,
+			map[string]string{})
+		Expect(err).ToNot(HaveOccurred())
+		s.CheckOrphans = true
+
+		report, err := s.Run()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(report.Orphans.Files).To(Equal(3))
+		Expect(report.Orphans.Size).To(Equal(
+			int64(len(orphanMetadata) + len("tarball") + len("stale"))))
+	})
+})
@@ -0,0 +1,407 @@
+/*
+Copyright (C) 2020-2021  Daniele Rondina <geaaru@sabayonlinux.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+*/
+
+package devkit
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	specs "github.com/Luet-lab/extensions/extensions/repo-devkit/pkg/specs"
+
+	artifact "github.com/mudler/luet/pkg/compiler/types/artifact"
+	luet_installer "github.com/mudler/luet/pkg/installer"
+	. "github.com/mudler/luet/pkg/logger"
+	luet_pkg "github.com/mudler/luet/pkg/package"
+)
+
+const (
+	VerifyIssueOrphan        = "orphan"
+	VerifyIssueCorrupted     = "corrupted"
+	VerifyIssueIndexMismatch = "index-mismatch"
+)
+
+type RepoVerifier struct {
+	*RepoKnife
+	CheckTarballs bool
+}
+
+type VerifyIssue struct {
+	Type    string `json:"type"`
+	File    string `json:"file"`
+	Package string `json:"package,omitempty"`
+	Message string `json:"message"`
+}
+
+type VerifyReport struct {
+	ProcessedFiles  int           `json:"processed_files"`
+	Packages        int           `json:"packages"`
+	Orphans         []VerifyIssue `json:"orphans"`
+	Corrupted       []VerifyIssue `json:"corrupted"`
+	IndexMismatches []VerifyIssue `json:"index_mismatches"`
+}
+
+func NewRepoVerifier(s *specs.LuetRDConfig,
+	backend, path string, opts map[string]string,
+	checkTarballs bool) (*RepoVerifier, error) {
+
+	knife, err := NewRepoKnife(s, backend, path, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	ans := &RepoVerifier{
+		RepoKnife:     knife,
+		CheckTarballs: checkTarballs,
+	}
+
+	return ans, nil
+}
+
+func NewVerifyReport() *VerifyReport {
+	return &VerifyReport{
+		Orphans:         []VerifyIssue{},
+		Corrupted:       []VerifyIssue{},
+		IndexMismatches: []VerifyIssue{},
+	}
+}
+
+func (r *VerifyReport) IsConsistent() bool {
+	return len(r.Orphans) == 0 && len(r.Corrupted) == 0 &&
+		len(r.IndexMismatches) == 0
+}
+
+func (r *VerifyReport) add(t, file, pkg, msg string) {
+	issue := VerifyIssue{
+		Type:    t,
+		File:    file,
+		Package: pkg,
+		Message: msg,
+	}
+	switch t {
+	case VerifyIssueOrphan:
+		r.Orphans = append(r.Orphans, issue)
+	case VerifyIssueCorrupted:
+		r.Corrupted = append(r.Corrupted, issue)
+	default:
+		r.IndexMismatches = append(r.IndexMismatches, issue)
+	}
+}
+
+func (c *RepoVerifier) Run() (*VerifyReport, error) {
+	report := NewVerifyReport()
+
+	files, err := c.BackendHandler.GetFilesList()
+	if err != nil {
+		return nil, err
+	}
+	mFiles := make(map[string]bool, len(files))
+	for _, f := range files {
+		mFiles[f] = true
+	}
+
+	err = c.RepoKnife.AnalyzeFiles(files)
+	if err != nil {
+		return nil, err
+	}
+	report.ProcessedFiles = c.ProcessedFiles
+
+	for _, f := range c.UnknownFiles {
+		report.add(VerifyIssueOrphan, f, "",
+			"file is not a package tarball or metadata")
+	}
+
+	// Tarballs with a valid metadata.
+	tarballs := make(map[string]*artifact.PackageArtifact, 0)
+
+	for _, m := range sortedMetaKeys(c.MetaMap) {
+		art := c.MetaMap[m]
+		pkg := art.CompileSpec.GetPackage().HumanReadableString()
+		tarball := filepath.Base(art.Path)
+
+		if _, ok := c.PkgsMap[tarball]; !ok {
+			report.add(VerifyIssueOrphan, m, pkg,
+				fmt.Sprintf("tarball %s not found", tarball))
+			continue
+		}
+
+		tarballs[tarball] = art
+		report.Packages++
+
+		if !c.CheckTarballs {
+			continue
+		}
+
+		if c.Verbose {
+			InfoC(fmt.Sprintf("[%s] Checking tarball...", tarball))
+		} else {
+			DebugC(fmt.Sprintf("[%s] Checking tarball...", tarball))
+		}
+
+		expected, ok := art.Checksums[string(artifact.SHA256)]
+		if !ok || expected == "" {
+			report.add(VerifyIssueCorrupted, tarball, pkg,
+				"no checksum available in the metadata")
+			continue
+		}
+
+		sum, err := c.FileSha256(tarball)
+		if err != nil {
+			report.add(VerifyIssueCorrupted, tarball, pkg,
+				"error on read tarball: "+err.Error())
+			continue
+		}
+
+		if sum != expected {
+			report.add(VerifyIssueCorrupted, tarball, pkg,
+				fmt.Sprintf("checksum mismatch: expected %s, found %s",
+					expected, sum))
+		}
+	}
+
+	pkgs := []string{}
+	for f := range c.PkgsMap {
+		pkgs = append(pkgs, f)
+	}
+	sort.Strings(pkgs)
+
+	for _, f := range pkgs {
+		if _, ok := c.MetaMap[c.PkgsMap[f]]; !ok {
+			report.add(VerifyIssueOrphan, f, "",
+				fmt.Sprintf("metadata %s not found", c.PkgsMap[f]))
+		}
+	}
+
+	err = c.verifyIndex(mFiles, tarballs, report)
+	if err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+func (c *RepoVerifier) verifyIndex(files map[string]bool,
+	tarballs map[string]*artifact.PackageArtifact, report *VerifyReport) error {
+
+	specfile := luet_installer.REPOSITORY_SPECFILE
+	if _, ok := files[specfile]; !ok {
+		report.add(VerifyIssueIndexMismatch, specfile, "", "file not found")
+		return nil
+	}
+
+	tmpdir, err := ioutil.TempDir("", "repo-devkit")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpdir)
+
+	repo, err := c.ReadRepositorySpec(tmpdir)
+	if err != nil {
+		report.add(VerifyIssueIndexMismatch, specfile, "", err.Error())
+		return nil
+	}
+
+	keys := []string{}
+	for key := range repo.RepositoryFiles {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		rf := repo.RepositoryFiles[key]
+		if _, ok := files[rf.GetFileName()]; !ok {
+			report.add(VerifyIssueIndexMismatch, rf.GetFileName(), "",
+				fmt.Sprintf("file of the key %s not found", key))
+			continue
+		}
+
+		expected, ok := rf.GetChecksums()[string(artifact.SHA256)]
+		if !ok {
+			continue
+		}
+
+		sum, err := c.FileSha256(rf.GetFileName())
+		if err != nil {
+			report.add(VerifyIssueCorrupted, rf.GetFileName(), "",
+				"error on read file: "+err.Error())
+			continue
+		}
+		if sum != expected {
+			report.add(VerifyIssueCorrupted, rf.GetFileName(), "",
+				fmt.Sprintf("checksum mismatch: expected %s, found %s",
+					expected, sum))
+			delete(repo.RepositoryFiles, key)
+		}
+	}
+
+	rf, ok := repo.RepositoryFiles[luet_installer.REPOFILE_META_KEY]
+	if !ok || !files[rf.GetFileName()] {
+		report.add(VerifyIssueIndexMismatch, specfile, "",
+			"repository metadata not available")
+		return nil
+	}
+
+	meta, err := c.ReadRepositoryMeta(rf, tmpdir)
+	if err != nil {
+		report.add(VerifyIssueIndexMismatch, rf.GetFileName(), "", err.Error())
+		return nil
+	}
+
+	indexed := make(map[string]bool, len(meta.Index))
+	for _, a := range meta.Index {
+		tarball := filepath.Base(a.Path)
+		pkg := ""
+		if a.CompileSpec != nil && a.CompileSpec.GetPackage() != nil {
+			pkg = a.CompileSpec.GetPackage().HumanReadableString()
+		}
+		indexed[tarball] = true
+
+		art, ok := tarballs[tarball]
+		if !ok {
+			report.add(VerifyIssueIndexMismatch, tarball, pkg,
+				"indexed package without tarball or metadata")
+			continue
+		}
+
+		if a.Checksums[string(artifact.SHA256)] != art.Checksums[string(artifact.SHA256)] {
+			report.add(VerifyIssueIndexMismatch, tarball, pkg,
+				"checksum of the index differs from the metadata")
+		}
+	}
+
+	for _, tarball := range sortedMetaKeys(tarballs) {
+		if _, ok := indexed[tarball]; !ok {
+			report.add(VerifyIssueIndexMismatch, tarball,
+				tarballs[tarball].CompileSpec.GetPackage().HumanReadableString(),
+				"package not available in the repository index")
+		}
+	}
+
+	return nil
+}
+
+// DownloadFile copies the file of the backend to the local path dst.
+func (c *RepoKnife) DownloadFile(file, dst string) error {
+	reader, err := c.BackendHandler.OpenFile(file)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, reader)
+	return err
+}
+
+// FileSha256 returns the sha256 checksum of the file of the backend.
+func (c *RepoKnife) FileSha256(file string) (string, error) {
+	reader, err := c.BackendHandler.OpenFile(file)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+
+	hasher := sha256.New()
+	if _, err = io.Copy(hasher, reader); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%x", hasher.Sum(nil)), nil
+}
+
+// ReadRepositorySpec reads the repository.yaml file of the backend
+// using tmpdir as work directory.
+func (c *RepoKnife) ReadRepositorySpec(tmpdir string) (*luet_installer.LuetSystemRepository, error) {
+	specfile := filepath.Join(tmpdir, luet_installer.REPOSITORY_SPECFILE)
+
+	err := c.DownloadFile(luet_installer.REPOSITORY_SPECFILE, specfile)
+	if err != nil {
+		return nil, errors.New(
+			"Error on download repository spec: " + err.Error())
+	}
+
+	content, err := ioutil.ReadFile(specfile)
+	if err != nil {
+		return nil, err
+	}
+
+	repo, err := luet_installer.NewLuetSystemRepositoryFromYaml(content,
+		luet_pkg.NewInMemoryDatabase(false))
+	if err != nil {
+		return nil, errors.New(
+			"Error on parse repository spec: " + err.Error())
+	}
+
+	if repo.RepositoryFiles == nil {
+		repo.RepositoryFiles = make(map[string]luet_installer.LuetRepositoryFile, 0)
+	}
+
+	return repo, nil
+}
+
+// ReadRepositoryMeta downloads and unpacks the repository metadata tarball
+// using tmpdir as work directory.
+func (c *RepoKnife) ReadRepositoryMeta(rf luet_installer.LuetRepositoryFile,
+	tmpdir string) (*luet_installer.LuetSystemRepositoryMetadata, error) {
+
+	metafile := filepath.Join(tmpdir, rf.GetFileName())
+	err := c.DownloadFile(rf.GetFileName(), metafile)
+	if err != nil {
+		return nil, errors.New(
+			"Error on download repository metadata: " + err.Error())
+	}
+
+	// The unpack without same owner doesn't create the directory.
+	unpackdir := filepath.Join(tmpdir, "meta")
+	err = os.MkdirAll(unpackdir, os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+
+	a := artifact.NewPackageArtifact(metafile)
+	a.CompressionType = rf.GetCompressionType()
+	err = a.Unpack(unpackdir, false)
+	if err != nil {
+		return nil, errors.New(
+			"Error on unpack repository metadata: " + err.Error())
+	}
+
+	return luet_installer.NewLuetSystemRepositoryMetadata(
+		filepath.Join(unpackdir, luet_installer.REPOSITORY_METAFILE), false,
+	)
+}
+
+func sortedMetaKeys(m map[string]*artifact.PackageArtifact) []string {
+	ans := []string{}
+	for k := range m {
+		ans = append(ans, k)
+	}
+	sort.Strings(ans)
+	return ans
+}
@@ -0,0 +1,376 @@
+/*
+Copyright (C) 2020-2021  Daniele Rondina <geaaru@sabayonlinux.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+*/
+
+package filter
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	luet_pkg "github.com/mudler/luet/pkg/package"
+	luet_version "github.com/mudler/luet/pkg/versioner"
+)
+
+// Filter is a compiled filter expression. The expressions have
+// the format:
+//
+//	category =~ "^dev-" and version >= 1.2 and label.arch == "amd64" and not annotation.hidden
+//
+// The supported fields are name, category, version, package
+// (category/name), description, license, hidden, label.<key> and
+// annotation.<key>. The supported operators are ==, !=, =~, !~, >=,
+// <=, > and <. The version field is compared with the luet version
+// selectors. A field without operator is true when it's not empty
+// and not "false". The conditions could be combined with and, or,
+// not and parenthesis.
+type Filter struct {
+	Expression string
+	root       node
+}
+
+type node interface {
+	eval(p *luet_pkg.DefaultPackage) (bool, error)
+}
+
+type andNode struct{ left, right node }
+type orNode struct{ left, right node }
+type notNode struct{ expr node }
+
+type fieldNode struct {
+	field string
+}
+
+type compareNode struct {
+	field string
+	op    string
+	value string
+	re    *regexp.Regexp
+}
+
+// Parse compiles the filter expression.
+func Parse(expr string) (*Filter, error) {
+	tokens, err := tokenize(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, errors.New(
+			fmt.Sprintf("Invalid filter expression '%s': %s", expr, err.Error()))
+	}
+
+	if p.peek().Type != tokenEOF {
+		return nil, errors.New(
+			fmt.Sprintf("Invalid filter expression '%s': unexpected '%s' at position %d",
+				expr, p.peek().Value, p.peek().Pos))
+	}
+
+	return &Filter{Expression: expr, root: root}, nil
+}
+
+// ParseAll compiles a list of filter expressions.
+func ParseAll(exprs []string) ([]*Filter, error) {
+	ans := []*Filter{}
+	for _, e := range exprs {
+		f, err := Parse(e)
+		if err != nil {
+			return nil, err
+		}
+		ans = append(ans, f)
+	}
+	return ans, nil
+}
+
+// Match returns true if the package matches the filter.
+func (f *Filter) Match(p *luet_pkg.DefaultPackage) (bool, error) {
+	return f.root.eval(p)
+}
+
+func (f *Filter) String() string { return f.Expression }
+
+// MatchAny returns true if the package matches at least
+// one of the filters.
+func MatchAny(filters []*Filter, p *luet_pkg.DefaultPackage) (bool, error) {
+	for _, f := range filters {
+		match, err := f.Match(p)
+		if err != nil {
+			return false, err
+		}
+		if match {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token { return p.tokens[p.pos] }
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.Type != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) isKeyword(k string) bool {
+	t := p.peek()
+	return t.Type == tokenWord && strings.ToLower(t.Value) == k
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.isKeyword("or") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &orNode{left, right}
+	}
+
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.isKeyword("and") {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &andNode{left, right}
+	}
+
+	return left, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.isKeyword("not") {
+		p.next()
+		expr, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{expr}, nil
+	}
+
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.next()
+
+	switch t.Type {
+	case tokenLParen:
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek().Type != tokenRParen {
+			return nil, errors.New(
+				fmt.Sprintf("missing ')' at position %d", p.peek().Pos))
+		}
+		p.next()
+		return expr, nil
+
+	case tokenWord:
+		if err := validateField(t.Value); err != nil {
+			return nil, err
+		}
+
+		if p.peek().Type != tokenOp {
+			return &fieldNode{field: t.Value}, nil
+		}
+		op := p.next().Value
+
+		v := p.next()
+		if v.Type != tokenWord && v.Type != tokenString {
+			return nil, errors.New(
+				fmt.Sprintf("missing value for the operator %s at position %d", op, v.Pos))
+		}
+
+		return newCompareNode(t.Value, op, v.Value)
+
+	case tokenEOF:
+		return nil, errors.New("unexpected end of the expression")
+	}
+
+	return nil, errors.New(
+		fmt.Sprintf("unexpected '%s' at position %d", t.Value, t.Pos))
+}
+
+func validateField(field string) error {
+	switch field {
+	case "name", "category", "version", "package", "description", "license", "hidden":
+		return nil
+	}
+
+	for _, prefix := range []string{"label.", "annotation."} {
+		if strings.HasPrefix(field, prefix) && len(field) > len(prefix) {
+			return nil
+		}
+	}
+
+	return errors.New("unknown field " + field)
+}
+
+func newCompareNode(field, op, value string) (*compareNode, error) {
+	ans := &compareNode{field: field, op: op, value: value}
+
+	switch op {
+	case "=~", "!~":
+		re, err := regexp.Compile(value)
+		if err != nil {
+			return nil, errors.New(
+				fmt.Sprintf("invalid regex %s: %s", value, err.Error()))
+		}
+		ans.re = re
+
+	case ">=", "<=", ">", "<":
+		if field == "version" {
+			if _, err := luet_version.ParseVersion(op + value); err != nil {
+				return nil, errors.New(
+					fmt.Sprintf("invalid version selector %s%s: %s", op, value, err.Error()))
+			}
+		}
+	}
+
+	return ans, nil
+}
+
+func fieldValue(p *luet_pkg.DefaultPackage, field string) string {
+	switch field {
+	case "name":
+		return p.GetName()
+	case "category":
+		return p.GetCategory()
+	case "version":
+		return p.GetVersion()
+	case "package":
+		return fmt.Sprintf("%s/%s", p.GetCategory(), p.GetName())
+	case "description":
+		return p.GetDescription()
+	case "license":
+		return p.GetLicense()
+	case "hidden":
+		return strconv.FormatBool(p.IsHidden())
+	}
+
+	if strings.HasPrefix(field, "label.") {
+		return p.Labels[strings.TrimPrefix(field, "label.")]
+	}
+
+	return p.Annotations[strings.TrimPrefix(field, "annotation.")]
+}
+
+func (n *andNode) eval(p *luet_pkg.DefaultPackage) (bool, error) {
+	l, err := n.left.eval(p)
+	if err != nil || !l {
+		return false, err
+	}
+	return n.right.eval(p)
+}
+
+func (n *orNode) eval(p *luet_pkg.DefaultPackage) (bool, error) {
+	l, err := n.left.eval(p)
+	if err != nil || l {
+		return l, err
+	}
+	return n.right.eval(p)
+}
+
+func (n *notNode) eval(p *luet_pkg.DefaultPackage) (bool, error) {
+	v, err := n.expr.eval(p)
+	return !v, err
+}
+
+func (n *fieldNode) eval(p *luet_pkg.DefaultPackage) (bool, error) {
+	v := fieldValue(p, n.field)
+	return v != "" && v != "false", nil
+}
+
+func (n *compareNode) eval(p *luet_pkg.DefaultPackage) (bool, error) {
+	v := fieldValue(p, n.field)
+
+	switch n.op {
+	case "==":
+		return v == n.value, nil
+	case "!=":
+		return v != n.value, nil
+	case "=~":
+		return n.re.MatchString(v), nil
+	case "!~":
+		return !n.re.MatchString(v), nil
+	}
+
+	if n.field == "version" {
+		selector, _ := luet_version.ParseVersion(n.op + n.value)
+		pSelector, err := luet_version.ParseVersion(v)
+		if err != nil {
+			return false, errors.New(fmt.Sprintf(
+				"Error on create package selector for package %s: %s",
+				p.HumanReadableString(), err.Error()))
+		}
+
+		return luet_version.PackageAdmit(selector, pSelector)
+	}
+
+	cmp := strings.Compare(v, n.value)
+	if fv, err := strconv.ParseFloat(v, 64); err == nil {
+		if fvalue, err := strconv.ParseFloat(n.value, 64); err == nil {
+			switch {
+			case fv < fvalue:
+				cmp = -1
+			case fv > fvalue:
+				cmp = 1
+			default:
+				cmp = 0
+			}
+		}
+	}
+
+	switch n.op {
+	case ">=":
+		return cmp >= 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	}
+	return cmp < 0, nil
+}
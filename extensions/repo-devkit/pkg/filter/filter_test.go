@@ -0,0 +1,118 @@
+/*
+Copyright (C) 2020-2021  Daniele Rondina <geaaru@sabayonlinux.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+*/
+
+package filter_test
+
+import (
+	. "github.com/Luet-lab/extensions/extensions/repo-devkit/pkg/filter"
+
+	luet_pkg "github.com/mudler/luet/pkg/package"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Filter", func() {
+	p := &luet_pkg.DefaultPackage{
+		Name:        "go",
+		Category:    "dev-lang",
+		Version:     "1.16.3",
+		Description: `The "Go" language`,
+		License:     "BSD",
+		Labels:      map[string]string{"arch": "amd64"},
+		Annotations: map[string]string{"kit": "stable"},
+	}
+
+	DescribeTable("Match",
+		func(expr string, expected bool) {
+			f, err := Parse(expr)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(f.Match(p)).To(Equal(expected))
+		},
+
+		Entry("equal", `name == "go"`, true),
+		Entry("not equal", `name != go`, false),
+		Entry("package field", `package == "dev-lang/go"`, true),
+		Entry("regex", `category =~ "^dev-"`, true),
+		Entry("negated regex", `category !~ "^dev-"`, false),
+		Entry("label", `label.arch == "amd64"`, true),
+		Entry("annotation", `annotation.kit == stable`, true),
+
+		// and binds tighter than or
+		Entry("and before or", `name == "go" or name == "foo" and category == "x"`, true),
+		Entry("parenthesis", `(name == "go" or name == "foo") and category == "x"`, false),
+		Entry("and chain", `name == "go" and category == "dev-lang" and license == "BSD"`, true),
+		Entry("case insensitive keywords", `name == "go" AND category == "dev-lang"`, true),
+
+		// not binds tighter than and/or
+		Entry("not", `not name == "go"`, false),
+		Entry("not before or", `not name == "go" or category == "dev-lang"`, true),
+		Entry("not with parenthesis", `not (name == "go" or category == "dev-lang")`, false),
+		Entry("double not", `not not name == "go"`, true),
+
+		// The version is compared with the version selectors
+		Entry("version greater", `version > 1.9`, true),
+		Entry("version greater or equal", `version >= 1.16.3`, true),
+		Entry("version less", `version < 1.9`, false),
+		Entry("version less or equal", `version <= 1.16.2`, false),
+
+		Entry("field set", `label.arch`, true),
+		Entry("field not set", `label.missing`, false),
+		Entry("false field", `hidden`, false),
+		Entry("not false field", `not hidden`, true),
+
+		Entry("double quoted string", `description == "The \"Go\" language"`, true),
+		Entry("single quoted string", `description == 'The "Go" language'`, true),
+		Entry("quoted operators", `description =~ "\"Go\""`, true),
+	)
+
+	DescribeTable("Parse errors",
+		func(expr, msg string) {
+			_, err := Parse(expr)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring(msg))
+		},
+
+		Entry("empty expression", ``, "unexpected end of the expression"),
+		Entry("missing value", `name ==`, "missing value for the operator == at position 7"),
+		Entry("missing parenthesis", `(name == "go"`, "missing ')' at position 13"),
+		Entry("extra parenthesis", `name == "go")`, "unexpected ')' at position 12"),
+		Entry("trailing condition", `name == "go" category`, "unexpected 'category' at position 13"),
+		Entry("unknown field", `foo == "x"`, "unknown field foo"),
+		Entry("empty label", `label. == "x"`, "unknown field label."),
+		Entry("unterminated string", `name == "go`, "Unterminated string at position 8"),
+		Entry("unexpected character", `name ~ "go"`, "Unexpected character '~' at position 5"),
+		Entry("invalid regex", `name =~ "("`, "invalid regex ("),
+		Entry("missing operand", `name == "go" and`, "unexpected end of the expression"),
+	)
+
+	It("Matches any filter", func() {
+		filters, err := ParseAll([]string{`name == "foo"`, `category == "dev-lang"`})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(MatchAny(filters, p)).To(BeTrue())
+
+		filters, err = ParseAll([]string{`name == "foo"`})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(MatchAny(filters, p)).To(BeFalse())
+	})
+
+	It("Fails on the first invalid expression", func() {
+		_, err := ParseAll([]string{`name == "foo"`, `name ==`})
+		Expect(err).To(HaveOccurred())
+	})
+})
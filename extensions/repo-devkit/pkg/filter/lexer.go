@@ -0,0 +1,124 @@
+/*
+Copyright (C) 2020-2021  Daniele Rondina <geaaru@sabayonlinux.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+*/
+
+package filter
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type tokenType int
+
+const (
+	tokenEOF tokenType = iota
+	tokenLParen
+	tokenRParen
+	tokenOp
+	tokenString
+	tokenWord
+)
+
+type token struct {
+	Type  tokenType
+	Value string
+	Pos   int
+}
+
+var operators = []string{"==", "!=", "=~", "!~", ">=", "<=", ">", "<"}
+
+func isWordRune(r rune) bool {
+	return !unicode.IsSpace(r) && !strings.ContainsRune("()\"'=!<>~", r)
+}
+
+func tokenize(expr string) ([]token, error) {
+	ans := []token{}
+	runes := []rune(expr)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+
+		switch {
+		case unicode.IsSpace(r):
+			i++
+
+		case r == '(':
+			ans = append(ans, token{Type: tokenLParen, Value: "(", Pos: i})
+			i++
+
+		case r == ')':
+			ans = append(ans, token{Type: tokenRParen, Value: ")", Pos: i})
+			i++
+
+		case r == '"' || r == '\'':
+			start := i
+			i++
+			for i < len(runes) && runes[i] != r {
+				if runes[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			if i >= len(runes) {
+				return nil, errors.New(
+					fmt.Sprintf("Unterminated string at position %d", start))
+			}
+			i++
+
+			raw := string(runes[start:i])
+			if r == '\'' {
+				raw = "\"" + strings.ReplaceAll(raw[1:len(raw)-1], "\"", "\\\"") + "\""
+			}
+			v, err := strconv.Unquote(raw)
+			if err != nil {
+				return nil, errors.New(
+					fmt.Sprintf("Invalid string at position %d: %s", start, err.Error()))
+			}
+			ans = append(ans, token{Type: tokenString, Value: v, Pos: start})
+
+		case isWordRune(r):
+			start := i
+			for i < len(runes) && isWordRune(runes[i]) {
+				i++
+			}
+			ans = append(ans, token{Type: tokenWord, Value: string(runes[start:i]), Pos: start})
+
+		default:
+			op := ""
+			for _, o := range operators {
+				if strings.HasPrefix(string(runes[i:]), o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return nil, errors.New(
+					fmt.Sprintf("Unexpected character '%c' at position %d", r, i))
+			}
+			ans = append(ans, token{Type: tokenOp, Value: op, Pos: i})
+			i += len(op)
+		}
+	}
+
+	ans = append(ans, token{Type: tokenEOF, Pos: len(runes)})
+
+	return ans, nil
+}
@@ -21,6 +21,10 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"os"
+	"strings"
+
+	filter "github.com/Luet-lab/extensions/extensions/repo-devkit/pkg/filter"
 
 	. "github.com/mudler/luet/pkg/logger"
 	luet_pkg "github.com/mudler/luet/pkg/package"
@@ -39,12 +43,82 @@ func NewLuetRDConfig() *LuetRDConfig {
 func (c *LuetRDConfig) GetCleaner() *LuetRDCCleaner { return &c.Cleaner }
 func (c *LuetRDConfig) GetList() *LuetRDCList       { return &c.List }
 
+func (c *LuetRDConfig) GetProfile(name string) (*LuetRDCProfile, error) {
+	p, ok := c.Profiles[name]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("Profile %s not found", name))
+	}
+	return &p, nil
+}
+
 func (c *LuetRDCCleaner) HasExcludes() bool {
 	return len(c.Excludes) > 0
 }
 
+func (c *LuetRDCCleaner) GetTrashDir() string {
+	if c.TrashDir == "" {
+		return ".trash"
+	}
+	return c.TrashDir
+}
+
 func (c *LuetRDCList) HasFilters() bool {
-	return len(c.ExcludePkgs) > 0
+	return len(c.ExcludePkgs) > 0 || len(c.excludeFilters) > 0
+}
+
+// AddExcludeFilters compiles and adds the filter expressions
+// of the packages to ignore.
+func (c *LuetRDCList) AddExcludeFilters(exprs []string) error {
+	filters, err := filter.ParseAll(exprs)
+	if err != nil {
+		return err
+	}
+	c.excludeFilters = append(c.excludeFilters, filters...)
+	return nil
+}
+
+// AddExcludeFilters compiles and adds the filter expressions
+// of the packages to keep on clean.
+func (c *LuetRDCCleaner) AddExcludeFilters(exprs []string) error {
+	filters, err := filter.ParseAll(exprs)
+	if err != nil {
+		return err
+	}
+	c.excludeFilters = append(c.excludeFilters, filters...)
+	return nil
+}
+
+// ToKeep returns true if the package matches one of the
+// exclude filters of the cleaner.
+func (c *LuetRDCCleaner) ToKeep(pkg *luet_pkg.DefaultPackage) bool {
+	match, err := filter.MatchAny(c.excludeFilters, pkg)
+	if err != nil {
+		// POST: on error the package is kept.
+		Warning(err.Error())
+		return true
+	}
+	return match
+}
+
+// NewLuetPackageFromString parses a package selector in the
+// format category/name or category/name@version-selector.
+func NewLuetPackageFromString(s string) (*LuetPackage, error) {
+	ans := &LuetPackage{Version: ">=0"}
+
+	if strings.Contains(s, "@") {
+		fields := strings.SplitN(s, "@", 2)
+		s = fields[0]
+		ans.Version = fields[1]
+	}
+
+	fields := strings.Split(s, "/")
+	if len(fields) != 2 || fields[0] == "" || fields[1] == "" {
+		return nil, errors.New("Invalid package selector " + s)
+	}
+	ans.Category = fields[0]
+	ans.Name = fields[1]
+
+	return ans, nil
 }
 
 func (c *LuetPackage) GetName() string     { return c.Name }
@@ -58,7 +132,7 @@ func (c *LuetRDCList) ToIgnore(pkg *luet_pkg.DefaultPackage) bool {
 	ans := false
 
 	if c.HasFilters() {
-		pSelector, err := luet_version.ParseVersion(pkg.GetVersion())
+		_, err := luet_version.ParseVersion(pkg.GetVersion())
 		if err != nil {
 			Warning(fmt.Sprintf(
 				"Error on create package selector for package %s: %s",
@@ -67,34 +141,163 @@ func (c *LuetRDCList) ToIgnore(pkg *luet_pkg.DefaultPackage) bool {
 		}
 
 		for _, f := range c.ExcludePkgs {
-			if f.GetName() != pkg.GetName() ||
-				f.GetCategory() != pkg.GetCategory() {
+			admit, err := f.Admit(pkg)
+			if err != nil {
+				Warning(err.Error())
 				continue
 			}
 
-			selector, err := luet_version.ParseVersion(f.GetVersion())
+			if admit {
+				ans = true
+			}
+
+		}
+
+		if !ans {
+			match, err := filter.MatchAny(c.excludeFilters, pkg)
 			if err != nil {
-				Warning(fmt.Sprintf(
-					"Error on create version selector for package %s: %s",
-					f.HumanReadableString(), err.Error()))
-				continue
+				Warning(err.Error())
 			}
+			ans = match
+		}
+	}
 
-			admit, err := luet_version.PackageAdmit(selector, pSelector)
+	return ans
+}
+
+// Admit returns true if the package matches the name, the category
+// and the version selector of the LuetPackage.
+func (c *LuetPackage) Admit(pkg *luet_pkg.DefaultPackage) (bool, error) {
+	if c.GetName() != pkg.GetName() ||
+		c.GetCategory() != pkg.GetCategory() {
+		return false, nil
+	}
+
+	pSelector, err := luet_version.ParseVersion(pkg.GetVersion())
+	if err != nil {
+		return false, errors.New(fmt.Sprintf(
+			"Error on create package selector for package %s: %s",
+			pkg.HumanReadableString(), err.Error()))
+	}
+
+	selector, err := luet_version.ParseVersion(c.GetVersion())
+	if err != nil {
+		return false, errors.New(fmt.Sprintf(
+			"Error on create version selector for package %s: %s",
+			c.HumanReadableString(), err.Error()))
+	}
+
+	admit, err := luet_version.PackageAdmit(selector, pSelector)
+	if err != nil {
+		return false, errors.New(fmt.Sprintf("Error on check package %s: %s",
+			c.HumanReadableString(), err.Error()))
+	}
+
+	return admit, nil
+}
+
+// Resolve returns the value of the secret.
+func (s *LuetRDCSecret) Resolve() (string, error) {
+	if s.File != "" {
+		content, err := ioutil.ReadFile(s.File)
+		if err != nil {
+			return "", errors.New(
+				fmt.Sprintf("Error on read secret file %s: %s", s.File, err.Error()))
+		}
+		return strings.TrimSpace(string(content)), nil
+	}
+
+	if s.Env != "" {
+		v, ok := os.LookupEnv(s.Env)
+		if !ok {
+			return "", errors.New(
+				fmt.Sprintf("Environment variable %s not set", s.Env))
+		}
+		return v, nil
+	}
+
+	return s.Value, nil
+}
+
+// ToOptions returns the backend type, the path and the options
+// of the backend defined by the profile.
+func (p *LuetRDCProfile) ToOptions() (string, string, map[string]string, error) {
+	opts := make(map[string]string, 0)
+
+	switch p.Type {
+	case "local":
+	case "minio":
+		opts["minio-endpoint"] = p.Endpoint
+		opts["minio-bucket"] = p.Bucket
+		opts["minio-region"] = p.Region
+		if p.Ssl != nil && !*p.Ssl {
+			opts["minio-ssl"] = "false"
+		}
+		if p.Prefix != "" {
+			opts["minio-prefix"] = p.Prefix
+		}
+		if p.CaBundle != "" {
+			opts["minio-ca-bundle"] = p.CaBundle
+		}
+
+		for k, secret := range map[string]*LuetRDCSecret{
+			"minio-keyid":         &p.KeyId,
+			"minio-secret":        &p.Secret,
+			"minio-session-token": &p.SessionToken,
+		} {
+			v, err := secret.Resolve()
 			if err != nil {
-				Warning(fmt.Sprintf("Error on check package %s: %s",
-					f.HumanReadableString(), err.Error()))
-				continue
+				return "", "", nil, errors.New(
+					fmt.Sprintf("Error on resolve %s: %s", k, err.Error()))
+			}
+			if v != "" || k != "minio-session-token" {
+				opts[k] = v
 			}
+		}
 
-			if admit {
-				ans = true
+	case "blob":
+		opts["blob-url"] = p.Url
+		if p.Prefix != "" {
+			opts["blob-prefix"] = p.Prefix
+		}
+
+	case "mottainai":
+		for k, v := range map[string]string{
+			"mottainai-master":    p.Master,
+			"mottainai-namespace": p.Namespace,
+			"mottainai-profile":   p.MottainaiProfile,
+		} {
+			if v != "" {
+				opts[k] = v
 			}
+		}
 
+		apikey, err := p.ApiKey.Resolve()
+		if err != nil {
+			return "", "", nil, errors.New(
+				"Error on resolve mottainai-apikey: " + err.Error())
+		}
+		if apikey != "" {
+			opts["mottainai-apikey"] = apikey
 		}
+		if p.Retries != nil {
+			opts["mottainai-retries"] = fmt.Sprintf("%d", *p.Retries)
+		}
+
+	default:
+		return "", "", nil, errors.New("Invalid profile type " + p.Type)
 	}
 
-	return ans
+	return p.Type, p.Path, opts, nil
+}
+
+// CacheKey returns the key used to identify a specific
+// revision of the file.
+func (i *RepoFileInfo) CacheKey() string {
+	if i.ETag != "" {
+		return i.ETag
+	}
+	return fmt.Sprintf("%d-%d", i.Size, i.ModTime.UnixNano())
 }
 
 func SpecsFromYaml(data []byte) (*LuetRDConfig, error) {
@@ -102,6 +305,14 @@ func SpecsFromYaml(data []byte) (*LuetRDConfig, error) {
 	if err := yaml.Unmarshal(data, ans); err != nil {
 		return nil, err
 	}
+
+	if err := ans.Cleaner.AddExcludeFilters(ans.Cleaner.ExcludeFilters); err != nil {
+		return nil, errors.New("Invalid cleaner exclude_filters: " + err.Error())
+	}
+	if err := ans.List.AddExcludeFilters(ans.List.ExcludeFilters); err != nil {
+		return nil, errors.New("Invalid list exclude_filters: " + err.Error())
+	}
+
 	return ans, nil
 }
 
@@ -0,0 +1,150 @@
+/*
+Copyright (C) 2020-2021  Daniele Rondina <geaaru@sabayonlinux.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+*/
+
+package specs_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/Luet-lab/extensions/extensions/repo-devkit/pkg/specs"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Profiles", func() {
+	var tmpdir string
+
+	BeforeEach(func() {
+		var err error
+		tmpdir, err = ioutil.TempDir("", "repo-devkit-specs")
+		Expect(err).ToNot(HaveOccurred())
+		os.Setenv("REPO_DEVKIT_TEST_SECRET", "env-secret")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tmpdir)
+		os.Unsetenv("REPO_DEVKIT_TEST_SECRET")
+	})
+
+	Context("Secrets", func() {
+
+		It("Reads the value", func() {
+			s := LuetRDCSecret{Value: "value,with,commas"}
+			Expect(s.Resolve()).To(Equal("value,with,commas"))
+		})
+
+		It("Reads the environment variable", func() {
+			s := LuetRDCSecret{Env: "REPO_DEVKIT_TEST_SECRET", Value: "ignored"}
+			Expect(s.Resolve()).To(Equal("env-secret"))
+		})
+
+		It("Reads the file", func() {
+			f := filepath.Join(tmpdir, "secret")
+			Expect(ioutil.WriteFile(f, []byte("file-secret\n"), 0600)).To(Succeed())
+
+			s := LuetRDCSecret{File: f, Env: "REPO_DEVKIT_TEST_SECRET"}
+			Expect(s.Resolve()).To(Equal("file-secret"))
+		})
+
+		It("Fails on missing environment variable", func() {
+			s := LuetRDCSecret{Env: "REPO_DEVKIT_TEST_MISSING"}
+			_, err := s.Resolve()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("REPO_DEVKIT_TEST_MISSING"))
+		})
+
+		It("Fails on unreadable file", func() {
+			s := LuetRDCSecret{File: filepath.Join(tmpdir, "missing")}
+			_, err := s.Resolve()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("missing"))
+		})
+	})
+
+	Context("Options", func() {
+
+		It("Converts a minio profile", func() {
+			ssl := false
+			p := LuetRDCProfile{
+				Type:     "minio",
+				Path:     "/repo",
+				Endpoint: "minio:9000",
+				Bucket:   "stable",
+				Prefix:   "x86_64/",
+				Ssl:      &ssl,
+				KeyId:    LuetRDCSecret{Value: "id"},
+				Secret:   LuetRDCSecret{Env: "REPO_DEVKIT_TEST_SECRET"},
+			}
+
+			backend, path, opts, err := p.ToOptions()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(backend).To(Equal("minio"))
+			Expect(path).To(Equal("/repo"))
+			Expect(opts).To(Equal(map[string]string{
+				"minio-endpoint": "minio:9000",
+				"minio-bucket":   "stable",
+				"minio-region":   "",
+				"minio-prefix":   "x86_64/",
+				"minio-ssl":      "false",
+				"minio-keyid":    "id",
+				"minio-secret":   "env-secret",
+			}))
+		})
+
+		It("Converts a mottainai profile", func() {
+			retries := 5
+			p := LuetRDCProfile{
+				Type:      "mottainai",
+				Master:    "https://mottainai",
+				Namespace: "stable",
+				ApiKey:    LuetRDCSecret{Env: "REPO_DEVKIT_TEST_SECRET"},
+				Retries:   &retries,
+			}
+
+			_, _, opts, err := p.ToOptions()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(opts).To(Equal(map[string]string{
+				"mottainai-master":    "https://mottainai",
+				"mottainai-namespace": "stable",
+				"mottainai-apikey":    "env-secret",
+				"mottainai-retries":   "5",
+			}))
+		})
+
+		It("Reports the option of the missing secret", func() {
+			p := LuetRDCProfile{
+				Type:   "minio",
+				Secret: LuetRDCSecret{Env: "REPO_DEVKIT_TEST_MISSING"},
+			}
+
+			_, _, _, err := p.ToOptions()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("minio-secret"))
+			Expect(err.Error()).To(ContainSubstring("REPO_DEVKIT_TEST_MISSING"))
+		})
+
+		It("Fails on invalid type", func() {
+			p := LuetRDCProfile{Type: "ftp"}
+			_, _, _, err := p.ToOptions()
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -18,20 +18,68 @@ along with this program. If not, see <http://www.gnu.org/licenses/>.
 package specs
 
 import (
+	"io"
+	"time"
+
+	filter "github.com/Luet-lab/extensions/extensions/repo-devkit/pkg/filter"
+
 	artifact "github.com/mudler/luet/pkg/compiler/types/artifact"
 )
 
 type LuetRDConfig struct {
-	Cleaner LuetRDCCleaner `json:"cleaner,omitempty" yaml:"cleaner,omitempty"`
-	List    LuetRDCList    `json:"list,omitempty" yaml:"list,omitempty"`
+	Cleaner  LuetRDCCleaner            `json:"cleaner,omitempty" yaml:"cleaner,omitempty"`
+	List     LuetRDCList               `json:"list,omitempty" yaml:"list,omitempty"`
+	Profiles map[string]LuetRDCProfile `json:"profiles,omitempty" yaml:"profiles,omitempty"`
+}
+
+type LuetRDCProfile struct {
+	Type string `json:"type" yaml:"type"`
+	Path string `json:"path,omitempty" yaml:"path,omitempty"`
+
+	// Minio options
+	Endpoint string        `json:"endpoint,omitempty" yaml:"endpoint,omitempty"`
+	Bucket   string        `json:"bucket,omitempty" yaml:"bucket,omitempty"`
+	Region   string        `json:"region,omitempty" yaml:"region,omitempty"`
+	Prefix   string        `json:"prefix,omitempty" yaml:"prefix,omitempty"`
+	Ssl      *bool         `json:"ssl,omitempty" yaml:"ssl,omitempty"`
+	CaBundle string        `json:"ca_bundle,omitempty" yaml:"ca_bundle,omitempty"`
+	KeyId    LuetRDCSecret `json:"keyid,omitempty" yaml:"keyid,omitempty"`
+	Secret   LuetRDCSecret `json:"secret,omitempty" yaml:"secret,omitempty"`
+
+	SessionToken LuetRDCSecret `json:"session_token,omitempty" yaml:"session_token,omitempty"`
+
+	// Blob options. The prefix is shared with minio.
+	Url string `json:"url,omitempty" yaml:"url,omitempty"`
+
+	// Mottainai options
+	Master           string        `json:"master,omitempty" yaml:"master,omitempty"`
+	Namespace        string        `json:"namespace,omitempty" yaml:"namespace,omitempty"`
+	MottainaiProfile string        `json:"mottainai_profile,omitempty" yaml:"mottainai_profile,omitempty"`
+	ApiKey           LuetRDCSecret `json:"apikey,omitempty" yaml:"apikey,omitempty"`
+	Retries          *int          `json:"retries,omitempty" yaml:"retries,omitempty"`
+}
+
+// LuetRDCSecret defines a value read from an environment
+// variable or from a file to avoid secrets in the specs file.
+type LuetRDCSecret struct {
+	Value string `json:"value,omitempty" yaml:"value,omitempty"`
+	Env   string `json:"env,omitempty" yaml:"env,omitempty"`
+	File  string `json:"file,omitempty" yaml:"file,omitempty"`
 }
 
 type LuetRDCCleaner struct {
-	Excludes []string `json:"excludes,omitempty" yaml:"excludes,omitempty"`
+	Excludes       []string `json:"excludes,omitempty" yaml:"excludes,omitempty"`
+	ExcludeFilters []string `json:"exclude_filters,omitempty" yaml:"exclude_filters,omitempty"`
+	TrashDir       string   `json:"trash_dir,omitempty" yaml:"trash_dir,omitempty"`
+
+	excludeFilters []*filter.Filter
 }
 
 type LuetRDCList struct {
-	ExcludePkgs []LuetPackage `json:"exclude_pkgs,omitempty" yaml:"exclude_pkgs,omitempty"`
+	ExcludePkgs    []LuetPackage `json:"exclude_pkgs,omitempty" yaml:"exclude_pkgs,omitempty"`
+	ExcludeFilters []string      `json:"exclude_filters,omitempty" yaml:"exclude_filters,omitempty"`
+
+	excludeFilters []*filter.Filter
 }
 
 type LuetPackage struct {
@@ -40,8 +88,28 @@ type LuetPackage struct {
 	Version  string `json:"version" yaml:"version"`
 }
 
+type RepoFileInfo struct {
+	Name        string    `json:"name" yaml:"name"`
+	Size        int64     `json:"size" yaml:"size"`
+	ModTime     time.Time `json:"mtime" yaml:"mtime"`
+	ETag        string    `json:"etag,omitempty" yaml:"etag,omitempty"`
+	ContentType string    `json:"content_type,omitempty" yaml:"content_type,omitempty"`
+}
+
 type RepoBackendHandler interface {
 	GetFilesList() ([]string, error)
 	GetMetadata(string) (*artifact.PackageArtifact, error)
 	CleanFile(string) error
+	MoveFile(string, string) error
+	OpenFile(string) (io.ReadCloser, error)
+	// UploadFile uploads the local file (first argument) to
+	// the backend path (second argument).
+	UploadFile(string, string) error
+	// GetFileInfo returns nil without error if the backend
+	// doesn't support file informations.
+	GetFileInfo(string) (*RepoFileInfo, error)
+	// GetFilesInfo returns the informations of the files of
+	// GetFilesList. If the backend doesn't support file informations
+	// only the name is available.
+	GetFilesInfo() ([]*RepoFileInfo, error)
 }